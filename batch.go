@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputError is an error that occurred while opening or reading an input file
+type inputError struct {
+	err error
+}
+
+func (e inputError) Error() string {
+	return e.err.Error()
+}
+
+func (e inputError) Unwrap() error {
+	return e.err
+}
+
+// skipError is returned for files that are not converted because they are not
+// suitable for conversion, or because the output file already exists
+type skipError struct {
+	err error
+}
+
+func (e skipError) Error() string {
+	return e.err.Error()
+}
+
+func (e skipError) Unwrap() error {
+	return e.err
+}
+
+// summary counts the outcome of each file in a batch
+type summary struct {
+	converted  int
+	skipped    int
+	unreadable int
+	failed     int
+}
+
+// the outcome of processing a single file
+const (
+	statusConverted  = "converted"
+	statusSkipped    = "skipped"
+	statusUnreadable = "unreadable"
+	statusFailed     = "failed"
+)
+
+// status returns the outcome of processing a file that resulted in the error
+func status(err error) string {
+	var inputErr inputError
+	var skipErr skipError
+	switch {
+	case err == nil:
+		return statusConverted
+	case errors.As(err, &inputErr):
+		return statusUnreadable
+	case errors.As(err, &skipErr):
+		return statusSkipped
+	}
+	return statusFailed
+}
+
+// add the outcome of processing a single file to the summary
+func (sum *summary) add(err error) {
+	switch status(err) {
+	case statusConverted:
+		sum.converted++
+	case statusUnreadable:
+		sum.unreadable++
+	case statusSkipped:
+		sum.skipped++
+	default:
+		sum.failed++
+	}
+}
+
+// retryFiles returns the files in a manifest that should be converted again.
+// the manifest is the output of a previous run with the -json flag. files that
+// failed or could not be read are retried. skipped files are not retried
+func retryFiles(manifest string) ([]string, error) {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	dec := json.NewDecoder(f)
+	for {
+		var res jsonResult
+		err := dec.Decode(&res)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(manifest), err)
+		}
+
+		// manifests written before the status field was added only have
+		// the error field
+		switch res.Status {
+		case statusFailed, statusUnreadable:
+			files = append(files, res.File)
+		case "":
+			if res.Error != "" {
+				files = append(files, res.File)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// failures returns true if any file failed or could not be read. skipped files
+// are not failures
+func (sum summary) failures() bool {
+	return sum.unreadable > 0 || sum.failed > 0
+}
+
+func (sum summary) String() string {
+	return fmt.Sprintf("%d converted, %d skipped, %d unreadable, %d failed", sum.converted, sum.skipped, sum.unreadable, sum.failed)
+}
+
+// expandDirs replaces each directory in the list of files with the files in the
+// directory and its subdirectories that have one of the extensions. extensions
+// are compared without regard to case. names that are not directories are kept
+// whatever their extension
+func expandDirs(files []string, exts []string) ([]string, error) {
+	match := func(name string) bool {
+		for _, e := range exts {
+			e = strings.TrimSpace(e)
+			if !strings.HasPrefix(e, ".") {
+				e = "." + e
+			}
+			if strings.EqualFold(filepath.Ext(name), e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var expanded []string
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil || !info.IsDir() {
+			expanded = append(expanded, f)
+			continue
+		}
+		err = filepath.WalkDir(f, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && match(path) {
+				expanded = append(expanded, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("recurse: %w", err)
+		}
+	}
+	return expanded, nil
+}
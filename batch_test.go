@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status string
+	}{
+		{nil, statusConverted},
+		{inputError{errors.New("read")}, statusUnreadable},
+		{skipError{errors.New("size")}, statusSkipped},
+		{errors.New("encode"), statusFailed},
+
+		// wrapped errors keep their status
+		{fmt.Errorf("game.bin: %w", inputError{errors.New("read")}), statusUnreadable},
+		{fmt.Errorf("game.bin: %w", skipError{errors.New("size")}), statusSkipped},
+	} {
+		if s := status(tc.err); s != tc.status {
+			t.Errorf("status of %v is %q but should be %q", tc.err, s, tc.status)
+		}
+	}
+}
+
+func TestInputErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "game.bin", testROM(4096))
+	writeFile(t, dir, "old.bin", testROM(4096))
+	writeFile(t, dir, "old.wav", nil)
+	writeFile(t, dir, "bad.bin", make([]byte, 100))
+	err := os.Mkdir(filepath.Join(dir, "roms.bin"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory that is named as a file, without the -recurse flag, can
+	// not be read. it is not skipped
+	stdout, _, code := runMain(t, dir, nil, "game.bin", "old.bin", "bad.bin", "missing.bin", "roms.bin")
+	if code != 1 {
+		t.Errorf("exit code is %d but should be 1", code)
+	}
+	if !strings.Contains(stdout, "1 converted, 2 skipped, 2 unreadable, 0 failed") {
+		t.Errorf("summary is not correct: %s", stdout)
+	}
+
+	// skipped files on their own are not a failure
+	_, _, code = runMain(t, dir, nil, "old.bin", "bad.bin")
+	if code != 0 {
+		t.Errorf("exit code for skipped files is %d but should be 0", code)
+	}
+}
+
+func TestRetryFiles(t *testing.T) {
+	dir := t.TempDir()
+	manifest := writeFile(t, dir, "manifest.json", []byte(strings.Join([]string{
+		`{"file":"converted.bin","status":"converted"}`,
+		`{"file":"skipped.bin","status":"skipped","error":"skipped.bin skipped: unsupported size"}`,
+		`{"file":"unreadable.bin","status":"unreadable","error":"unreadable.bin: no such file"}`,
+		`{"file":"failed.bin","status":"failed","error":"failed.bin: write error"}`,
+
+		// manifests without the status field
+		`{"file":"old.bin","error":"old.bin: write error"}`,
+		`{"file":"oldok.bin"}`,
+	}, "\n")))
+
+	files, err := retryFiles(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, ",") != "unreadable.bin,failed.bin,old.bin" {
+		t.Errorf("files to retry are %v", files)
+	}
+
+	bad := writeFile(t, dir, "bad.json", []byte(`{"file":"game.bin",`))
+	_, err = retryFiles(bad)
+	if err == nil || !strings.HasPrefix(err.Error(), "bad.json: ") {
+		t.Errorf("error for a bad manifest is %v", err)
+	}
+	_, err = retryFiles(filepath.Join(dir, "missing.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error for a missing manifest is %v", err)
+	}
+
+	// the manifest of a run with a missing file. once the file is present the
+	// retry converts it and only it
+	writeFile(t, dir, "game.bin", testROM(4096))
+	stdout, _, code := runMain(t, dir, nil, "-json", "game.bin", "later.bin")
+	if code != 1 {
+		t.Fatalf("exit code %d with a missing file", code)
+	}
+	writeFile(t, dir, "run.json", []byte(stdout))
+	writeFile(t, dir, "later.bin", testROM(2048))
+	stdout, stderr, code := runMain(t, dir, nil, "-json", "-retry", "run.json")
+	if code != 0 {
+		t.Fatalf("exit code %d for the retry: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"file":"later.bin","status":"converted"`) {
+		t.Errorf("output of the retry is %q", stdout)
+	}
+	readFile(t, dir, "later.wav")
+}
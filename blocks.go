@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// dumpBlocks writes each 256 byte block of the rom to a separate file in a
+// subdirectory next to the rom file. the blocks are sliced from the trimmed rom
+// so that block n is the data at offset n*256 of the rom, whatever order the
+// blocks are written to tape in and whatever changes are made to their data
+func dumpBlocks(ctx context, romFile string, rom []byte, opts supercharge.ConvertOptions) error {
+	rom, err := supercharge.TrimROM(rom, opts)
+	if err != nil {
+		return err
+	}
+
+	dir, _ := strings.CutSuffix(romFile, filepath.Ext(romFile))
+	dir = fmt.Sprintf("%s_blocks", dir)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; (i+1)*256 <= len(rom); i++ {
+		blockFile := filepath.Join(dir, fmt.Sprintf("block_%02d.bin", i))
+		if !ctx.overwrite {
+			_, err := os.Stat(blockFile)
+			if err == nil || !os.IsNotExist(err) {
+				return fmt.Errorf("%s already exists", filepath.Base(blockFile))
+			}
+		}
+		err := os.WriteFile(blockFile, rom[i*256:(i+1)*256], 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpBlocks(t *testing.T) {
+	dir := t.TempDir()
+	rom := testROM(6144)
+	writeFile(t, dir, "game.bin", rom)
+
+	// the blocks are the data at each offset of the rom whatever the order of
+	// the blocks on tape
+	_, stderr, code := runMain(t, dir, nil, "-q", "-dump-blocks", "-bank", "0d", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "game_blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 24 {
+		t.Errorf("%d block files for 24 blocks", len(entries))
+	}
+	for i := 0; i < 24; i++ {
+		block := readFile(t, dir, filepath.Join("game_blocks", fmt.Sprintf("block_%02d.bin", i)))
+		if !bytes.Equal(block, rom[i*256:(i+1)*256]) {
+			t.Errorf("block %d is different to the rom", i)
+		}
+	}
+
+	// existing block files are only replaced with -o
+	err = os.Remove(filepath.Join(dir, "game.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, code := runMain(t, dir, nil, "-dump-blocks", "game.bin")
+	if code != 1 || !strings.Contains(stdout, "already exists") {
+		t.Errorf("exit code %d when the block files exist: %s", code, stdout)
+	}
+	_, stderr, code = runMain(t, dir, nil, "-q", "-o", "-dump-blocks", "game.bin")
+	if code != 0 {
+		t.Errorf("exit code %d with -o: %s", code, stderr)
+	}
+}
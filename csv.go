@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// the columns of the CSV report
+var csvColumns = []string{"file", "size", "size class", "valid", "start address", "block count", "error"}
+
+// sizeClass returns a short description of the size of a ROM. sizes that are a
+// whole number of kilobytes are described as such (eg. 4K)
+func sizeClass(size int) string {
+	if size > 0 && size%1024 == 0 {
+		return fmt.Sprintf("%dK", size/1024)
+	}
+	return "other"
+}
+
+// csvRow validates the named rom file and returns the row describing it in the
+// CSV report. the rom is not converted
+func csvRow(romFile string, opts supercharge.ConvertOptions) []string {
+	row := []string{romFile, "", "", "false", "", "", ""}
+
+	rom, err := os.ReadFile(romFile)
+	if err != nil {
+		row[6] = err.Error()
+		return row
+	}
+	row[1] = fmt.Sprintf("%d", len(rom))
+	row[2] = sizeClass(len(rom))
+
+	// the header is derived without converting the rom to audio
+	_, rep, err := supercharge.BuildStream(rom, opts)
+	if err != nil {
+		row[6] = err.Error()
+		return row
+	}
+	row[3] = "true"
+	row[4] = fmt.Sprintf("%04x", rep.Address)
+	row[5] = fmt.Sprintf("%d", rep.BlockCount)
+
+	return row
+}
+
+// writeCSV validates each of the rom files and writes a report of the results
+// to the named CSV file, with one row for each rom file
+func writeCSV(ctx context, csvFile string, files []string) error {
+	if !ctx.overwrite {
+		_, err := os.Stat(csvFile)
+		if err == nil || !os.IsNotExist(err) {
+			return fmt.Errorf("%s already exists", filepath.Base(csvFile))
+		}
+	}
+
+	f, err := os.Create(csvFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(csvColumns)
+	for _, romFile := range files {
+		w.Write(csvRow(filepath.Clean(romFile), ctx.options()))
+	}
+	w.Flush()
+	err = w.Error()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
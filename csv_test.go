@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	dir := t.TempDir()
+	rom := testROM(4096)
+	rom[4092] = 0x23
+	rom[4093] = 0xf1
+	writeFile(t, dir, "game.bin", rom)
+	writeFile(t, dir, "two.bin", testROM(2048))
+	writeFile(t, dir, "bad.bin", make([]byte, 3072))
+
+	_, stderr, code := runMain(t, dir, nil, "-csv", "report.csv", "game.bin", "two.bin", "bad.bin", "missing.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(readFile(t, dir, "report.csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("%d rows for 4 files", len(rows))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(csvColumns, ",") {
+		t.Errorf("column names are %v", rows[0])
+	}
+
+	// the error column of an invalid file is the validation error
+	for i, expected := range [][]string{
+		{"game.bin", "4096", "4K", "true", "f123", "16", ""},
+		{"two.bin", "2048", "2K", "true", "f000", "8", ""},
+		{"bad.bin", "3072", "3K", "false", "", "", "unsupported size"},
+		{"missing.bin", "", "", "false", "", "", "missing.bin"},
+	} {
+		row := rows[i+1]
+		if strings.Join(row[:6], ",") != strings.Join(expected[:6], ",") || !strings.Contains(row[6], expected[6]) {
+			t.Errorf("row for %s is %q", expected[0], row)
+		}
+	}
+
+	// the files are not converted
+	_, err = os.Stat(filepath.Join(dir, "game.wav"))
+	if !os.IsNotExist(err) {
+		t.Errorf("game.wav was created by the csv report")
+	}
+
+	// an existing report is only replaced with -o
+	stdout, _, code := runMain(t, dir, nil, "-csv", "report.csv", "game.bin")
+	if code != 1 || !strings.Contains(stdout, "report.csv already exists") {
+		t.Errorf("exit code %d when the report exists: %s", code, stdout)
+	}
+	_, _, code = runMain(t, dir, nil, "-o", "-csv", "report.csv", "game.bin")
+	if code != 0 {
+		t.Errorf("exit code %d with -o", code)
+	}
+	rows, err = csv.NewReader(bytes.NewReader(readFile(t, dir, "report.csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("replaced report has %d rows", len(rows))
+	}
+}
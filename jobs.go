@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// processFile converts a single file named on the command line and writes
+// the result
+func (ctx context) processFile(f string) error {
+	f = filepath.Clean(f)
+	if ctx.bar != nil {
+		ctx.bar.start(filepath.Base(f))
+	}
+	if ctx.stages {
+		reps, err := processStages(ctx, f)
+		ctx.result(f, reps, err)
+		return err
+	}
+	if reps, err := processMultiload(ctx, f); !errors.Is(err, notMultiload) {
+		ctx.result(f, reps, err)
+		return err
+	}
+	rep, err := process(ctx, f)
+	ctx.result(f, []supercharge.ConvertReport{rep}, err)
+	return err
+}
+
+// the result of processing a single file by one of the workers started by
+// processFiles
+type job struct {
+	file  string
+	owner int
+	err   error
+
+	// everything written to the context while the file was processed
+	log bytes.Buffer
+
+	// the file was not processed because the program was interrupted
+	skipped bool
+
+	// closed when the file has been processed
+	done chan struct{}
+}
+
+// processFiles converts each of the files with the given number of workers.
+// the messages for each file are kept together and are written in the same
+// order as the files, whatever order the conversions finish in
+func (ctx context) processFiles(files []string, workers int) summary {
+	var sum summary
+
+	// the output files are claimed in the order of the files before any file
+	// is processed. when two files have the same output file it is always the
+	// first of them that is converted, whichever worker gets to it first
+	for i, f := range files {
+		for _, name := range ctx.outputNames(filepath.Clean(f)) {
+			ctx.outputs.claim(name, i)
+		}
+	}
+
+	if workers <= 1 || len(files) <= 1 {
+		for i, f := range files {
+			if ctx.interrupted() {
+				break
+			}
+			c := ctx
+			c.owner = i
+			sum.add(c.processFile(f))
+		}
+		return sum
+	}
+
+	jobs := make([]*job, len(files))
+	queue := make(chan *job, len(files))
+	for i, f := range files {
+		jobs[i] = &job{file: f, owner: i, done: make(chan struct{})}
+		queue <- jobs[i]
+	}
+	close(queue)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range queue {
+				if ctx.interrupted() {
+					j.skipped = true
+					close(j.done)
+					continue
+				}
+				c := ctx
+				c.log = &j.log
+				c.owner = j.owner
+				j.err = c.processFile(j.file)
+				close(j.done)
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		<-j.done
+		if j.skipped {
+			continue
+		}
+		ctx.Write(j.log.Bytes())
+		sum.add(j.err)
+	}
+
+	return sum
+}
+
+// interrupted returns true if the program has been interrupted
+func (ctx context) interrupted() bool {
+	return ctx.interrupt != nil && ctx.interrupt.Err() != nil
+}
+
+// outputSet is the set of output files that have been claimed by the files
+// being processed. it prevents two files from writing to the same output file
+//
+// a nil outputSet does not prevent anything
+type outputSet struct {
+	crit  sync.Mutex
+	names map[string]int
+}
+
+func newOutputSet() *outputSet {
+	return &outputSet{names: make(map[string]int)}
+}
+
+// claim returns true if the name has not been claimed before or if it has
+// already been claimed by the same owner. the owner is the position of the
+// file in the list of files
+func (o *outputSet) claim(name string, owner int) bool {
+	if o == nil {
+		return true
+	}
+	o.crit.Lock()
+	defer o.crit.Unlock()
+	name = filepath.Clean(name)
+	if o2, ok := o.names[name]; ok {
+		return o2 == owner
+	}
+	o.names[name] = owner
+	return true
+}
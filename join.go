@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// manifestFiles returns the files named in a manifest. the manifest is a text
+// file with one file on each line. blank lines and lines beginning with # are
+// ignored. relative names are relative to the directory of the manifest
+func manifestFiles(manifest string) ([]string, error) {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(manifest), line)
+		}
+		files = append(files, line)
+	}
+	return files, scanner.Err()
+}
+
+// processJoin converts every file into a single output file as the loads of
+// one multiload game. the multiload index of the first file is the
+// joinIndex of the context and each file after that is one more than the
+// previous file
+//
+// every file is read and validated before the output file is created
+func processJoin(ctx context, joinFile string, files []string) ([]supercharge.ConvertReport, error) {
+	if ctx.joinIndex < 0 || ctx.joinIndex+len(files) > 256 {
+		return nil, fmt.Errorf("%s: %d loads starting at multiload index %d do not fit in the index byte", filepath.Base(joinFile), len(files), ctx.joinIndex)
+	}
+
+	opts := ctx.options()
+	opts.Multiload = byte(ctx.joinIndex)
+
+	var loads [][]byte
+	for _, f := range files {
+		rom, err := os.ReadFile(f)
+		if err != nil {
+			return nil, inputError{fmt.Errorf("%s: %w", filepath.Base(f), err)}
+		}
+
+		// the rom is validated as it will be converted, after it has been
+		// trimmed. the conversion does the trimming itself so the rom is not
+		// changed here
+		trimmed, err := supercharge.TrimROM(rom, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		err = supercharge.Validate(trimmed)
+		if err != nil {
+			return nil, skipError{fmt.Errorf("%s skipped: %w", filepath.Base(f), err)}
+		}
+		loads = append(loads, rom)
+	}
+
+	if ctx.exists(joinFile) {
+		return nil, skipError{fmt.Errorf("%s already exists", filepath.Base(joinFile))}
+	}
+
+	w, err := ctx.create(joinFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(joinFile), err)
+	}
+	defer w.Close()
+
+	reps, err := supercharge.ConvertMultiloadEncoder(loads, ctx.encoder(w), opts)
+	if err != nil {
+		ctx.remove(w, joinFile)
+		return nil, fmt.Errorf("%s: %w", filepath.Base(joinFile), err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(joinFile), err)
+	}
+
+	return reps, nil
+}
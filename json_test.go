@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+func TestJSON(t *testing.T) {
+	dir := t.TempDir()
+	roms := map[string][]byte{
+		"game.bin": testROM(4096),
+		"six.bin":  testROM(6144),
+	}
+	for name, rom := range roms {
+		writeFile(t, dir, name, rom)
+	}
+	writeFile(t, dir, "bad.bin", make([]byte, 100))
+
+	stdout, stderr, code := runMain(t, dir, nil, "-json", "game.bin", "six.bin", "bad.bin")
+	if code != 0 {
+		t.Errorf("exit code is %d: %s", code, stderr)
+	}
+
+	// one line of JSON for each file
+	results := make(map[string]jsonResult)
+	for _, l := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		var res jsonResult
+		err := json.Unmarshal([]byte(l), &res)
+		if err != nil {
+			t.Fatalf("%v: %q", err, l)
+		}
+		results[res.File] = res
+	}
+	if len(results) != 3 {
+		t.Fatalf("%d results for 3 files", len(results))
+	}
+
+	for name, rom := range roms {
+		res := results[name]
+		if res.Status != statusConverted || res.Error != "" || res.Report == nil {
+			t.Fatalf("%s: status %q, error %q", name, res.Status, res.Error)
+		}
+		_, rep, err := supercharge.BuildStream(rom, supercharge.Default())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Report.Header != rep.Header {
+			t.Errorf("%s: header is %+v but should be %+v", name, res.Report.Header, rep.Header)
+		}
+		if len(res.Report.Blocks) != len(rep.Blocks) || res.Report.Blocks[0] != rep.Blocks[0] {
+			t.Errorf("%s: %d blocks in the report", name, len(res.Report.Blocks))
+		}
+		if res.Report.SampleRate != 44100 || res.Report.Channels != 1 || res.Report.BitDepth != 8 || res.Report.Duration <= 0 {
+			t.Errorf("%s: report is %dHz, %d channels, %d bits, %.2f seconds", name, res.Report.SampleRate, res.Report.Channels, res.Report.BitDepth, res.Report.Duration)
+		}
+	}
+
+	res := results["bad.bin"]
+	if res.Status != statusSkipped || res.Error == "" || res.Report != nil {
+		t.Errorf("bad.bin: status %q, error %q", res.Status, res.Error)
+	}
+}
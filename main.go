@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	gocontext "context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/jetsetilly/supercharge/supercharge"
@@ -16,10 +21,239 @@ import (
 // also a valid io.Writer, suitable for verbose logging
 type context struct {
 	overwrite bool
+	version   bool
+	infoTones bool
+	json      bool
+	target    string
+	ips       float64
+	tagName   bool
+	stages    bool
+	stereo    bool
+	split     bool
+
+	// the channels of stereo output that carry the tones
+	channel     string
+	channelMode supercharge.ChannelMode
+
+	// the peak level of the tones
+	volume float64
+
+	// the shape of each tone cycle
+	waveformName string
+	waveform     supercharge.Waveform
+	bandLimited  bool
+
+	// audio format of the tape target. the raw flag is the same as the raw
+	// format
+	format     string
+	raw        bool
+	mp3Bitrate int
+	oggQuality float64
+
+	// the sample rate at which the tones are generated. zero for the normal
+	// rate
+	rate uint
+
+	// the number of bits in each sample. zero for 8 bit samples
+	bits int
+
+	// write the fmt chunk of wav files in the WAVE_FORMAT_EXTENSIBLE layout
+	extensible bool
+
+	// the device profile named by the profile flag
+	profile       string
+	deviceProfile supercharge.DeviceProfile
+
+	// do not add the dither that the device profile would add
+	noDither bool
+
+	// the converter is created once and used for every file
+	converter *supercharge.Converter
+
+	// progress display. bar is nil if progress is not being displayed
+	progress bool
+	quiet    bool
+	bar      *progressBar
+
+	// write each block of the rom to a separate file
+	dumpBlocks bool
+
+	// the largest size of a wav file. output that is larger is written to a
+	// sequence of files. zero means no limit
+	maxFileSize int64
+
+	// write a spectrogram of the output next to the wav file
+	spectrogram bool
+
+	// validate the files and write a CSV report instead of converting
+	csv string
+
+	// read the rom from stdin and write the output to stdout. diagnostics
+	// are written to stderr when the output is written to stdout
+	stdin  bool
+	stdout bool
+
+	// output files are written to a tar archive instead of to the directory
+	// of the ROM file. archive is nil if a tar archive is not being used
+	tar     string
+	archive *tarArchive
+
+	// how files containing several loads are treated. the load size is the
+	// size of each load in a file that is a sequence of ROMs
+	multiload string
+	loadSize  int
+
+	// the length of the silence before each load after the first
+	loadGap float64
+
+	// the bank configuration byte given on the command line, as a hex string,
+	// and its value. zero if the default is used
+	bank       string
+	bankConfig byte
+
+	// the progress bar speed given on the command line, either as a hex
+	// string or as the name of a preset. only one of progressSpeed and
+	// progressPreset is set
+	speed          string
+	progressSpeed  uint16
+	progressPreset supercharge.ProgressPreset
+
+	// manifest of a previous run. failed files in the manifest are converted
+	retry string
+
+	// the name of the output file when there is a single input. if empty the
+	// name of the output file is taken from the name of the input file
+	out string
+
+	// directories are searched for files with one of the extensions in the
+	// comma separated list if recurse is true
+	recurse bool
+	ext     string
+
+	// the number of files converted at the same time
+	jobs int
+
+	// all files are converted into this single output file, one after
+	// another, if it is not empty
+	tape string
+
+	// all files are converted into this single output file as the loads of
+	// one multiload game, if it is not empty. the files are added to those
+	// named in the join manifest
+	join         string
+	joinIndex    int
+	joinManifest string
+
+	// the output files claimed by the files being processed. nil if no claims
+	// are being made
+	outputs *outputSet
+
+	// the position of the file being processed in the list of files. output
+	// files are claimed for this position
+	owner int
+
+	// messages are written to log instead of stdout or stderr if it is not
+	// nil. used to keep the messages for each file together when files are
+	// processed at the same time
+	log *bytes.Buffer
+
+	// cancelled when the program is interrupted. the conversion in progress
+	// stops and no more files are started
+	interrupt gocontext.Context
+}
+
+// options returns the conversion options selected by the command line
+func (ctx context) options() supercharge.ConvertOptions {
+	opts := supercharge.Default()
+	if ctx.stereo || ctx.channelMode != supercharge.ChannelBoth {
+		opts.Channels = 2
+	}
+	opts.ChannelMode = ctx.channelMode
+	opts.Waveform = ctx.waveform
+	opts.Volume = ctx.volume
+	opts.BandLimited = ctx.bandLimited
+	opts.DeviceProfile = ctx.deviceProfile
+	opts.NoDither = ctx.noDither
+	opts.MultiloadSilenceSeconds = ctx.loadGap
+	opts.BankConfig = ctx.bankConfig
+	opts.ProgressSpeed = ctx.progressSpeed
+	opts.ProgressPreset = ctx.progressPreset
+	if ctx.bar != nil {
+		opts.Progress = ctx.bar.update
+	}
+	opts.SampleRate = uint32(ctx.rate)
+	opts.BitDepth = ctx.bits
+	opts.WAVExtensible = ctx.extensible
+	opts.Context = ctx.interrupt
+	return opts
+}
+
+// encoder returns the Encoder for the audio format of the tape target. the mp3
+// and ogg formats are not encoded by the supercharge package and are not
+// handled here
+func (ctx context) encoder(w io.Writer) supercharge.Encoder {
+	switch ctx.format {
+	case "raw":
+		return supercharge.NewRawEncoder(w)
+	case "flac":
+		return supercharge.NewFLACEncoder(w)
+	case "aiff":
+		return supercharge.NewAIFFEncoder(w)
+	case "csw":
+		return supercharge.NewCSWEncoder(w)
+	}
+	return supercharge.NewWAVEncoder(w)
+}
+
+// multiloadFormat returns true if the audio format of the tape target can hold
+// the tones of more than one load in a single file
+func (ctx context) multiloadFormat() bool {
+	switch ctx.format {
+	case "wav", "flac", "aiff", "csw":
+		return true
+	}
+	return false
+}
+
+// filenameTags returns a short description of the conversion options, suitable
+// for use in a filename
+func filenameTags(opts supercharge.ConvertOptions) string {
+	// the tags describe the output, which includes the settings of the device
+	// profile
+	opts = opts.WithProfile()
+
+	rate := opts.ResampleTo
+	if rate == 0 {
+		rate = opts.SampleRate
+	}
+	if rate == 0 {
+		rate = 44100
+	}
+	tags := []string{strconv.Itoa(int(rate))}
+	if opts.BitDepth == 16 {
+		tags = append(tags, "16bit")
+	}
+	if opts.Channels == 2 {
+		tags = append(tags, "stereo")
+	}
+	if opts.ChannelMode != supercharge.ChannelBoth {
+		tags = append(tags, opts.ChannelMode.String())
+	}
+	tags = append(tags, opts.Waveform.String())
+	if opts.BandLimited && opts.Waveform != supercharge.WaveformSine {
+		tags = append(tags, "bandlimited")
+	}
+	return strings.Join(tags, "_")
 }
 
 func (ctx context) Write(p []byte) (n int, err error) {
-	os.Stdout.Write(p)
+	if ctx.log != nil {
+		ctx.log.Write(p)
+	} else if ctx.stdout {
+		os.Stderr.Write(p)
+	} else {
+		os.Stdout.Write(p)
+	}
 	return len(p), nil
 }
 
@@ -27,78 +261,776 @@ func main() {
 	var ctx context
 
 	// parse command line arguments
-	flag.BoolVar(&ctx.overwrite, "o", false, "overwrite existing wav files")
+	flag.BoolVar(&ctx.overwrite, "o", false, "overwrite existing output files")
+	flag.BoolVar(&ctx.version, "version", false, "display the version of the program and the default output parameters and exit")
+	flag.BoolVar(&ctx.infoTones, "info-tones", false, "list the frequencies of the generated tones and exit")
+	flag.BoolVar(&ctx.json, "json", false, "output results as JSON, one object per line")
+	flag.Float64Var(&ctx.ips, "ips", 1.875, "tape speed in inches per second used to report the length of tape required")
+	flag.BoolVar(&ctx.tagName, "tag-filename", false, "add the main conversion parameters to the output filename")
+	flag.BoolVar(&ctx.stages, "stages", false, "treat each file as the first of a set of numbered multiload stages (eg. game.1, game.2)")
+	flag.StringVar(&ctx.multiload, "multiload", "auto", "how files with several loads are converted: auto (load images only), image (Supercharger load image, eg. .ar or .mlt), split (a sequence of ROMs of -load-size bytes) or off")
+	flag.IntVar(&ctx.loadSize, "load-size", 4096, "the size of each load when a file is split into several loads")
+	flag.Float64Var(&ctx.loadGap, "load-gap", 0, "seconds of silence before each load of a multiload game, after the first")
+	flag.BoolVar(&ctx.stereo, "stereo", false, "create stereo output with the same data in both channels")
+	flag.StringVar(&ctx.channel, "channel", "both", "channels of stereo output that carry the tones: both, left or right. left and right imply -stereo and the other channel is silent")
+	flag.Float64Var(&ctx.volume, "volume", 0.98, "peak level of the tones, greater than 0 and no more than 1. some line-out and line-in connections need a hotter or quieter signal")
+	flag.StringVar(&ctx.waveformName, "waveform", "sine", "shape of each tone cycle: sine, square or trapezoid. some sound cards reproduce square waves more reliably")
+	flag.BoolVar(&ctx.bandLimited, "band-limited", false, "remove the harmonics of square and trapezoid waveforms that would alias at the sample rate")
+	flag.BoolVar(&ctx.split, "split", false, "write each channel of stereo output to a separate mono file (with _L and _R suffixes)")
+	flag.StringVar(&ctx.target, "target", "tape", "output target: tape (WAV audio), stream (header and packet bytes, no tones) or ar (Supercharger load image for emulators and flash cartridges)")
+	flag.StringVar(&ctx.bank, "bank", "", "bank configuration byte of the header in hex. the default is 1d")
+	flag.StringVar(&ctx.speed, "speed", "", "progress bar speed of the header in hex, or slow, normal or fast to compute it from the size of the ROM. the default is the sctech.txt value for the size of the ROM (b6 for 2K, 16d for 4K and 224 for 6K)")
+	flag.StringVar(&ctx.profile, "profile", "none", "playback device profile: none, modern-soundcard, vintage-deck or emulator")
+	flag.BoolVar(&ctx.noDither, "no-dither", false, "do not add the dither that the modern-soundcard profile adds")
+	flag.BoolVar(&ctx.progress, "progress", false, "display a progress bar for each file (only when the output is a terminal)")
+	flag.StringVar(&ctx.retry, "retry", "", "convert the files that failed in a previous run. the manifest is the output of the previous run with -json")
+	flag.Int64Var(&ctx.maxFileSize, "max-file-size", 0, "the largest size in bytes of each WAV file. larger output is split at packet boundaries into numbered files (eg. game_00.wav, game_01.wav)")
+	flag.BoolVar(&ctx.spectrogram, "spectrogram", false, "write a PNG spectrogram of the audio next to each WAV file")
+	flag.BoolVar(&ctx.dumpBlocks, "dump-blocks", false, "write each 256 byte block of the ROM to a separate file in a _blocks subdirectory")
+	flag.StringVar(&ctx.tar, "tar", "", "write the output files as entries in the named tar archive instead of next to each ROM file")
+	flag.StringVar(&ctx.csv, "csv", "", "validate each file and write a report to the named CSV file. the files are not converted")
+	flag.BoolVar(&ctx.stdin, "stdin", false, "read the ROM from stdin. requires -stdout or -out")
+	flag.StringVar(&ctx.tape, "tape", "", "convert every ROM into the named WAV file, one after another, like several games on one side of a cassette. programs are separated by -load-gap seconds of silence")
+	flag.StringVar(&ctx.join, "join", "", "convert every ROM into the named WAV file as the loads of one multiload game. loads are separated by -load-gap seconds of silence")
+	flag.IntVar(&ctx.joinIndex, "join-index", 0, "multiload index of the first load written by -join. the index of each load after that is one more than the previous load")
+	flag.StringVar(&ctx.joinManifest, "join-list", "", "text file naming the ROMs for -join, one on each line, in load order. the ROMs are added to any named on the command line")
+	flag.StringVar(&ctx.out, "out", "", "name of the output file. requires a single ROM")
+	flag.IntVar(&ctx.jobs, "j", runtime.NumCPU(), "number of files to convert at the same time. files are converted one at a time when writing to a tar archive or displaying a progress bar")
+	flag.BoolVar(&ctx.recurse, "r", false, "convert the files in directories and their subdirectories that have one of the -ext extensions")
+	flag.StringVar(&ctx.ext, "ext", ".bin,.a26,.rom,.ar,.mlt", "comma separated list of the extensions of files converted by -r")
+	flag.BoolVar(&ctx.stdout, "stdout", false, "write the output to stdout instead of a file. messages are written to stderr")
+	flag.BoolVar(&ctx.quiet, "q", false, "quiet mode. only errors are displayed")
+	flag.StringVar(&ctx.format, "format", "wav", "audio format of the tape target: wav, aiff (wav in the big-endian container used by Mac audio software), flac (lossless and smaller than wav), csw (compressed square wave for tape archives, mono only), raw (unsigned 8 bit PCM without a header), mp3 or ogg (mp3 requires the lame encoder, ogg requires the oggenc encoder, and neither may load on real hardware)")
+	flag.BoolVar(&ctx.raw, "raw", false, "the same as -format raw. write bare PCM samples without a header, for piping to aplay or sox")
+	flag.UintVar(&ctx.rate, "rate", 0, "sample rate in Hz at which the tones are generated, eg. 22050, 31400 or 48000. the length of each tone cycle is scaled to the rate. the default is 44100")
+	flag.IntVar(&ctx.bits, "bits", 8, "bits in each sample: 8 (unsigned) or 16 (signed little-endian)")
+	flag.BoolVar(&ctx.extensible, "extensible", false, "write WAV files with a WAVE_FORMAT_EXTENSIBLE fmt chunk, for players that require it")
+	flag.IntVar(&ctx.mp3Bitrate, "mp3-bitrate", 320, "bitrate in kbps of mp3 output. high bitrates preserve the tones better")
+	flag.Float64Var(&ctx.oggQuality, "ogg-quality", 8, "quality of ogg output, from -1 to 10. high qualities preserve the tones better")
 	flag.Usage = func() {
 		fmt.Printf("Usage: %s [ROM files]\n\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
-		fmt.Println("\nconverted WAV files will be saved in the same directory as the ROM file")
+		fmt.Println("\nconverted files will be saved in the same directory as the ROM file")
 	}
 	flag.Parse()
+	files := flag.Args()
+
+	// the first interrupt stops the conversion in progress. the output files
+	// that are not complete are removed
+	interrupt, stop := signal.NotifyContext(gocontext.Background(), os.Interrupt)
+	defer stop()
+	ctx.interrupt = interrupt
+
+	// a single "-" is the same as the -stdin flag. the output is written to
+	// stdout unless an output file is named
+	if len(files) == 1 && files[0] == "-" {
+		files = nil
+		ctx.stdin = true
+		ctx.stdout = ctx.out == ""
+	}
+
+	if ctx.version {
+		ctx.Write([]byte(version()))
+		return
+	}
+
+	if ctx.raw {
+		if ctx.format != "wav" && ctx.format != "raw" {
+			fmt.Println("-raw can not be used with -format")
+			os.Exit(1)
+		}
+		ctx.format = "raw"
+	}
+
+	if ctx.target != "tape" && ctx.target != "stream" && ctx.target != "ar" {
+		fmt.Printf("unknown target: %s\n", ctx.target)
+		os.Exit(1)
+	}
+
+	var err error
+	ctx.deviceProfile, err = supercharge.ParseDeviceProfile(ctx.profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ctx.channelMode, err = supercharge.ParseChannelMode(ctx.channel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ctx.waveform, err = supercharge.ParseWaveform(ctx.waveformName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if ctx.volume <= 0 || ctx.volume > 1 {
+		fmt.Println("volume must be greater than 0 and no more than 1")
+		os.Exit(1)
+	}
+
+	if ctx.bank != "" {
+		ctx.bankConfig, err = supercharge.ParseBankConfig(ctx.bank)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if ctx.speed != "" {
+		ctx.progressSpeed, ctx.progressPreset, err = supercharge.ParseProgressSpeed(ctx.speed)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	switch ctx.multiload {
+	case "auto", "image", "split", "off":
+	default:
+		fmt.Printf("unknown multiload mode: %s\n", ctx.multiload)
+		os.Exit(1)
+	}
+
+	switch ctx.format {
+	case "wav":
+	case "raw":
+		if ctx.stages || ctx.split || ctx.maxFileSize > 0 {
+			fmt.Println("raw format can not be used with multiload stages, split stereo output or a maximum file size")
+			os.Exit(1)
+		}
+	case "flac", "aiff":
+		if ctx.split || ctx.maxFileSize > 0 {
+			fmt.Printf("%s format can not be used with split stereo output or a maximum file size\n", ctx.format)
+			os.Exit(1)
+		}
+	case "csw":
+		if ctx.stereo || ctx.channelMode != supercharge.ChannelBoth || ctx.split || ctx.maxFileSize > 0 {
+			fmt.Println("csw format can not be used with stereo output or a maximum file size")
+			os.Exit(1)
+		}
+	case "mp3":
+		if ctx.stages || ctx.split || ctx.stdout {
+			fmt.Println("mp3 format can not be used with multiload stages, split stereo output or stdout")
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, mp3Warning)
+	case "ogg":
+		if ctx.stages || ctx.split || ctx.stdout {
+			fmt.Println("ogg format can not be used with multiload stages, split stereo output or stdout")
+			os.Exit(1)
+		}
+		if ctx.oggQuality < -1 || ctx.oggQuality > 10 {
+			fmt.Println("ogg quality must be between -1 and 10")
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, oggWarning)
+	default:
+		fmt.Printf("unknown format: %s\n", ctx.format)
+		os.Exit(1)
+	}
+
+	if ctx.tar != "" && (ctx.stdout || ctx.format == "mp3" || ctx.format == "ogg" || ctx.dumpBlocks) {
+		fmt.Println("tar archive can not be used with stdout, mp3 or ogg format or dumping of blocks")
+		os.Exit(1)
+	}
+
+	if ctx.stdin && !ctx.stdout && ctx.out == "" {
+		fmt.Println("stdin input requires -stdout or -out")
+		os.Exit(1)
+	}
+	if ctx.tape != "" && (ctx.stdout || ctx.stdin || ctx.stages || ctx.split || ctx.out != "" || ctx.maxFileSize > 0 || ctx.format != "wav" || ctx.target != "tape") {
+		fmt.Println("-tape can only be used for WAV output and not with stdin, stdout, -out, multiload stages, split stereo output or a maximum file size")
+		os.Exit(1)
+	}
+	if ctx.join != "" && (ctx.tape != "" || ctx.stdout || ctx.stdin || ctx.stages || ctx.split || ctx.out != "" || ctx.maxFileSize > 0 || !ctx.multiloadFormat() || ctx.target != "tape") {
+		fmt.Println("-join can only be used for WAV, AIFF, FLAC or CSW output and not with -tape, stdin, stdout, -out, multiload stages, split stereo output or a maximum file size")
+		os.Exit(1)
+	}
+	if ctx.joinManifest != "" && ctx.join == "" {
+		fmt.Println("-join-list requires -join")
+		os.Exit(1)
+	}
+	if ctx.out != "" && (ctx.stdout || ctx.stages || ctx.tar != "") {
+		fmt.Println("-out can not be used with stdout, multiload stages or a tar archive")
+		os.Exit(1)
+	}
+	if ctx.stdout {
+		if ctx.stages || ctx.split || ctx.maxFileSize > 0 || ctx.spectrogram || ctx.csv != "" {
+			fmt.Fprintln(os.Stderr, "stdout can not be used with multiload stages, split stereo output, a maximum file size, spectrograms or a csv report")
+			os.Exit(1)
+		}
+
+		// the output is binary and would be unreadable in a terminal
+		if isTerminal(os.Stdout) && !isDevNull(os.Stdout) {
+			fmt.Fprintln(os.Stderr, "stdout is a terminal. redirect the output to a file or to another program")
+			os.Exit(1)
+		}
+	}
+
+	// list tone frequencies and exit
+	if ctx.infoTones {
+		start, zero, one := supercharge.ToneFrequencies(ctx.options())
+		ctx.Write([]byte(fmt.Sprintf("start tone: %.2f Hz\n", start)))
+		ctx.Write([]byte(fmt.Sprintf("zero bit: %.2f Hz\n", zero)))
+		ctx.Write([]byte(fmt.Sprintf("one bit: %.2f Hz\n", one)))
+		return
+	}
+
+	// the output of a single rom is written to stdout
+	if ctx.stdout {
+		if ctx.stdin && len(files) > 0 || !ctx.stdin && len(files) != 1 {
+			fmt.Fprintln(os.Stderr, "stdout requires exactly one ROM, either from stdin or a single file")
+			os.Exit(1)
+		}
+
+		ctx.converter, err = supercharge.NewConverter(ctx.options())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		romFile := "stdin"
+		if !ctx.stdin {
+			romFile = filepath.Clean(files[0])
+		}
+		reps, err := processPipe(ctx, romFile, os.Stdout)
+		ctx.result(romFile, reps, err)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// a rom from stdin is written to the named output file
+	if ctx.stdin {
+		ctx.converter, err = supercharge.NewConverter(ctx.options())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		reps, err := processStdin(ctx)
+		ctx.result("stdin", reps, err)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// directories are replaced by the files they contain
+	if ctx.recurse {
+		files, err = expandDirs(files, strings.Split(ctx.ext, ","))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// add files from the manifest of a previous run
+	if ctx.retry != "" {
+		retry, err := retryFiles(ctx.retry)
+		if err != nil {
+			fmt.Printf("retry: %s\n", err)
+			os.Exit(1)
+		}
+		if len(retry) == 0 && len(files) == 0 {
+			fmt.Println("retry: no failed files in manifest")
+			return
+		}
+		files = append(files, retry...)
+	}
+
+	// add files from the join manifest
+	if ctx.joinManifest != "" {
+		list, err := manifestFiles(ctx.joinManifest)
+		if err != nil {
+			fmt.Printf("join: %s\n", err)
+			os.Exit(1)
+		}
+		files = append(files, list...)
+	}
 
 	// display usage if no rom files have been specified
-	if len(flag.Args()) == 0 {
+	if len(files) == 0 {
 		flag.Usage()
 		return
 	}
 
-	// process all files specified on the command line
-	for _, f := range flag.Args() {
-		f = filepath.Clean(f)
-		err := process(ctx, f)
+	if ctx.out != "" && len(files) != 1 {
+		fmt.Println("-out requires exactly one ROM")
+		os.Exit(1)
+	}
+
+	// the csv report replaces conversion
+	if ctx.csv != "" {
+		err := writeCSV(ctx, ctx.csv, files)
+		if err != nil {
+			fmt.Printf("csv: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the progress bar is written to stderr so that it does not interfere with
+	// the normal output
+	if ctx.progress && !ctx.quiet && isTerminal(os.Stderr) {
+		ctx.bar = &progressBar{w: os.Stderr}
+	}
+
+	ctx.converter, err = supercharge.NewConverter(ctx.options())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// the tar archive replaces the output files
+	if ctx.tar != "" {
+		if !ctx.overwrite {
+			_, err := os.Stat(ctx.tar)
+			if err == nil || !os.IsNotExist(err) {
+				fmt.Printf("tar: %s already exists\n", filepath.Base(ctx.tar))
+				os.Exit(1)
+			}
+		}
+		f, err := os.Create(ctx.tar)
 		if err != nil {
-			ctx.Write([]byte(fmt.Sprintf("%s\n", err.Error())))
+			fmt.Printf("tar: %s\n", err)
+			os.Exit(1)
 		}
+		defer f.Close()
+		ctx.archive = newTarArchive(f)
+	}
+
+	// process all files specified on the command line. a failure with one file
+	// does not prevent the other files from being processed. the entries of a
+	// tar archive and the progress bar require that the files are processed
+	// one at a time
+	var sum summary
+	if ctx.join != "" {
+		reps, err := processJoin(ctx, ctx.join, files)
+		ctx.result(ctx.join, reps, err)
+		sum.add(err)
+	} else if ctx.tape != "" {
+		reps, err := processTape(ctx, ctx.tape, files)
+		ctx.result(ctx.tape, reps, err)
+		if err == nil {
+			ctx.tapeIndex(files, reps)
+		}
+		sum.add(err)
+	} else {
+		workers := ctx.jobs
+		if ctx.archive != nil || ctx.bar != nil {
+			workers = 1
+		}
+		ctx.outputs = newOutputSet()
+		sum = ctx.processFiles(files, workers)
+	}
+
+	if ctx.archive != nil {
+		err := ctx.archive.w.Close()
+		if err != nil {
+			fmt.Printf("tar: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// summarise the batch if there was more than one file
+	if len(files) > 1 && ctx.tape == "" && ctx.join == "" && !ctx.json && !ctx.quiet {
+		ctx.Write([]byte(fmt.Sprintf("%s\n", sum)))
+	}
+
+	if ctx.interrupted() {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		os.Exit(1)
+	}
+
+	if sum.failures() {
+		os.Exit(1)
 	}
 }
 
-func process(ctx context, romFile string) error {
-	// create filename for wav file
-	wavFile, _ := strings.CutSuffix(romFile, filepath.Ext(romFile))
-	wavFile = fmt.Sprintf("%s.wav", wavFile)
+// result writes the result of processing a file. there is one report for
+// each load in the output
+func (ctx context) result(romFile string, reps []supercharge.ConvertReport, err error) {
+	// the progress display is not always finished, for example if there was
+	// an error
+	if ctx.bar != nil {
+		ctx.bar.finish()
+	}
 
-	// check whether wav file already exists
-	if !ctx.overwrite {
-		_, err := os.Stat(wavFile)
-		if err == nil || !os.IsNotExist(err) {
-			return fmt.Errorf("%s already exists", filepath.Base(wavFile))
+	if ctx.json {
+		ctx.writeJSON(romFile, reps, err)
+		return
+	}
+
+	if err != nil {
+		ctx.Write([]byte(fmt.Sprintf("%s\n", err.Error())))
+		return
+	}
+
+	if ctx.quiet {
+		return
+	}
+
+	ctx.Write([]byte(fmt.Sprintf("%s converted\n", filepath.Base(romFile))))
+
+	var duration float64
+	for i, rep := range reps {
+		if len(reps) > 1 {
+			ctx.Write([]byte(fmt.Sprintf("load %d\n", i)))
 		}
+		ctx.Write([]byte(rep.String()))
+		duration += rep.Duration
 	}
 
-	// open rom file and read the data in its entirety
-	r, err := os.Open(romFile)
+	if duration > 0 && ctx.ips > 0 {
+		feet := supercharge.TapeLength(duration, ctx.ips)
+		ctx.Write([]byte(fmt.Sprintf("\ttape length: %.1f ft (%.1f m) at %g ips\n", feet, feet*0.3048, ctx.ips)))
+	}
+}
+
+// the result of processing a single file in a form suitable for JSON output
+type jsonResult struct {
+	File   string                      `json:"file"`
+	Status string                      `json:"status"`
+	Error  string                      `json:"error,omitempty"`
+	Report *supercharge.ConvertReport  `json:"report,omitempty"`
+	Loads  []supercharge.ConvertReport `json:"loads,omitempty"`
+}
+
+// writeJSON writes the result of processing a file as a single line of JSON
+func (ctx context) writeJSON(romFile string, reps []supercharge.ConvertReport, err error) {
+	res := jsonResult{
+		File:   romFile,
+		Status: status(err),
+	}
 	if err != nil {
-		return fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		res.Error = err.Error()
+	} else if len(reps) == 1 {
+		res.Report = &reps[0]
+	} else {
+		res.Loads = reps
+	}
+	b, _ := json.Marshal(res)
+	ctx.Write(append(b, '\n'))
+}
+
+// outputName returns the name of the output file for the named input file. the
+// suffix is added to the filename before the extension and can be empty
+//
+// if an output file has been named with -out the suffix is added to that name,
+// before its extension
+func (ctx context) outputName(romFile string, suffix string, opts supercharge.ConvertOptions) string {
+	if ctx.out != "" {
+		outFile, _ := strings.CutSuffix(ctx.out, filepath.Ext(ctx.out))
+		return fmt.Sprintf("%s%s%s", outFile, suffix, filepath.Ext(ctx.out))
 	}
-	defer r.Close()
 
-	rom, err := io.ReadAll(r)
+	outFile, _ := strings.CutSuffix(romFile, filepath.Ext(romFile))
+	if ctx.tagName {
+		outFile = fmt.Sprintf("%s_%s", outFile, filenameTags(opts))
+	}
+	outFile += suffix
+	if ctx.target == "stream" {
+		return fmt.Sprintf("%s.stream", outFile)
+	} else if ctx.target == "ar" {
+		return fmt.Sprintf("%s.ar", outFile)
+	} else if ctx.format == "mp3" {
+		return fmt.Sprintf("%s.mp3", outFile)
+	} else if ctx.format == "raw" {
+		return fmt.Sprintf("%s.raw", outFile)
+	} else if ctx.format == "flac" {
+		return fmt.Sprintf("%s.flac", outFile)
+	} else if ctx.format == "aiff" {
+		return fmt.Sprintf("%s.aiff", outFile)
+	} else if ctx.format == "csw" {
+		return fmt.Sprintf("%s.csw", outFile)
+	} else if ctx.format == "ogg" {
+		return fmt.Sprintf("%s.ogg", outFile)
+	}
+	return fmt.Sprintf("%s.wav", outFile)
+}
+
+// outputNames returns the names of the output files that processFile() claims
+// for the named input file
+func (ctx context) outputNames(romFile string) []string {
+	opts := ctx.options()
+	if !ctx.stages && ctx.split && opts.Channels == 2 && ctx.target == "tape" {
+		return []string{ctx.outputName(romFile, "_L", opts), ctx.outputName(romFile, "_R", opts)}
+	}
+	return []string{ctx.outputName(romFile, "", opts)}
+}
+
+// outputFile returns the name of the output file for the named input file, as
+// returned by outputName(). an error is returned if the file already exists and
+// overwriting is not enabled, or if the file is the output of another file
+func (ctx context) outputFile(romFile string, suffix string, opts supercharge.ConvertOptions) (string, error) {
+	outFile := ctx.outputName(romFile, suffix, opts)
+
+	// the claim is checked first because the other file might already have
+	// created the output file
+	if !ctx.outputs.claim(outFile, ctx.owner) {
+		return "", skipError{fmt.Errorf("%s is the output of another file", filepath.Base(outFile))}
+	}
+	if ctx.exists(outFile) {
+		return "", skipError{fmt.Errorf("%s already exists", filepath.Base(outFile))}
+	}
+
+	return outFile, nil
+}
+
+func process(ctx context, romFile string) (supercharge.ConvertReport, error) {
+	opts := ctx.options()
+
+	// stereo output can be split into two mono files
+	if ctx.split && opts.Channels == 2 && ctx.target == "tape" {
+		return processSplit(ctx, romFile, opts)
+	}
+
+	// create filename for output file
+	outFile, err := ctx.outputFile(romFile, "", opts)
 	if err != nil {
-		return fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		return supercharge.ConvertReport{}, err
 	}
 
-	// validate with the supercharge package that this rom data is okay
-	err = supercharge.Validate(rom)
+	rom, err := readROM(romFile)
 	if err != nil {
-		return fmt.Errorf("%s skipped: %w", filepath.Base(romFile), err)
+		return supercharge.ConvertReport{}, err
 	}
 
-	// create wav file
-	w, err := os.Create(wavFile)
+	if ctx.dumpBlocks {
+		err = dumpBlocks(ctx, romFile, rom, opts)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: dump blocks: %w", filepath.Base(romFile), err)
+		}
+	}
+
+	// the mp3 output file is created by the external encoder
+	if ctx.target == "tape" && ctx.format == "mp3" {
+		rep, err := convertMP3(ctx, rom, romFile, outFile, opts)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return rep, nil
+	}
+
+	// the ogg output file is also created by an external encoder
+	if ctx.target == "tape" && ctx.format == "ogg" {
+		rep, err := convertOgg(ctx, rom, romFile, outFile, opts)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return rep, nil
+	}
+
+	// output is written to a sequence of wav files if a maximum size has been
+	// specified
+	if ctx.target == "tape" && ctx.maxFileSize > 0 {
+		return processRotating(ctx, rom, romFile, outFile)
+	}
+
+	// create output file
+	w, err := ctx.create(outFile)
 	if err != nil {
-		return fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
 	}
 	defer w.Close()
 
-	// convert rom data to wav file
-	var results bytes.Buffer
-	err = supercharge.Convert(rom, w, &results)
+	// the stream target is the data that would be represented by the tones
+	// in the wav file
+	if ctx.target == "stream" {
+		stream, rep, err := supercharge.BuildStream(rom, opts)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		_, err = w.Write(stream)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return rep, nil
+	}
+
+	// the ar target is a load image for emulators and flash cartridges
+	if ctx.target == "ar" {
+		rep, err := supercharge.ConvertToAR(rom, w, opts)
+		if err != nil {
+			ctx.remove(w, outFile)
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return rep, nil
+	}
+
+	// convert rom data to wav file, or to the selected format. the samples
+	// are also recorded if a spectrogram is required
+	enc := ctx.encoder(w)
+	var rec *sampleRecorder
+	var pngFile string
+	if ctx.spectrogram {
+		pngFile, _ = strings.CutSuffix(outFile, filepath.Ext(outFile))
+		pngFile = fmt.Sprintf("%s.png", pngFile)
+		if ctx.exists(pngFile) {
+			return supercharge.ConvertReport{}, skipError{fmt.Errorf("%s already exists", filepath.Base(pngFile))}
+		}
+		rec = &sampleRecorder{}
+		enc = supercharge.MultiEncoder(enc, rec)
+	}
+
+	rep, err := ctx.converter.ConvertEncoder(rom, enc)
+	if err != nil {
+		ctx.remove(w, outFile)
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+	err = w.Close()
 	if err != nil {
-		return fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
 	}
 
-	// display results
-	ctx.Write([]byte(fmt.Sprintf("%s converted\n", filepath.Base(romFile))))
-	ctx.Write(results.Bytes())
+	if rec != nil {
+		err = writeSpectrogram(ctx, pngFile, rec.samples)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: spectrogram: %w", filepath.Base(romFile), err)
+		}
+	}
+
+	return rep, nil
+}
+
+// readROM reads the named rom file in its entirety and validates it
+func readROM(romFile string) ([]byte, error) {
+	r, err := os.Open(romFile)
+	if err != nil {
+		return nil, inputError{fmt.Errorf("%s: %w", filepath.Base(romFile), err)}
+	}
+	defer r.Close()
 
+	return readROMFrom(r, romFile)
+}
+
+// readROMFrom is the same as readROM except that the rom data is read from an
+// io.Reader. the name is used in error messages
+func readROMFrom(r io.Reader, romFile string) ([]byte, error) {
+	rom, err := readAllFrom(r, romFile)
+	if err != nil {
+		return nil, err
+	}
+	return rom, validateROM(rom, romFile)
+}
+
+// readAllFrom reads all the data from an io.Reader without validating it. the
+// name is used in error messages
+func readAllFrom(r io.Reader, romFile string) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, inputError{fmt.Errorf("%s: %w", filepath.Base(romFile), err)}
+	}
+	return data, nil
+}
+
+// validateROM checks with the supercharge package that the rom data is okay
+func validateROM(rom []byte, romFile string) error {
+	err := supercharge.Validate(rom)
+	if err != nil {
+		return skipError{fmt.Errorf("%s skipped: %w", filepath.Base(romFile), err)}
+	}
 	return nil
 }
+
+// processSplit converts a rom file to stereo and writes each channel to a
+// separate mono file
+func processSplit(ctx context, romFile string, opts supercharge.ConvertOptions) (supercharge.ConvertReport, error) {
+	leftFile, err := ctx.outputFile(romFile, "_L", opts)
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+	rightFile, err := ctx.outputFile(romFile, "_R", opts)
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+
+	rom, err := readROM(romFile)
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+
+	left, err := ctx.create(leftFile)
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+	defer left.Close()
+
+	right, err := ctx.create(rightFile)
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+	defer right.Close()
+
+	rep, err := supercharge.ConvertSplit(rom, left, right, opts)
+	if err != nil {
+		ctx.remove(left, leftFile)
+		ctx.remove(right, rightFile)
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+
+	for _, f := range []io.WriteCloser{left, right} {
+		err = f.Close()
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+	}
+
+	return rep, nil
+}
+
+// processRotating converts rom data to a sequence of wav files, none of which
+// are larger than the maximum file size. the files are named after the output
+// file with a two digit suffix
+//
+// if the conversion fails or is interrupted every file in the sequence is
+// removed. the files are only useful as a complete set
+func processRotating(ctx context, rom []byte, romFile string, outFile string) (supercharge.ConvertReport, error) {
+	stem, _ := strings.CutSuffix(outFile, filepath.Ext(outFile))
+
+	var files []io.WriteCloser
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	removeAll := func() {
+		for i, f := range files {
+			ctx.remove(f, names[i])
+		}
+	}
+
+	create := func(n int) (io.Writer, error) {
+		name := fmt.Sprintf("%s_%02d.wav", stem, n)
+		if ctx.exists(name) {
+			return nil, fmt.Errorf("%s already exists", filepath.Base(name))
+		}
+		f, err := ctx.create(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		names = append(names, name)
+		return f, nil
+	}
+
+	rep, err := ctx.converter.ConvertEncoder(rom, supercharge.NewRotatingEncoder(create, ctx.maxFileSize))
+	if err != nil {
+		removeAll()
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+
+	for _, f := range files {
+		err = f.Close()
+		if err != nil {
+			removeAll()
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+	}
+
+	return rep, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// TestMain runs the program instead of the tests when the test binary is
+// started by runMain(). the test binary also stands in for the lame encoder
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "lame" {
+		err := fakeLame()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if os.Getenv("SUPERCHARGE_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the program in the directory with the arguments. the stdin
+// data is given to the program as its standard input. the output to stdout and
+// stderr is returned with the exit code
+func runMain(t *testing.T, dir string, stdin []byte, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SUPERCHARGE_TEST_MAIN=1")
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), stderr.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// testROM returns a ROM of the size with pseudo-random content and a reset
+// vector of $F000. the same as the testROM function of the supercharge package
+func testROM(size int) []byte {
+	rom := make([]byte, size)
+	x := uint32(size)
+	for i := range rom {
+		x = x*1664525 + 1013904223
+		rom[i] = byte(x >> 24)
+	}
+	rom[size-4] = 0x00
+	rom[size-3] = 0xf0
+	return rom
+}
+
+// writeFile writes the data to the named file in the directory
+func writeFile(t *testing.T, dir string, name string, data []byte) string {
+	t.Helper()
+	f := filepath.Join(dir, name)
+	err := os.WriteFile(f, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// readFile returns the content of the named file in the directory
+func readFile(t *testing.T, dir string, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStreamTarget(t *testing.T) {
+	dir := t.TempDir()
+	rom := testROM(4096)
+	writeFile(t, dir, "game.bin", rom)
+
+	_, stderr, code := runMain(t, dir, nil, "-q", "-target", "stream", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	// the output is the header followed by the packets, with no tones
+	stream, rep, err := supercharge.BuildStream(rom, supercharge.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readFile(t, dir, "game.stream")
+	if len(data) != 8+len(rep.Blocks)*258 {
+		t.Errorf("stream is %d bytes but should be %d", len(data), 8+len(rep.Blocks)*258)
+	}
+	hdr := rep.Header.Bytes()
+	if !bytes.Equal(data[:8], hdr[:]) {
+		t.Errorf("stream starts with % x but the header is % x", data[:8], hdr)
+	}
+	if !bytes.Equal(data, stream) {
+		t.Errorf("stream is different to the stream of BuildStream")
+	}
+}
+
+func TestTapeLengthOutput(t *testing.T) {
+	dir := t.TempDir()
+	rom := testROM(4096)
+	writeFile(t, dir, "game.bin", rom)
+
+	rep, err := supercharge.ConvertWithOptions(rom, io.Discard, supercharge.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ips := range []float64{1.875, 3.75} {
+		stdout, stderr, code := runMain(t, dir, nil, "-o", "-ips", fmt.Sprint(ips), "game.bin")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		feet := supercharge.TapeLength(rep.Duration, ips)
+		expected := fmt.Sprintf("tape length: %.1f ft (%.1f m) at %g ips", feet, feet*0.3048, ips)
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("output does not contain %q: %s", expected, stdout)
+		}
+	}
+}
+
+func TestFilenameTags(t *testing.T) {
+	for _, tc := range []struct {
+		opts supercharge.ConvertOptions
+		tags string
+	}{
+		{supercharge.ConvertOptions{}, "44100_sine"},
+		{supercharge.ConvertOptions{SampleRate: 22050, BitDepth: 16}, "22050_16bit_sine"},
+		{supercharge.ConvertOptions{SampleRate: 22050, ResampleTo: 48000}, "48000_sine"},
+		{supercharge.ConvertOptions{Channels: 2, ChannelMode: supercharge.ChannelLeft}, "44100_stereo_left_sine"},
+		{supercharge.ConvertOptions{Waveform: supercharge.WaveformSquare, BandLimited: true}, "44100_square_bandlimited"},
+		{supercharge.ConvertOptions{BandLimited: true}, "44100_sine"},
+
+		// the resample rate of the profile is included in the tags
+		{supercharge.ConvertOptions{DeviceProfile: supercharge.ProfileModernSoundcard}, "48000_sine"},
+	} {
+		tags := filenameTags(tc.opts)
+		if tags != tc.tags {
+			t.Errorf("tags for %+v are %q, expected %q", tc.opts, tags, tc.tags)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFile(t, dir, "game.bin", testROM(4096))
+	_, stderr, code := runMain(t, dir, nil, "-q", "-tag-filename", "-bits", "16", "-stereo", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	readFile(t, dir, "game_44100_16bit_stereo_sine.wav")
+}
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "game.bin", testROM(4096))
+	_, stderr, code := runMain(t, dir, nil, "-q", "-stereo", "-bits", "16", "-split", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	left := readFile(t, dir, "game_L.wav")
+	right := readFile(t, dir, "game_R.wav")
+
+	_, stderr, code = runMain(t, dir, nil, "-q", "-stereo", "-bits", "16", "-out", "stereo.wav", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	stereo := readFile(t, dir, "stereo.wav")
+
+	// the samples of each file are the samples of one channel of the stereo
+	// output. the files have the same length of header
+	if len(left) != len(right) || len(stereo)-len(left) != len(left)-44 {
+		t.Fatalf("split files are %d and %d bytes and the stereo file is %d bytes", len(left), len(right), len(stereo))
+	}
+	for i := 0; i < (len(left)-44)/2; i++ {
+		if !bytes.Equal(stereo[44+i*4:44+i*4+2], left[44+i*2:44+i*2+2]) || !bytes.Equal(stereo[44+i*4+2:44+i*4+4], right[44+i*2:44+i*2+2]) {
+			t.Fatalf("sample %d of the split files is different to the stereo file", i)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// mp3Warning is displayed whenever MP3 output is selected. lossy compression
+// discards detail from the audio and the tones may no longer be recognised by
+// the Supercharger
+const mp3Warning = "warning: MP3 compression can damage the tones. MP3 files are for sharing and may not load on real hardware"
+
+// convertMP3 converts the rom data to an MP3 file using the external lame
+// encoder. the rom filename and the SHA-1 hash of the rom data are written to
+// the ID3 title and comment tags, ahead of the MP3 data created by lame
+func convertMP3(ctx context, rom []byte, romFile string, outFile string, opts supercharge.ConvertOptions) (supercharge.ConvertReport, error) {
+	lame, err := exec.LookPath("lame")
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("mp3: lame encoder not found: %w", err)
+	}
+
+	// the samples are passed to lame as raw PCM data
+	var pcm bytes.Buffer
+	rep, err := ctx.converter.ConvertEncoder(rom, supercharge.NewRawEncoder(&pcm))
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+
+	hz := int(rep.SampleRate)
+	sample := []string{"--bitwidth", "8", "--unsigned"}
+	if rep.BitDepth == 16 {
+		sample = []string{"--bitwidth", "16", "--signed", "--little-endian"}
+	}
+	mode := "m"
+	if opts.Channels == 2 {
+		mode = "s"
+	}
+
+	// lame writes the MP3 data to stdout without any tags
+	args := []string{"--quiet", "-r", "-s", strconv.FormatFloat(float64(hz)/1000, 'f', -1, 64)}
+	args = append(args, sample...)
+	args = append(args, "-m", mode,
+		"-b", strconv.Itoa(ctx.mp3Bitrate),
+		"-", "-")
+	cmd := exec.Command(lame, args...)
+	cmd.Stdin = &pcm
+	var mp3, stderr bytes.Buffer
+	cmd.Stdout = &mp3
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("mp3: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	w, err := ctx.create(outFile)
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+	defer w.Close()
+
+	title, _ := strings.CutSuffix(filepath.Base(romFile), filepath.Ext(romFile))
+	err = supercharge.WriteID3(w, title, fmt.Sprintf("sha1:%x", sha1.Sum(rom)))
+	if err == nil {
+		_, err = w.Write(mp3.Bytes())
+	}
+	if err != nil {
+		ctx.remove(w, outFile)
+		return supercharge.ConvertReport{}, fmt.Errorf("mp3: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("mp3: %w", err)
+	}
+
+	return rep, nil
+}
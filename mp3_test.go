@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// the bitrates in kbps and the sample rates of MPEG-1 Layer III frames, in the
+// order of their index in the frame header
+var mp3Bitrates = []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+var mp3Rates = []int{44100, 48000, 32000}
+
+// the samples in each MPEG-1 Layer III frame
+const mp3FrameSamples = 1152
+
+func indexOf(values []int, v int) int {
+	for i, x := range values {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// fakeLame is run by TestMain in place of the lame encoder. the raw PCM data
+// on stdin is written to stdout as MPEG-1 Layer III frames of silence, with
+// frame headers for the sample rate, channel mode and bitrate given in the
+// arguments
+func fakeLame() error {
+	bitwidth, rate, bitrate := 8, 0, 0
+	mode := ""
+	args := os.Args[1:]
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--bitwidth":
+			bitwidth, _ = strconv.Atoi(args[i+1])
+		case "-s":
+			khz, _ := strconv.ParseFloat(args[i+1], 64)
+			rate = int(khz * 1000)
+		case "-m":
+			mode = args[i+1]
+		case "-b":
+			bitrate, _ = strconv.Atoi(args[i+1])
+		}
+	}
+	rateIdx := indexOf(mp3Rates, rate)
+	bitrateIdx := indexOf(mp3Bitrates, bitrate)
+	if rateIdx < 0 || bitrateIdx < 1 {
+		return fmt.Errorf("unsupported sample rate or bitrate (%d, %d)", rate, bitrate)
+	}
+	channels := 1
+	modeBits := byte(0x03)
+	if mode == "s" {
+		channels = 2
+		modeBits = 0x00
+	}
+
+	pcm, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	samples := len(pcm) / (bitwidth / 8) / channels
+	frames := (samples + mp3FrameSamples - 1) / mp3FrameSamples
+
+	// frames without padding
+	frame := make([]byte, 144*bitrate*1000/rate)
+	frame[0] = 0xff
+	frame[1] = 0xfb
+	frame[2] = byte(bitrateIdx<<4 | rateIdx<<2)
+	frame[3] = modeBits << 6
+	for i := 0; i < frames; i++ {
+		_, err := os.Stdout.Write(frame)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestMP3(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	err = os.Symlink(exe, filepath.Join(bin, "lame"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		args     []string
+		opts     supercharge.ConvertOptions
+		rate     int
+		bitrate  int
+		channels int
+	}{
+		{nil, supercharge.ConvertOptions{}, 44100, 320, 1},
+		{[]string{"-stereo", "-bits", "16", "-rate", "48000", "-mp3-bitrate", "128"}, supercharge.ConvertOptions{Channels: 2, BitDepth: 16, SampleRate: 48000}, 48000, 128, 2},
+	} {
+		dir := t.TempDir()
+		writeFile(t, dir, "game.bin", rom)
+		args := append([]string{"-q", "-format", "mp3"}, tc.args...)
+		_, stderr, code := runMain(t, dir, nil, append(args, "game.bin")...)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tc.args, code, stderr)
+		}
+		data := readFile(t, dir, "game.mp3")
+
+		// the ID3 tag is ahead of the first frame
+		if !bytes.HasPrefix(data, []byte("ID3")) {
+			t.Fatalf("%v: mp3 file does not start with an ID3 tag", tc.args)
+		}
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		frames := data[10+size:]
+
+		var count int
+		for len(frames) > 0 {
+			if len(frames) < 4 || frames[0] != 0xff || frames[1]&0xfe != 0xfa {
+				t.Fatalf("%v: frame %d has no frame header", tc.args, count)
+			}
+			rate := mp3Rates[frames[2]>>2&0x03]
+			bitrate := mp3Bitrates[frames[2]>>4]
+			channels := 2
+			if frames[3]>>6 == 0x03 {
+				channels = 1
+			}
+			if rate != tc.rate || bitrate != tc.bitrate || channels != tc.channels {
+				t.Fatalf("%v: frame %d is %dHz, %dkbps, %d channels", tc.args, count, rate, bitrate, channels)
+			}
+			l := 144*bitrate*1000/rate + int(frames[2]>>1&0x01)
+			if l > len(frames) {
+				t.Fatalf("%v: frame %d is incomplete", tc.args, count)
+			}
+			frames = frames[l:]
+			count++
+		}
+
+		// the frames hold all the samples of the conversion
+		var w bytes.Buffer
+		rep, err := supercharge.ConvertWithOptions(rom, &w, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		duration := float64(count*mp3FrameSamples) / float64(tc.rate)
+		if duration < rep.Duration || duration > rep.Duration+float64(mp3FrameSamples)/float64(tc.rate) {
+			t.Errorf("%v: %d frames last %.3fs but the conversion is %.3fs", tc.args, count, duration, rep.Duration)
+		}
+	}
+}
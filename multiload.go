@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// notMultiload is returned by processMultiload for files that should be
+// converted as a single ROM
+var notMultiload = errors.New("not a multiload file")
+
+// processMultiload converts a file that contains several loads into a single
+// output file. the file is either a Supercharger load image (eg. an .ar or .mlt file)
+// or a sequence of ROMs of the same size
+//
+// how the file is treated depends on the multiload option. in auto mode only a
+// Supercharger load image is a multiload file. a file is only split into loads
+// of -load-size bytes if the split mode is chosen
+func processMultiload(ctx context, romFile string) ([]supercharge.ConvertReport, error) {
+	if ctx.multiload == "off" || ctx.target != "tape" || !ctx.multiloadFormat() || ctx.split {
+		return nil, notMultiload
+	}
+
+	// errors reading the file are reported when the file is processed as a
+	// single ROM
+	data, err := os.ReadFile(romFile)
+	if err != nil {
+		return nil, notMultiload
+	}
+
+	var loads [][]byte
+	image := ctx.multiload == "image"
+	switch ctx.multiload {
+	case "auto":
+		if supercharge.Validate(data) == nil {
+			return nil, notMultiload
+		}
+		// any other file is converted as a single ROM, which will fail if
+		// the file is not a supported size
+		if !supercharge.IsLoadImage(data) {
+			return nil, notMultiload
+		}
+		image = true
+	case "split":
+		loads, err = supercharge.SplitLoads(data, ctx.loadSize)
+		if err != nil {
+			return nil, skipError{fmt.Errorf("%s skipped: %w", filepath.Base(romFile), err)}
+		}
+	}
+
+	opts := ctx.options()
+	outFile, err := ctx.outputFile(romFile, "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := ctx.create(outFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+	defer w.Close()
+
+	var reps []supercharge.ConvertReport
+	if image {
+		reps, err = supercharge.ConvertLoadImageEncoder(data, ctx.encoder(w), opts)
+	} else {
+		reps, err = supercharge.ConvertMultiloadEncoder(loads, ctx.encoder(w), opts)
+	}
+	if err != nil {
+		ctx.remove(w, outFile)
+		return nil, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+
+	return reps, nil
+}
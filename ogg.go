@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// oggWarning is displayed whenever Ogg Vorbis output is selected. as with MP3,
+// lossy compression may leave tones that the Supercharger can not recognise
+const oggWarning = "warning: Ogg Vorbis compression can damage the tones. Ogg files are for sharing and may not load on real hardware"
+
+// depth16 passes samples to an Encoder as 16 bit samples, whatever the depth of
+// the conversion
+type depth16 struct {
+	supercharge.Encoder
+}
+
+func (enc depth16) WriteHeader(f supercharge.Format) error {
+	f.BitDepth = 16
+	return enc.Encoder.WriteHeader(f)
+}
+
+// convertOgg converts the rom data to an Ogg Vorbis file using the external
+// oggenc encoder. the rom filename and the SHA-1 hash of the rom data are
+// written to the title and comment tags
+func convertOgg(ctx context, rom []byte, romFile string, outFile string, opts supercharge.ConvertOptions) (supercharge.ConvertReport, error) {
+	oggenc, err := exec.LookPath("oggenc")
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("ogg: oggenc encoder not found: %w", err)
+	}
+
+	// the samples are passed to oggenc as raw 16 bit PCM data. the meaning of
+	// 8 bit raw data is not the same for every version of oggenc
+	var pcm bytes.Buffer
+	rep, err := ctx.converter.ConvertEncoder(rom, depth16{supercharge.NewRawEncoder(&pcm)})
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+
+	title, _ := strings.CutSuffix(filepath.Base(romFile), filepath.Ext(romFile))
+	cmd := exec.Command(oggenc, "--quiet",
+		"-r", "-B", "16", "-C", strconv.Itoa(rep.Channels), "-R", strconv.Itoa(int(rep.SampleRate)), "--raw-endianness", "0",
+		"-q", strconv.FormatFloat(ctx.oggQuality, 'f', -1, 64),
+		"-t", title, "-c", fmt.Sprintf("comment=sha1:%x", sha1.Sum(rom)),
+		"-o", outFile, "-")
+	cmd.Stdin = &pcm
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("ogg: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	return rep, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// processPipe converts rom data and writes the output to w, which is normally
+// stdout. the rom is read from stdin if the stdin option is set, otherwise it
+// is read from the named file
+//
+// a Supercharger load image is converted as a whole, in the same way as
+// processMultiload, and there is one report for each load
+func processPipe(ctx context, romFile string, w io.Writer) ([]supercharge.ConvertReport, error) {
+	var r io.Reader = os.Stdin
+	if !ctx.stdin {
+		f, err := os.Open(romFile)
+		if err != nil {
+			return nil, inputError{fmt.Errorf("%s: %w", filepath.Base(romFile), err)}
+		}
+		defer f.Close()
+		r = f
+	}
+	rom, err := readAllFrom(r, romFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.multiload != "off" && ctx.target == "tape" && ctx.multiloadFormat() && supercharge.IsLoadImage(rom) {
+		reps, err := supercharge.ConvertLoadImageEncoder(rom, ctx.encoder(w), ctx.options())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return reps, nil
+	}
+
+	rep, err := pipeROM(ctx, romFile, rom, w)
+	if err != nil {
+		return nil, err
+	}
+	return []supercharge.ConvertReport{rep}, nil
+}
+
+// pipeROM converts a single rom for processPipe
+func pipeROM(ctx context, romFile string, rom []byte, w io.Writer) (supercharge.ConvertReport, error) {
+	err := validateROM(rom, romFile)
+	if err != nil {
+		return supercharge.ConvertReport{}, err
+	}
+
+	// the stream target is the data that would be represented by the tones
+	if ctx.target == "stream" {
+		stream, rep, err := supercharge.BuildStream(rom, ctx.options())
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		_, err = w.Write(stream)
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return rep, nil
+	}
+
+	if ctx.target == "ar" {
+		rep, err := supercharge.ConvertToAR(rom, w, ctx.options())
+		if err != nil {
+			return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+		}
+		return rep, nil
+	}
+
+	rep, err := ctx.converter.ConvertEncoder(rom, ctx.encoder(w))
+	if err != nil {
+		return supercharge.ConvertReport{}, fmt.Errorf("%s: %w", filepath.Base(romFile), err)
+	}
+
+	return rep, nil
+}
+
+// isDevNull returns true if the file is the null device. the null device is a
+// character device but it is not a terminal
+func isDevNull(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info, null)
+}
+
+// processStdin converts rom data read from stdin and writes the output to the
+// file named by the out option
+func processStdin(ctx context) ([]supercharge.ConvertReport, error) {
+	opts := ctx.options()
+	outFile, err := ctx.outputFile("stdin", "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mp3 and ogg output files are created by an external encoder, in the
+	// same way as for a named rom file
+	if ctx.target == "tape" && (ctx.format == "mp3" || ctx.format == "ogg") {
+		rom, err := readROMFrom(os.Stdin, "stdin")
+		if err != nil {
+			return nil, err
+		}
+		var rep supercharge.ConvertReport
+		if ctx.format == "mp3" {
+			rep, err = convertMP3(ctx, rom, "stdin", outFile, opts)
+		} else {
+			rep, err = convertOgg(ctx, rom, "stdin", outFile, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %w", err)
+		}
+		return []supercharge.ConvertReport{rep}, nil
+	}
+
+	w, err := ctx.create(outFile)
+	if err != nil {
+		return nil, fmt.Errorf("stdin: %w", err)
+	}
+	defer w.Close()
+
+	reps, err := processPipe(ctx, "stdin", w)
+	if err != nil {
+		ctx.remove(w, outFile)
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("stdin: %w", err)
+	}
+	return reps, nil
+}
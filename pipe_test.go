@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+func TestPipe(t *testing.T) {
+	dir := t.TempDir()
+	rom := testROM(4096)
+	writeFile(t, dir, "game.bin", rom)
+
+	// the output of a normal conversion to a file
+	_, stderr, code := runMain(t, dir, nil, "-q", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	expected := readFile(t, dir, "game.wav")
+	data, err := supercharge.Decode(bytes.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, rom) {
+		t.Fatalf("output decodes to data different to the ROM")
+	}
+
+	// the output written to stdout is the same as the output file. nothing
+	// else is written to stdout
+	for _, tc := range []struct {
+		stdin []byte
+		args  []string
+	}{
+		{rom, []string{"-stdin", "-stdout"}},
+		{rom, []string{"-"}},
+		{nil, []string{"-stdout", "game.bin"}},
+	} {
+		stdout, stderr, code := runMain(t, dir, tc.stdin, tc.args...)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tc.args, code, stderr)
+		}
+		if !bytes.Equal([]byte(stdout), expected) {
+			t.Errorf("%v: output to stdout is different to the output file", tc.args)
+		}
+	}
+
+	// the rom from stdin is written to the named output file
+	_, stderr, code = runMain(t, dir, rom, "-q", "-stdin", "-out", "piped.wav")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !bytes.Equal(readFile(t, dir, "piped.wav"), expected) {
+		t.Errorf("output file for stdin is different to the output file for the rom")
+	}
+
+	// the stream target can also be piped
+	stdout, stderr, code := runMain(t, dir, rom, "-stdin", "-stdout", "-target", "stream")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	stream, _, err := supercharge.BuildStream(rom, supercharge.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(stdout), stream) {
+		t.Errorf("stream written to stdout is different to the stream of BuildStream")
+	}
+
+	// nothing is written to stdout for a rom that can not be converted
+	stdout, _, code = runMain(t, dir, make([]byte, 100), "-stdin", "-stdout")
+	if code != 1 || stdout != "" {
+		t.Errorf("exit code %d and %d bytes to stdout for a bad rom", code, len(stdout))
+	}
+
+	// stdin needs somewhere to write the output
+	_, _, code = runMain(t, dir, rom, "-stdin")
+	if code != 1 {
+		t.Errorf("exit code %d for stdin without an output", code)
+	}
+}
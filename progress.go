@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// the number of characters in the bar of the progress display
+const progressWidth = 40
+
+// progressBar displays the progress of the current conversion. the display is
+// redrawn on a single line
+type progressBar struct {
+	w    io.Writer
+	name string
+
+	// the display has been drawn at least once for the current file
+	drawn bool
+	done  bool
+}
+
+// start the progress display for the named file. nothing is displayed until
+// the first update
+func (bar *progressBar) start(name string) {
+	bar.name = name
+	bar.drawn = false
+	bar.done = false
+}
+
+// update redraws the progress display. it is suitable for use as the Progress
+// conversion option
+func (bar *progressBar) update(block int, total int) {
+	if bar.done || total <= 0 {
+		return
+	}
+	n := block * progressWidth / total
+	pct := block * 100 / total
+	bar.drawn = true
+	fmt.Fprintf(bar.w, "\r%s [%s%s] %3d%%", bar.name, strings.Repeat("#", n), strings.Repeat(".", progressWidth-n), pct)
+	if block >= total {
+		bar.finish()
+	}
+}
+
+// finish ends the progress display. the display is also finished when the
+// progress reaches 100%
+func (bar *progressBar) finish() {
+	if bar.done {
+		return
+	}
+	bar.done = true
+	if bar.drawn {
+		fmt.Fprintln(bar.w)
+	}
+}
+
+// isTerminal returns true if the file is connected to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+func TestProgressBar(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		var b bytes.Buffer
+		bar := &progressBar{w: &b}
+		bar.start("game.bin")
+
+		opts := supercharge.Default()
+		opts.Progress = bar.update
+		_, err := supercharge.ConvertWithOptions(testROM(size), io.Discard, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the display is redrawn for each block and ends with a full bar and
+		// a newline
+		out := b.String()
+		if !strings.HasSuffix(out, "\n") {
+			t.Fatalf("%d bytes: display does not end with a newline: %q", size, out)
+		}
+		draws := strings.Split(strings.TrimPrefix(strings.TrimSuffix(out, "\n"), "\r"), "\r")
+		if len(draws) < size/256 {
+			t.Errorf("%d bytes: display drawn %d times", size, len(draws))
+		}
+		last := fmt.Sprintf("game.bin [%s] 100%%", strings.Repeat("#", progressWidth))
+		if draws[len(draws)-1] != last {
+			t.Errorf("%d bytes: final display is %q", size, draws[len(draws)-1])
+		}
+
+		// the progress never goes backwards
+		prev := -1
+		for _, d := range draws {
+			var pct int
+			_, err := fmt.Sscanf(d[len(d)-4:], "%d%%", &pct)
+			if err != nil {
+				t.Fatalf("%d bytes: %v: %q", size, err, d)
+			}
+			if pct < prev {
+				t.Errorf("%d bytes: progress goes from %d%% to %d%%", size, prev, pct)
+			}
+			prev = pct
+		}
+
+		// nothing more is displayed once the bar has finished
+		l := b.Len()
+		bar.update(1, 2)
+		bar.finish()
+		if b.Len() != l {
+			t.Errorf("%d bytes: display changed after the bar finished", size)
+		}
+	}
+}
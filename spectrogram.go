@@ -0,0 +1,136 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/cmplx"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// the spectrogram is made from windows of this many samples. it must be a power
+// of two. the height of the image is half the window size
+const spectrogramWindow = 256
+
+// the largest width of the spectrogram image. long recordings have more
+// samples between each column of the image
+const spectrogramMaxWidth = 2000
+
+// the range of the spectrogram in decibels. quieter frequencies are black
+const spectrogramRange = 80.0
+
+// sampleRecorder is an Encoder that keeps the samples of the first channel
+type sampleRecorder struct {
+	channels int
+	samples  []float64
+}
+
+func (rec *sampleRecorder) WriteHeader(f supercharge.Format) error {
+	rec.channels = f.Channels
+	return nil
+}
+
+func (rec *sampleRecorder) WriteSamples(samples []float64) error {
+	for i := 0; i < len(samples); i += rec.channels {
+		rec.samples = append(rec.samples, samples[i])
+	}
+	return nil
+}
+
+func (rec *sampleRecorder) Finalize() error {
+	return nil
+}
+
+// spectrogram returns a grayscale image of the frequencies in the samples. time
+// is along the horizontal axis and frequency increases up the vertical axis
+func spectrogram(samples []float64) *image.Gray {
+	height := spectrogramWindow / 2
+
+	hop := spectrogramWindow / 2
+	if len(samples)/hop > spectrogramMaxWidth {
+		hop = len(samples)/spectrogramMaxWidth + 1
+	}
+	width := 1
+	if len(samples) > spectrogramWindow {
+		width = (len(samples)-spectrogramWindow)/hop + 1
+	}
+
+	// hann window
+	window := make([]float64, spectrogramWindow)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(spectrogramWindow-1))
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	buf := make([]complex128, spectrogramWindow)
+	for x := 0; x < width; x++ {
+		for i := range buf {
+			var s float64
+			if p := x*hop + i; p < len(samples) {
+				s = samples[p]
+			}
+			buf[i] = complex(s*window[i], 0)
+		}
+		fft(buf)
+
+		for y := 0; y < height; y++ {
+			mag := cmplx.Abs(buf[y]) / float64(spectrogramWindow/4)
+			db := 20 * math.Log10(math.Max(mag, 1e-10))
+			v := (db + spectrogramRange) / spectrogramRange
+			v = math.Max(0, math.Min(1, v))
+			img.SetGray(x, height-1-y, color.Gray{Y: uint8(v * 255)})
+		}
+	}
+
+	return img
+}
+
+// fft is an in-place radix-2 fast fourier transform. the length of the data
+// must be a power of two
+func fft(data []complex128) {
+	n := len(data)
+
+	// bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			t := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := data[start+k]
+				b := data[start+k+size/2] * t
+				data[start+k] = a + b
+				data[start+k+size/2] = a - b
+				t *= w
+			}
+		}
+	}
+}
+
+// writeSpectrogram writes a spectrogram of the samples to a PNG file
+func writeSpectrogram(ctx context, pngFile string, samples []float64) error {
+	f, err := ctx.create(pngFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = png.Encode(f, spectrogram(samples))
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
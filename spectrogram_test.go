@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestSpectrogram(t *testing.T) {
+	for _, tc := range []struct {
+		samples int
+		width   int
+	}{
+		{0, 1},
+		{100, 1},
+		{spectrogramWindow, 1},
+		{1000, 6},
+		{44100, 343},
+
+		// long recordings are limited to the largest width
+		{1000000, 1996},
+	} {
+		img := spectrogram(make([]float64, tc.samples))
+		b := img.Bounds()
+		if b.Dx() != tc.width || b.Dy() != spectrogramWindow/2 {
+			t.Errorf("%d samples: image is %dx%d but should be %dx%d", tc.samples, b.Dx(), b.Dy(), tc.width, spectrogramWindow/2)
+		}
+		if b.Dx() > spectrogramMaxWidth {
+			t.Errorf("%d samples: image is wider than %d", tc.samples, spectrogramMaxWidth)
+		}
+	}
+
+	// a tone with a period of eight samples is in the 32nd frequency bin. the
+	// brightest row is the same distance from the bottom of the image
+	samples := make([]float64, 4096)
+	for i := range samples {
+		samples[i] = math.Sin(2 * math.Pi * float64(i) / 8)
+	}
+	img := spectrogram(samples)
+	height := img.Bounds().Dy()
+	for x := 0; x < img.Bounds().Dx(); x++ {
+		brightest := 0
+		for y := 0; y < height; y++ {
+			if img.GrayAt(x, y).Y > img.GrayAt(x, brightest).Y {
+				brightest = y
+			}
+		}
+		if brightest != height-1-32 {
+			t.Fatalf("column %d: brightest row is %d but should be %d", x, brightest, height-1-32)
+		}
+	}
+}
+
+func TestSpectrogramOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "game.bin", testROM(4096))
+	_, stderr, code := runMain(t, dir, nil, "-q", "-spectrogram", "game.bin")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	// the width of the image depends on the number of samples in the WAV file
+	wav := readFile(t, dir, "game.wav")
+	frames := int(binary.LittleEndian.Uint32(wav[40:44]))
+	hop := spectrogramWindow / 2
+	if frames/hop > spectrogramMaxWidth {
+		hop = frames/spectrogramMaxWidth + 1
+	}
+	width := (frames-spectrogramWindow)/hop + 1
+
+	img, err := png.Decode(bytes.NewReader(readFile(t, dir, "game.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	if b.Dx() != width || b.Dy() != spectrogramWindow/2 {
+		t.Errorf("spectrogram is %dx%d but should be %dx%d", b.Dx(), b.Dy(), width, spectrogramWindow/2)
+	}
+}
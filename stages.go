@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// stageFiles returns the files in a set of numbered multiload stages, starting
+// with the named file. Stage files have a numeric extension (game.1, game.2,
+// etc.) and the numbers must form an unbroken sequence. The number of the first
+// stage is also returned
+func stageFiles(first string) ([]string, int, error) {
+	ext := filepath.Ext(first)
+	start, err := strconv.Atoi(strings.TrimPrefix(ext, "."))
+	if err != nil || start < 0 {
+		return nil, 0, fmt.Errorf("%s: not a numbered stage file", filepath.Base(first))
+	}
+	base := strings.TrimSuffix(filepath.Base(first), ext)
+
+	entries, err := os.ReadDir(filepath.Dir(first))
+	if err != nil {
+		return nil, 0, inputError{fmt.Errorf("%s: %w", filepath.Base(first), err)}
+	}
+
+	// collect the stage numbers of all sibling files with the same base name
+	var numbers []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(name, base+"."))
+		if err != nil || n < start {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	var files []string
+	for i, n := range numbers {
+		if n != start+i {
+			return nil, 0, inputError{fmt.Errorf("%s: stage %d is missing", base, start+i)}
+		}
+		files = append(files, filepath.Join(filepath.Dir(first), fmt.Sprintf("%s.%d", base, n)))
+	}
+
+	return files, start, nil
+}
+
+// processStages converts a set of numbered multiload stages into a single
+// output file. the multiload index of each stage is taken from the stage number
+func processStages(ctx context, firstFile string) ([]supercharge.ConvertReport, error) {
+	if ctx.target != "tape" {
+		return nil, fmt.Errorf("%s: multiload stages can only be converted to the tape target", filepath.Base(firstFile))
+	}
+
+	files, start, err := stageFiles(firstFile)
+	if err != nil {
+		return nil, err
+	}
+	if start > 255 {
+		return nil, fmt.Errorf("%s: stage number is too large for a multiload index", filepath.Base(firstFile))
+	}
+
+	opts := ctx.options()
+	opts.Multiload = byte(start)
+
+	// read and validate all stages before creating the output file
+	var loads [][]byte
+	for _, f := range files {
+		rom, err := os.ReadFile(f)
+		if err != nil {
+			return nil, inputError{fmt.Errorf("%s: %w", filepath.Base(f), err)}
+		}
+		err = supercharge.Validate(rom)
+		if err != nil {
+			return nil, skipError{fmt.Errorf("%s skipped: %w", filepath.Base(f), err)}
+		}
+		loads = append(loads, rom)
+	}
+
+	outFile, err := ctx.outputFile(firstFile, "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := ctx.create(outFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(firstFile), err)
+	}
+	defer w.Close()
+
+	reps, err := supercharge.ConvertMultiloadEncoder(loads, ctx.encoder(w), opts)
+	if err != nil {
+		ctx.remove(w, outFile)
+		return nil, fmt.Errorf("%s: %w", filepath.Base(firstFile), err)
+	}
+
+	// an output file that can not be closed is incomplete
+	err = w.Close()
+	if err != nil {
+		ctx.remove(w, outFile)
+		return nil, fmt.Errorf("%s: %w", filepath.Base(firstFile), err)
+	}
+
+	return reps, nil
+}
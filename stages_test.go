@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+func TestStages(t *testing.T) {
+	dir := t.TempDir()
+	var stages [][]byte
+	for i, size := range []int{4096, 2048, 6144} {
+		rom := testROM(size)
+		writeFile(t, dir, fmt.Sprintf("game.%d", i+1), rom)
+		stages = append(stages, rom)
+	}
+
+	files, start, err := stageFiles(filepath.Join(dir, "game.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || start != 1 {
+		t.Fatalf("found %d stages starting at %d", len(files), start)
+	}
+
+	_, stderr, code := runMain(t, dir, nil, "-q", "-stages", "game.1")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	// the combined output decodes to the stages in order
+	loads, err := supercharge.DecodeMultiload(bytes.NewReader(readFile(t, dir, "game.wav")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loads) != len(stages) {
+		t.Fatalf("decoded %d loads from %d stages", len(loads), len(stages))
+	}
+	for i := range loads {
+		if !bytes.Equal(loads[i], stages[i]) {
+			t.Errorf("load %d is different to stage %d", i, i+1)
+		}
+	}
+}
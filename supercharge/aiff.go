@@ -0,0 +1,232 @@
+package supercharge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// NewAIFFEncoder returns an Encoder that writes samples to an AIFF file. The
+// samples are the same as those in a WAV file but are stored as signed
+// big-endian values. The samples are 8 bit values, or 16 bit values if the
+// BitDepth field of the Format is 16. AIFF has no floating point samples so the
+// Float32 field of the Format is ignored
+//
+// Cue points are written to a MARK chunk, with one marker for each cue. In the
+// same way as the WAV encoder, the AIFF file is written to the io.Writer as the
+// samples are received when the Encoder is used by one of the conversion
+// functions. Otherwise the samples are kept until the Encoder is finalized
+func NewAIFFEncoder(w io.Writer) Encoder {
+	return &aiff{w: w}
+}
+
+// aiff collects samples and encodes them as an AIFF file
+type aiff struct {
+	w io.Writer
+
+	channels uint16
+	hz       uint32
+	depth    uint16
+	rounding Rounding
+
+	// the frame position of the start of each data packet. the positions
+	// are written to a MARK chunk if there are any
+	cues []int
+
+	// if stream is true the length of the audio and the number of cues are
+	// known in advance. the header is written by WriteHeader() and samples
+	// are written as they are received. dataLen counts the bytes of samples
+	// written so far
+	stream       bool
+	expectFrames int
+	expectCues   int
+	dataLen      int
+	out          *bufio.Writer
+
+	// the samples of an aiff that is not being streamed
+	data bytes.Buffer
+
+	// reusable buffer for the encoded samples
+	buf []byte
+}
+
+// expect implements the sizedEncoder interface
+func (enc *aiff) expect(frames int, cues int) {
+	enc.stream = true
+	enc.expectFrames = frames
+	enc.expectCues = cues
+	enc.out = bufio.NewWriter(enc.w)
+}
+
+func (enc *aiff) WriteHeader(f Format) error {
+	if f.Channels < 1 || f.Channels > 2 {
+		return fmt.Errorf("aiff: unsupported number of channels (%d)", f.Channels)
+	}
+
+	enc.channels = uint16(f.Channels)
+	enc.hz = f.SampleRate
+	enc.depth = 8
+	if f.BitDepth == 16 {
+		enc.depth = 16
+	}
+	enc.rounding = f.Rounding
+
+	if enc.stream {
+		_, err := enc.out.Write(enc.header(enc.expectFrames*int(enc.frameSize()), enc.expectCues))
+		return err
+	}
+
+	return nil
+}
+
+func (enc *aiff) WriteSamples(samples []float64) error {
+	// samples are always written as complete frames
+	if len(samples)%int(enc.channels) != 0 {
+		return fmt.Errorf("aiff: %d samples is not a whole number of frames", len(samples))
+	}
+
+	enc.buf = enc.buf[:0]
+	for _, s := range samples {
+		if enc.depth == 16 {
+			enc.buf = binary.BigEndian.AppendUint16(enc.buf, uint16(quantize16(s, enc.rounding)))
+			continue
+		}
+
+		// AIFF samples are signed. an 8 bit sample is the WAV sample offset
+		// by 128
+		enc.buf = append(enc.buf, quantize8(s, enc.rounding)-128)
+	}
+
+	if enc.stream {
+		enc.dataLen += len(enc.buf)
+		_, err := enc.out.Write(enc.buf)
+		return err
+	}
+	enc.data.Write(enc.buf)
+	return nil
+}
+
+// frameSize returns the number of bytes in a frame. a frame is one sample for
+// each channel
+func (enc *aiff) frameSize() uint16 {
+	return enc.channels * enc.depth / 8
+}
+
+func (enc *aiff) cue(frame int) {
+	enc.cues = append(enc.cues, frame)
+}
+
+func (enc *aiff) Finalize() error {
+	if enc.stream {
+		// the header has already been written so the audio must be exactly
+		// the length that was expected
+		expected := enc.expectFrames * int(enc.frameSize())
+		if enc.dataLen != expected {
+			return fmt.Errorf("aiff: %d bytes of samples written but %d bytes were expected", enc.dataLen, expected)
+		}
+		if len(enc.cues) != enc.expectCues {
+			return fmt.Errorf("aiff: %d cues written but %d were expected", len(enc.cues), enc.expectCues)
+		}
+		_, err := enc.out.Write(enc.trailer(enc.dataLen))
+		if err != nil {
+			return err
+		}
+		return enc.out.Flush()
+	}
+
+	var w bytes.Buffer
+	w.Write(enc.header(enc.data.Len(), len(enc.cues)))
+	w.Write(enc.data.Bytes())
+	w.Write(enc.trailer(enc.data.Len()))
+	_, err := enc.w.Write(w.Bytes())
+	return err
+}
+
+// sizeFor returns the number of bytes in an AIFF file with the given number of
+// bytes of samples and number of cues
+func (enc *aiff) sizeFor(dataLen int, cues int) int {
+	// FORM header and the COMM and SSND chunks
+	n := 12 + 8 + 18 + 8 + 8 + dataLen + dataLen&1
+	if cues > 0 {
+		n += 8 + 2 + cues*8
+	}
+	return n
+}
+
+// header returns the part of the AIFF file that comes before the samples, for
+// a file with the given number of bytes of samples and number of cues
+func (enc *aiff) header(dataLen int, cues int) []byte {
+	var w bytes.Buffer
+
+	// the FORM chunk contains every other chunk. the size does not include
+	// the chunk ID or the size field
+	w.Write([]byte("FORM"))
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(enc.sizeFor(dataLen, cues)-8)))
+	w.Write([]byte("AIFF"))
+
+	// the common chunk has the number of channels, the number of frames, the
+	// sample size and the sample rate. the sample rate is an 80 bit extended
+	// precision floating point value
+	w.Write([]byte("COMM"))
+	w.Write([]byte{0, 0, 0, 18})
+	w.Write(binary.BigEndian.AppendUint16(nil, enc.channels))
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(dataLen/int(enc.frameSize()))))
+	w.Write(binary.BigEndian.AppendUint16(nil, enc.depth))
+	w.Write(extended80(float64(enc.hz)))
+
+	// the sound data chunk has an offset and a block size before the samples.
+	// both are zero
+	w.Write([]byte("SSND"))
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(8+dataLen)))
+	w.Write([]byte{0, 0, 0, 0})
+	w.Write([]byte{0, 0, 0, 0})
+
+	return w.Bytes()
+}
+
+// trailer returns the part of the AIFF file that comes after the samples
+func (enc *aiff) trailer(dataLen int) []byte {
+	var w bytes.Buffer
+
+	// chunks must start on an even byte boundary. the pad byte is not
+	// included in the size of the sound data chunk
+	if dataLen&1 == 1 {
+		w.WriteByte(0)
+	}
+
+	// prepare marker chunk with one marker for each entry in the cues field.
+	// each marker is a two byte ID, a four byte frame position and an empty
+	// name. the empty name is a zero length byte and a pad byte
+	if len(enc.cues) > 0 {
+		w.Write([]byte("MARK"))
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(2+len(enc.cues)*8)))
+		w.Write(binary.BigEndian.AppendUint16(nil, uint16(len(enc.cues))))
+		for i, c := range enc.cues {
+			w.Write(binary.BigEndian.AppendUint16(nil, uint16(i+1)))
+			w.Write(binary.BigEndian.AppendUint32(nil, uint32(c)))
+			w.Write([]byte{0, 0})
+		}
+	}
+
+	return w.Bytes()
+}
+
+// extended80 returns the value as an 80 bit IEEE 754 extended precision
+// floating point number, as used for the sample rate of an AIFF file. the
+// value must be positive or zero
+func extended80(v float64) []byte {
+	b := make([]byte, 10)
+	if v <= 0 {
+		return b
+	}
+
+	// the mantissa of the extended format has an explicit integer bit. the
+	// 52 bits of the float64 fraction follow the integer bit
+	frac, exp := math.Frexp(v)
+	binary.BigEndian.PutUint16(b[0:2], uint16(exp-1+16383))
+	binary.BigEndian.PutUint64(b[2:10], uint64(frac*(1<<64)))
+	return b
+}
@@ -0,0 +1,134 @@
+package supercharge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestExtended80(t *testing.T) {
+	for _, tc := range []struct {
+		v        float64
+		expected []byte
+	}{
+		{44100, []byte{0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0}},
+		{48000, []byte{0x40, 0x0e, 0xbb, 0x80, 0, 0, 0, 0, 0, 0}},
+		{22050, []byte{0x40, 0x0d, 0xac, 0x44, 0, 0, 0, 0, 0, 0}},
+		{1, []byte{0x3f, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{0, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		if b := extended80(tc.v); !bytes.Equal(b, tc.expected) {
+			t.Errorf("%v is % x but should be % x", tc.v, b, tc.expected)
+		}
+	}
+}
+
+// aiffChunks returns the body of each chunk in the AIFF file, checking that
+// the size of the FORM chunk is the size of the file
+func aiffChunks(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	if len(data) < 12 || string(data[0:4]) != "FORM" || string(data[8:12]) != "AIFF" {
+		t.Fatalf("not an AIFF file")
+	}
+	if l := int(binary.BigEndian.Uint32(data[4:8])); l != len(data)-8 {
+		t.Fatalf("FORM size is %d but the file is %d bytes", l, len(data))
+	}
+	chunks := make(map[string][]byte)
+	p := 12
+	for p+8 <= len(data) {
+		l := int(binary.BigEndian.Uint32(data[p+4 : p+8]))
+		if p+8+l > len(data) {
+			t.Fatalf("%q chunk is %d bytes but only %d bytes remain", data[p:p+4], l, len(data)-p-8)
+		}
+		chunks[string(data[p:p+4])] = data[p+8 : p+8+l]
+		p += 8 + l + l&1
+	}
+	if p != len(data) {
+		t.Fatalf("%d bytes after the last chunk", len(data)-p)
+	}
+	return chunks
+}
+
+func TestAIFFChunks(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{CueChunk: true},
+		{Channels: 2, ChannelDelaySamples: 3, BitDepth: 16},
+		{ResampleTo: 48000, CueChunk: true},
+	} {
+		var w bytes.Buffer
+		_, err := ConvertWithOptions(rom, &w, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := wavChunk(t, w.Bytes(), "data")
+		format := OutputFormat(opts)
+		frames := len(data) / (format.Channels * format.BitDepth / 8)
+
+		// the output is the same whether or not the AIFF is streamed
+		var streamed bytes.Buffer
+		_, err = ConvertEncoder(rom, NewAIFFEncoder(&streamed), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buffered bytes.Buffer
+		_, err = ConvertEncoder(rom, bufferedAIFF{aiff: &aiff{w: &buffered}}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(streamed.Bytes(), buffered.Bytes()) {
+			t.Errorf("options %d: streamed output is different to buffered output", i)
+		}
+
+		chunks := aiffChunks(t, streamed.Bytes())
+
+		comm := chunks["COMM"]
+		if len(comm) != 18 {
+			t.Fatalf("options %d: COMM chunk is %d bytes", i, len(comm))
+		}
+		channels := binary.BigEndian.Uint16(comm[0:2])
+		n := binary.BigEndian.Uint32(comm[2:6])
+		depth := binary.BigEndian.Uint16(comm[6:8])
+		if int(channels) != format.Channels || int(n) != frames || int(depth) != format.BitDepth {
+			t.Errorf("options %d: COMM chunk is %d channels, %d frames, %d bits", i, channels, n, depth)
+		}
+		if hz := extended80(float64(format.SampleRate)); !bytes.Equal(comm[8:18], hz) {
+			t.Errorf("options %d: sample rate is % x but should be % x", i, comm[8:18], hz)
+		}
+
+		// the sound data is the offset and block size followed by the
+		// samples of the WAV, as signed big-endian values
+		ssnd := chunks["SSND"]
+		if len(ssnd) != 8+len(data) {
+			t.Fatalf("options %d: SSND chunk is %d bytes but should be %d", i, len(ssnd), 8+len(data))
+		}
+		for j := 0; j < len(data); j++ {
+			var expected byte
+			if format.BitDepth == 16 {
+				expected = data[j^1]
+			} else {
+				expected = data[j] - 128
+			}
+			if ssnd[8+j] != expected {
+				t.Fatalf("options %d: byte %d of the samples is %02x but should be %02x", i, j, ssnd[8+j], expected)
+			}
+		}
+
+		_, ok := chunks["MARK"]
+		if ok != opts.CueChunk {
+			t.Errorf("options %d: MARK chunk is present: %v", i, ok)
+		}
+	}
+}
+
+// bufferedAIFF is an AIFF encoder that does not implement sizedEncoder, so the
+// samples are kept until the encoder is finalized
+type bufferedAIFF struct {
+	aiff *aiff
+}
+
+func (enc bufferedAIFF) WriteHeader(f Format) error           { return enc.aiff.WriteHeader(f) }
+func (enc bufferedAIFF) WriteSamples(samples []float64) error { return enc.aiff.WriteSamples(samples) }
+func (enc bufferedAIFF) Finalize() error                      { return enc.aiff.Finalize() }
+func (enc bufferedAIFF) cue(frame int)                        { enc.aiff.cue(frame) }
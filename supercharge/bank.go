@@ -0,0 +1,155 @@
+package supercharge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the bank configuration used if one is not specified. bank configuration 7
+// (bank 1 at $F000 and bank 2 at $F800) with writes to RAM disabled and the
+// BIOS ROM powered off
+const defaultBankConfig = 0x1d
+
+// the RAM bank mapped into the $F000 and $F800 address ranges for each of the
+// eight bank configurations. a value of -1 indicates that the address range is
+// occupied by the BIOS ROM
+var bankMapping = [8][2]int{
+	{2, -1},
+	{0, -1},
+	{2, 0},
+	{0, 2},
+	{2, -1},
+	{1, -1},
+	{2, 1},
+	{1, 2},
+}
+
+// the number of 256 byte blocks in each 2K bank of Supercharger RAM
+const blocksPerBank = 8
+
+// validateBankConfig checks that the bank configuration byte is suitable for a
+// load of blockCount blocks
+//
+// The bank configuration number is in bits 2 to 4 of the bank configuration
+// byte. Each configuration maps RAM banks or the BIOS ROM into the $F000 and
+// $F800 address ranges:
+//
+//	config   $F000    $F800
+//	  0      bank 2   ROM
+//	  1      bank 0   ROM
+//	  2      bank 2   bank 0
+//	  3      bank 0   bank 2
+//	  4      bank 2   ROM
+//	  5      bank 1   ROM
+//	  6      bank 2   bank 1
+//	  7      bank 1   bank 2
+//
+// The data in a load is placed in the banks that are mapped when the game
+// starts, so the block count and bank configuration must agree:
+//
+//   - a 2K load is placed in the bank at $F800, which contains the reset vector
+//   - a 4K load is placed in the banks at $F000 and $F800
+//   - a 6K load is placed in banks 0, 1 and 2, in that order. bank 2 contains
+//     the reset vector and must be at $F800
+//
+// The valid combinations are:
+//
+//	blocks   configs
+//	8 (2K)   2, 3, 6, 7
+//	16 (4K)  2, 3, 6, 7
+//	24 (6K)  3, 7
+func validateBankConfig(bankConfig byte, blockCount int) error {
+	_, err := loadBanks(bankConfig, blockCount)
+	return err
+}
+
+// loadBanks returns the RAM bank for each 2K of a load of blockCount blocks
+func loadBanks(bankConfig byte, blockCount int) ([]int, error) {
+	m := bankMapping[(bankConfig>>2)&0x07]
+	switch {
+	case blockCount <= blocksPerBank:
+		if m[1] == -1 {
+			return nil, fmt.Errorf("bank config %02x: ROM is mapped where RAM is needed for %d blocks", bankConfig, blockCount)
+		}
+		return []int{m[1]}, nil
+	case blockCount <= 2*blocksPerBank:
+		if m[0] == -1 || m[1] == -1 {
+			return nil, fmt.Errorf("bank config %02x: ROM is mapped where RAM is needed for %d blocks", bankConfig, blockCount)
+		}
+		return m[:], nil
+	case blockCount <= 3*blocksPerBank:
+		if m[0] == -1 || m[1] != 2 {
+			return nil, fmt.Errorf("bank config %02x: bank 2 must be at $F800 for %d blocks", bankConfig, blockCount)
+		}
+		return []int{0, 1, 2}, nil
+	}
+	return nil, fmt.Errorf("bank config %02x: %d blocks can not be addressed", bankConfig, blockCount)
+}
+
+// blockNumber returns the block number written to tape for the block at the
+// given index in a load of blockCount blocks. the block number is the page
+// offset into the bank multiplied by four, plus the bank number. the bank
+// configuration must have been checked with validateBankConfig()
+func blockNumber(bankConfig byte, blockCount int, block int) byte {
+	banks, _ := loadBanks(bankConfig, blockCount)
+	bank := banks[block/blocksPerBank]
+	page := block % blocksPerBank
+	return byte(page*4 + bank)
+}
+
+// the bits of the bank configuration byte outside of the bank configuration
+// number. bits 5 to 7 are not used by the Supercharger
+const (
+	bankROMPowerOff  = 0x01
+	bankWriteEnabled = 0x02
+)
+
+// DescribeBankConfig returns a short description of the bank configuration
+// byte: the banks mapped into the $F000 and $F800 address ranges, whether
+// writes to RAM are enabled and whether the BIOS ROM is powered. Any of the
+// unused bits 5 to 7 that are set are also noted
+func DescribeBankConfig(bankConfig byte) string {
+	var s []string
+	m := bankMapping[(bankConfig>>2)&0x07]
+	for i, addr := range []string{"$F000", "$F800"} {
+		if m[i] == -1 {
+			s = append(s, fmt.Sprintf("ROM at %s", addr))
+		} else {
+			s = append(s, fmt.Sprintf("bank %d at %s", m[i], addr))
+		}
+	}
+	if bankConfig&bankWriteEnabled == bankWriteEnabled {
+		s = append(s, "writes enabled")
+	} else {
+		s = append(s, "writes disabled")
+	}
+	if bankConfig&bankROMPowerOff == bankROMPowerOff {
+		s = append(s, "ROM power off")
+	} else {
+		s = append(s, "ROM power on")
+	}
+	if bankConfig&0xe0 != 0 {
+		s = append(s, fmt.Sprintf("unused bits %02x", bankConfig&0xe0))
+	}
+	return fmt.Sprintf("config %d: %s", (bankConfig>>2)&0x07, strings.Join(s, ", "))
+}
+
+// ParseBankConfig parses a bank configuration byte written in hexadecimal, with
+// or without a $ or 0x prefix. The value is returned as it is given. Whether it
+// is suitable for a ROM is checked by the conversion
+//
+// The value $00 is an error. It can not be given to a conversion because a
+// BankConfig of zero selects the default configuration. It would not be
+// suitable for any ROM in any case, because it maps the BIOS ROM at $F800
+func ParseBankConfig(s string) (byte, error) {
+	h := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	v, err := strconv.ParseUint(h, 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("bank config: not a hexadecimal byte (%s)", s)
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("bank config 00: ROM is mapped at $F800 where RAM is needed")
+	}
+	return byte(v), nil
+}
@@ -0,0 +1,79 @@
+package supercharge
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseBankConfig(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		v   byte
+		err bool
+	}{
+		{"1d", 0x1d, false},
+		{"$1D", 0x1d, false},
+		{"0x0d", 0x0d, false},
+		{"00", 0, true},
+		{"$0", 0, true},
+		{"100", 0, true},
+		{"x", 0, true},
+	} {
+		v, err := ParseBankConfig(tc.s)
+		if (err != nil) != tc.err || v != tc.v {
+			t.Errorf("%s: parsed as %02x with error %v", tc.s, v, err)
+		}
+	}
+
+	// the configuration is never suitable, so rejecting it does not prevent
+	// any conversion
+	for _, blocks := range []int{8, 16, 24} {
+		if validateBankConfig(0x00, blocks) == nil {
+			t.Errorf("bank config 00 is accepted for %d blocks", blocks)
+		}
+	}
+}
+
+func TestValidateBankConfig(t *testing.T) {
+	// the valid configurations for each number of blocks, from the
+	// documentation of validateBankConfig
+	valid := map[int][]int{
+		8:  {2, 3, 6, 7},
+		16: {2, 3, 6, 7},
+		24: {3, 7},
+	}
+	for blocks, configs := range valid {
+		for config := 0; config < 8; config++ {
+			ok := false
+			for _, c := range configs {
+				ok = ok || c == config
+			}
+
+			// the bits outside of the configuration number do not change
+			// the result
+			for _, bits := range []byte{0x00, bankROMPowerOff, bankWriteEnabled} {
+				bankConfig := byte(config<<2) | bits
+				err := validateBankConfig(bankConfig, blocks)
+				if (err == nil) != ok {
+					t.Errorf("bank config %02x, %d blocks: validation returned %v", bankConfig, blocks, err)
+				}
+			}
+		}
+	}
+
+	// an incompatible configuration is rejected by the conversion, with the
+	// configuration named in the error
+	_, err := ConvertWithOptions(testROM(4096), io.Discard, ConvertOptions{BankConfig: 0x05})
+	if err == nil || !strings.Contains(err.Error(), "bank config 05") {
+		t.Errorf("conversion with bank config 05 returned %v", err)
+	}
+	_, err = ConvertWithOptions(testROM(6144), io.Discard, ConvertOptions{BankConfig: 0x19})
+	if err == nil || !strings.Contains(err.Error(), "bank 2 must be at $F800") {
+		t.Errorf("conversion of 6K with bank config 19 returned %v", err)
+	}
+
+	// a compatible configuration other than the default loads
+	roundTrip(t, testROM(6144), ConvertOptions{BankConfig: 0x0d})
+	roundTrip(t, testROM(2048), ConvertOptions{BankConfig: 0x09})
+}
@@ -0,0 +1,131 @@
+package supercharge
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Cache keeps the output of recent conversions in memory so that converting
+// the same ROM with the same options a second time does not require the WAV
+// data to be generated again
+//
+// A Cache is safe for use by multiple goroutines
+type Cache struct {
+	crit sync.Mutex
+
+	// the maximum number of bytes of output data to keep. when the limit is
+	// exceeded the least recently used entries are evicted
+	maxBytes int
+	size     int
+
+	entries map[string]*list.Element
+	lru     *list.List
+
+	hits   int
+	misses int
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+	rep  ConvertReport
+}
+
+// NewCache creates a new Cache that keeps at most maxBytes of output data
+func NewCache(maxBytes int) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Convert is the same as ConvertWithOptions except that the output and report
+// are taken from the cache if the same ROM data has been converted with the same
+// options before
+//
+// Options that specify a function (for example, AmplitudeEnvelope) can not be
+// compared reliably and conversions using them bypass the cache
+func (c *Cache) Convert(rom []byte, w io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	if !opts.cacheable() {
+		return ConvertWithOptions(rom, w, opts)
+	}
+
+	// the raw header is a pointer so the bytes it points to are used in the
+	// key rather than its address
+	keyOpts := opts
+	var rawHeader []byte
+	if opts.RawHeader != nil {
+		keyOpts.RawHeader = nil
+		rawHeader = opts.RawHeader[:]
+	}
+	key := fmt.Sprintf("%x %+v %x", sha256.Sum256(rom), keyOpts, rawHeader)
+
+	c.crit.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		ent := e.Value.(*cacheEntry)
+		c.crit.Unlock()
+
+		_, err := w.Write(ent.data)
+		if err != nil {
+			return ConvertReport{}, err
+		}
+		return ent.rep.copy(), nil
+	}
+	c.misses++
+	c.crit.Unlock()
+
+	var data bytes.Buffer
+	rep, err := ConvertWithOptions(rom, &data, opts)
+	if err != nil {
+		return ConvertReport{}, err
+	}
+
+	c.add(key, data.Bytes(), rep.copy())
+
+	_, err = w.Write(data.Bytes())
+	if err != nil {
+		return ConvertReport{}, err
+	}
+	return rep, nil
+}
+
+func (c *Cache) add(key string, data []byte, rep ConvertReport) {
+	c.crit.Lock()
+	defer c.crit.Unlock()
+
+	// data that can never fit in the cache is not added
+	if len(data) > c.maxBytes {
+		return
+	}
+
+	// another goroutine may have added the same conversion in the meantime
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data, rep: rep})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		e := c.lru.Back()
+		ent := e.Value.(*cacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, ent.key)
+		c.size -= len(ent.data)
+	}
+}
+
+// Stats returns the number of conversions that were found in the cache and the
+// number that were not
+func (c *Cache) Stats() (hits int, misses int) {
+	c.crit.Lock()
+	defer c.crit.Unlock()
+	return c.hits, c.misses
+}
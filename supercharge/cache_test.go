@@ -0,0 +1,88 @@
+package supercharge
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	rom := testROM(4096)
+	c := NewCache(1 << 24)
+
+	var expected bytes.Buffer
+	expectedRep, err := ConvertWithOptions(rom, &expected, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first conversion is a miss and the second is a hit. both are the
+	// same as a conversion without the cache
+	for i := 0; i < 2; i++ {
+		var b bytes.Buffer
+		rep, err := c.Convert(rom, &b, ConvertOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), expected.Bytes()) {
+			t.Errorf("conversion %d: output is different to a conversion without the cache", i)
+		}
+		if !reflect.DeepEqual(rep, expectedRep) {
+			t.Errorf("conversion %d: report is different to a conversion without the cache", i)
+		}
+		hits, misses := c.Stats()
+		if hits != i || misses != 1 {
+			t.Errorf("conversion %d: %d hits and %d misses", i, hits, misses)
+		}
+	}
+
+	// a report from the cache can be changed without changing the cache
+	rep, _ := c.Convert(rom, &bytes.Buffer{}, ConvertOptions{})
+	rep.Blocks[0].Checksum++
+	rep, _ = c.Convert(rom, &bytes.Buffer{}, ConvertOptions{})
+	if rep.Blocks[0] != expectedRep.Blocks[0] {
+		t.Errorf("report in the cache was changed")
+	}
+
+	// different options, a different ROM and a different raw header are
+	// misses
+	hdr := [8]byte{0x00, 0xf0, 0x1d, 0x10, 0x00, 0x00, 0x6d, 0x01}
+	other := [8]byte{0x00, 0xf0, 0x1d, 0x10, 0x00, 0x01, 0x6d, 0x01}
+	_, misses := c.Stats()
+	for i, tc := range []struct {
+		rom  []byte
+		opts ConvertOptions
+	}{
+		{rom, ConvertOptions{BitDepth: 16}},
+		{rom, ConvertOptions{Channels: 2}},
+		{testROM(2048), ConvertOptions{}},
+		{rom, ConvertOptions{RawHeader: &hdr}},
+		{rom, ConvertOptions{RawHeader: &other}},
+	} {
+		var b bytes.Buffer
+		_, err := c.Convert(tc.rom, &b, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var direct bytes.Buffer
+		_, err = ConvertWithOptions(tc.rom, &direct, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), direct.Bytes()) {
+			t.Errorf("case %d: output is different to a conversion without the cache", i)
+		}
+		if _, m := c.Stats(); m != misses+i+1 {
+			t.Errorf("case %d: conversion is not a miss", i)
+		}
+	}
+
+	// the least recently used entry is evicted once the cache is full
+	small := NewCache(expected.Len() + 1)
+	small.Convert(rom, &bytes.Buffer{}, ConvertOptions{})
+	small.Convert(rom, &bytes.Buffer{}, ConvertOptions{Seed: 1})
+	small.Convert(rom, &bytes.Buffer{}, ConvertOptions{})
+	if hits, misses := small.Stats(); hits != 0 || misses != 3 {
+		t.Errorf("full cache has %d hits and %d misses", hits, misses)
+	}
+}
@@ -0,0 +1,45 @@
+package supercharge
+
+import (
+	"fmt"
+	"io"
+)
+
+// ConvertAll converts several ROMs into a single WAV, in the same way that
+// several games were once recorded onto one side of a cassette. Each ROM is a
+// separate program with its own start tone, calibration tone and header, and
+// the Multiload field of the options is used for every program. The programs
+// are separated by MultiloadSilenceSeconds of silence
+//
+// Every ROM is checked before any output is written. If a ROM can not be
+// converted the error names the position of the ROM in the list and nothing
+// is written
+//
+// The details of each program are returned in a ConvertReport, in the same
+// order as the ROMs. The Offset and OffsetSamples fields give the position in
+// the WAV at which each program starts
+func ConvertAll(roms [][]byte, w io.Writer, opts ConvertOptions) ([]ConvertReport, error) {
+	opts = opts.WithProfile()
+
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roms) == 0 {
+		return nil, fmt.Errorf("no roms to convert")
+	}
+
+	var streams [][]byte
+	var reps []ConvertReport
+	for i, rom := range roms {
+		stream, rep, err := BuildStream(rom, opts)
+		if err != nil {
+			return nil, fmt.Errorf("rom %d: %w", i, err)
+		}
+		streams = append(streams, stream)
+		reps = append(reps, rep)
+	}
+
+	return convertStreams(streams, reps, NewWAVEncoder(w), opts, newTones(opts))
+}
@@ -0,0 +1,43 @@
+package supercharge
+
+import "io"
+
+// Converter converts ROMs with the same ConvertOptions. The tones used in the
+// output are prepared once, when the Converter is created, and are reused for
+// each conversion. A Converter is useful when converting a large number of
+// ROMs
+//
+// A Converter can be used by more than one goroutine at the same time
+type Converter struct {
+	opts  ConvertOptions
+	tones tones
+}
+
+// NewConverter returns a Converter for the options. An error is returned if
+// the options can not be used for a conversion
+func NewConverter(opts ConvertOptions) (*Converter, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Converter{
+		opts:  opts,
+		tones: newTones(opts),
+	}, nil
+}
+
+// Convert is the same as ConvertWithOptions, using the options given to
+// NewConverter
+func (c *Converter) Convert(rom []byte, w io.Writer) (ConvertReport, error) {
+	return c.ConvertEncoder(rom, NewWAVEncoder(w))
+}
+
+// ConvertEncoder is the same as the ConvertEncoder function, using the options
+// given to NewConverter
+func (c *Converter) ConvertEncoder(rom []byte, enc Encoder) (ConvertReport, error) {
+	reps, err := convertLoads([][]byte{rom}, enc, c.opts, c.tones)
+	if err != nil {
+		return ConvertReport{}, err
+	}
+	return reps[0], nil
+}
@@ -0,0 +1,98 @@
+package supercharge
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentConversion(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{Dither: true, Seed: 11, Channels: 2, ChannelDelaySamples: 4},
+		{ResampleTo: 48000, CueChunk: true, BitDepth: 16},
+	} {
+		var b bytes.Buffer
+		_, err := ConvertWithOptions(rom, &b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := sha256.Sum256(b.Bytes())
+
+		conv, err := NewConverter(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// each goroutine converts the ROM twice. once with the shared
+		// Converter and once with ConvertWithOptions()
+		const goroutines = 8
+		hashes := make([][2][sha256.Size]byte, goroutines)
+		errs := make([]error, goroutines)
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				var b bytes.Buffer
+				_, errs[g] = conv.Convert(rom, &b)
+				if errs[g] != nil {
+					return
+				}
+				hashes[g][0] = sha256.Sum256(b.Bytes())
+
+				b.Reset()
+				_, errs[g] = ConvertWithOptions(rom, &b, opts)
+				hashes[g][1] = sha256.Sum256(b.Bytes())
+			}(g)
+		}
+		wg.Wait()
+
+		for g := 0; g < goroutines; g++ {
+			if errs[g] != nil {
+				t.Fatalf("options %d, goroutine %d: %v", i, g, errs[g])
+			}
+			if hashes[g][0] != expected || hashes[g][1] != expected {
+				t.Errorf("options %d, goroutine %d: output is different to the output of a single conversion", i, g)
+			}
+		}
+	}
+}
+
+// BenchmarkConvert compares a Converter that is reused for every conversion
+// with a call to ConvertWithOptions() for every conversion
+func BenchmarkConvert(b *testing.B) {
+	rom := testROM(4096)
+	opts := Default()
+
+	b.Run("Converter", func(b *testing.B) {
+		conv, err := NewConverter(opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var w bytes.Buffer
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			w.Reset()
+			_, err := conv.Convert(rom, &w)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ConvertWithOptions", func(b *testing.B) {
+		var w bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w.Reset()
+			_, err := ConvertWithOptions(rom, &w, opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package supercharge
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// NewCSWEncoder returns an Encoder that writes the audio to a CSW (Compressed
+// Square Wave) file. CSW files store the length of each pulse of a square wave
+// rather than the samples, so the file is much smaller than a WAV file. The
+// pulse lengths are compressed with zlib, as in version 2 of the format
+//
+// The audio must have a single channel. A new pulse starts each time a sample
+// crosses zero. The BitDepth, Float32 and Rounding fields of the Format are
+// ignored
+//
+// The pulses are kept until the Encoder is finalized, when the entire CSW file
+// is written
+func NewCSWEncoder(w io.Writer) Encoder {
+	return &cswEncoder{w: w}
+}
+
+// samples must cross zero by more than the threshold to start a new pulse.
+// this prevents dither and other low level noise from adding very short pulses
+const cswThreshold = 1.0 / 64
+
+type cswEncoder struct {
+	w  io.Writer
+	hz uint32
+
+	// the level of the first sample and of the current pulse
+	started bool
+	initial bool
+	high    bool
+
+	// the length in samples of the current pulse
+	length uint32
+
+	// the encoded pulse lengths and the number of pulses
+	pulses bytes.Buffer
+	count  uint32
+}
+
+func (enc *cswEncoder) WriteHeader(f Format) error {
+	if f.Channels != 1 {
+		return fmt.Errorf("csw: unsupported number of channels (%d)", f.Channels)
+	}
+	enc.hz = f.SampleRate
+	return nil
+}
+
+func (enc *cswEncoder) WriteSamples(samples []float64) error {
+	for _, s := range samples {
+		if !enc.started {
+			enc.started = true
+			enc.high = s >= 0
+			enc.initial = enc.high
+		}
+		if (enc.high && s < -cswThreshold) || (!enc.high && s > cswThreshold) {
+			enc.pulse()
+			enc.high = !enc.high
+		}
+		enc.length++
+	}
+	return nil
+}
+
+// pulse adds the current pulse to the encoded pulse lengths. lengths that do
+// not fit in a byte are written as a zero byte followed by a four byte length
+func (enc *cswEncoder) pulse() {
+	if enc.length == 0 {
+		return
+	}
+	if enc.length < 256 {
+		enc.pulses.WriteByte(byte(enc.length))
+	} else {
+		enc.pulses.WriteByte(0)
+		enc.pulses.Write(binary.LittleEndian.AppendUint32(nil, enc.length))
+	}
+	enc.count++
+	enc.length = 0
+}
+
+func (enc *cswEncoder) Finalize() error {
+	enc.pulse()
+
+	var w bytes.Buffer
+
+	// the header of a version 2.0 file. the compression type 2 is Z-RLE and
+	// bit 0 of the flags is the level of the first pulse
+	w.WriteString("Compressed Square Wave\x1a")
+	w.Write([]byte{2, 0})
+	w.Write(binary.LittleEndian.AppendUint32(nil, enc.hz))
+	w.Write(binary.LittleEndian.AppendUint32(nil, enc.count))
+	w.WriteByte(2)
+	if enc.initial {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+
+	// no header extension followed by the name of the encoding application,
+	// padded to 16 bytes
+	w.WriteByte(0)
+	var app [16]byte
+	copy(app[:], "supercharge")
+	w.Write(app[:])
+
+	z := zlib.NewWriter(&w)
+	_, err := z.Write(enc.pulses.Bytes())
+	if err != nil {
+		return err
+	}
+	err = z.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.w.Write(w.Bytes())
+	return err
+}
@@ -0,0 +1,143 @@
+package supercharge
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// cswPulses returns the sample rate and the pulse lengths of the CSW file
+func cswPulses(t *testing.T, data []byte) (uint32, []int) {
+	t.Helper()
+	if len(data) < 52 || string(data[:23]) != "Compressed Square Wave\x1a" {
+		t.Fatalf("not a CSW file")
+	}
+	if data[23] != 2 || data[24] != 0 || data[33] != 2 {
+		t.Fatalf("not a version 2.0 Z-RLE file")
+	}
+	hz := binary.LittleEndian.Uint32(data[25:29])
+	count := int(binary.LittleEndian.Uint32(data[29:33]))
+
+	z, err := zlib.NewReader(bytes.NewReader(data[52+int(data[35]):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pulses []int
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0 {
+			pulses = append(pulses, int(b[i]))
+			continue
+		}
+		if i+5 > len(b) {
+			t.Fatalf("long pulse is truncated")
+		}
+		pulses = append(pulses, int(binary.LittleEndian.Uint32(b[i+1:i+5])))
+		i += 4
+	}
+	if len(pulses) != count {
+		t.Fatalf("header gives %d pulses but there are %d", count, len(pulses))
+	}
+	return hz, pulses
+}
+
+func TestCSWPulses(t *testing.T) {
+	tn := newTones(Default())
+	for _, tc := range []struct {
+		name    string
+		samples []float64
+		cycle   int
+	}{
+		{"zero bit", tn.zeroBit, 6},
+		{"one bit", tn.oneBit, 10},
+		{"start", tn.start, 51},
+	} {
+		if len(tc.samples) != tc.cycle {
+			t.Fatalf("%s tone cycle is %d samples but should be %d", tc.name, len(tc.samples), tc.cycle)
+		}
+
+		const cycles = 8
+		var b bytes.Buffer
+		enc := NewCSWEncoder(&b)
+		err := enc.WriteHeader(Format{SampleRate: 44100, Channels: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < cycles; i++ {
+			err = enc.WriteSamples(tc.samples)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = enc.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, pulses := cswPulses(t, b.Bytes())
+		if len(pulses) != cycles*2 {
+			t.Fatalf("%s: %d pulses for %d cycles", tc.name, len(pulses), cycles)
+		}
+
+		// the first and last pulses depend on where the cycle starts. every
+		// other pulse is half a cycle, which for an odd length is one sample
+		// more or less than the other half
+		total := 0
+		for i, p := range pulses {
+			total += p
+			if i == 0 || i == len(pulses)-1 {
+				continue
+			}
+			if p != tc.cycle/2 && p != tc.cycle-tc.cycle/2 {
+				t.Errorf("%s: pulse %d is %d samples but the cycle is %d samples", tc.name, i, p, tc.cycle)
+			}
+			if i > 1 && pulses[i-1]+p != tc.cycle {
+				t.Errorf("%s: pulses %d and %d are %d and %d samples but the cycle is %d samples", tc.name, i-1, i, pulses[i-1], p, tc.cycle)
+			}
+		}
+		if total != cycles*tc.cycle {
+			t.Errorf("%s: pulses are %d samples but %d samples were written", tc.name, total, cycles*tc.cycle)
+		}
+	}
+}
+
+func TestCSWOutput(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{ResampleTo: 48000},
+		{Dither: true, Seed: 7},
+	} {
+		var w bytes.Buffer
+		_, err := ConvertWithOptions(rom, &w, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := len(wavChunk(t, w.Bytes(), "data")) / (OutputFormat(opts).BitDepth / 8)
+
+		var b bytes.Buffer
+		_, err = ConvertEncoder(rom, NewCSWEncoder(&b), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hz, pulses := cswPulses(t, b.Bytes())
+		if hz != OutputFormat(opts).SampleRate {
+			t.Errorf("options %d: sample rate is %d", i, hz)
+		}
+
+		// every sample is part of a pulse
+		total := 0
+		for _, p := range pulses {
+			total += p
+		}
+		if total != frames {
+			t.Errorf("options %d: pulses are %d samples but the WAV is %d samples", i, total, frames)
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package supercharge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// pcm is the audio data of a WAV file. only the first channel is kept and the
+// samples are in the range -1 to +1
+type pcm struct {
+	hz       uint32
+	channels int
+	depth    int
+	samples  []float64
+}
+
+// readWAV reads a WAV file. 8, 16, 24 and 32 bit integer PCM data is supported
+// as well as 32 bit float data
+func readWAV(r io.Reader) (pcm, error) {
+	var hdr [12]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return pcm{}, fmt.Errorf("wav: %w", err)
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return pcm{}, fmt.Errorf("wav: not a WAV file")
+	}
+
+	var p pcm
+	var format uint16
+	var haveFmt bool
+
+	for {
+		var chunk [8]byte
+		_, err := io.ReadFull(r, chunk[:])
+		if err != nil {
+			return pcm{}, fmt.Errorf("wav: no data chunk")
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return pcm{}, fmt.Errorf("wav: fmt chunk is too short")
+			}
+			b := make([]byte, size)
+			_, err := io.ReadFull(r, b)
+			if err != nil {
+				return pcm{}, fmt.Errorf("wav: %w", err)
+			}
+			format = binary.LittleEndian.Uint16(b[0:2])
+			p.channels = int(binary.LittleEndian.Uint16(b[2:4]))
+			p.hz = binary.LittleEndian.Uint32(b[4:8])
+			p.depth = int(binary.LittleEndian.Uint16(b[14:16]))
+
+			// the real format of a WAVE_FORMAT_EXTENSIBLE file is in the
+			// first two bytes of the sub-format GUID
+			if format == 0xfffe && size >= 26 {
+				format = binary.LittleEndian.Uint16(b[24:26])
+			}
+			haveFmt = true
+
+		case "data":
+			if !haveFmt {
+				return pcm{}, fmt.Errorf("wav: data chunk before fmt chunk")
+			}
+			b := make([]byte, size)
+			_, err := io.ReadFull(r, b)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return pcm{}, fmt.Errorf("wav: %w", err)
+			}
+			err = p.decodeSamples(format, b)
+			if err != nil {
+				return pcm{}, err
+			}
+			return p, nil
+
+		default:
+			_, err := io.CopyN(io.Discard, r, int64(size))
+			if err != nil {
+				return pcm{}, fmt.Errorf("wav: %w", err)
+			}
+		}
+
+		// chunks are padded to an even number of bytes
+		if size&1 == 1 && id != "data" {
+			_, err := io.CopyN(io.Discard, r, 1)
+			if err != nil {
+				return pcm{}, fmt.Errorf("wav: %w", err)
+			}
+		}
+	}
+}
+
+// decodeSamples converts the data chunk of a WAV file into samples
+func (p *pcm) decodeSamples(format uint16, b []byte) error {
+	if p.channels < 1 {
+		return fmt.Errorf("wav: no channels")
+	}
+	if p.hz == 0 {
+		return fmt.Errorf("wav: sample rate is zero")
+	}
+
+	var sample func([]byte) float64
+	switch {
+	case format == 1 && p.depth == 8:
+		sample = func(b []byte) float64 {
+			return (float64(b[0]) - 128) / 128
+		}
+	case format == 1 && p.depth == 16:
+		sample = func(b []byte) float64 {
+			return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+		}
+	case format == 1 && p.depth == 24:
+		sample = func(b []byte) float64 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float64(v) / 8388608
+		}
+	case format == 1 && p.depth == 32:
+		sample = func(b []byte) float64 {
+			return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+		}
+	case format == 3 && p.depth == 32:
+		sample = func(b []byte) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+	default:
+		return fmt.Errorf("wav: unsupported sample format (format %d, %d bits)", format, p.depth)
+	}
+
+	frame := p.channels * p.depth / 8
+	p.samples = make([]float64, len(b)/frame)
+	for i := range p.samples {
+		p.samples[i] = sample(b[i*frame:])
+	}
+
+	return nil
+}
+
+// the length of a run of silent samples that is treated as a gap in the tones
+const gapSamples = 32
+
+// cycles returns the length, in samples, of each cycle in the audio. a cycle
+// begins at a rising zero crossing. the position of each crossing is
+// interpolated between samples
+//
+// a small amount of hysteresis is used so that noise around the centre line is
+// not mistaken for a crossing
+//
+// a gap in the tones ends the cycle at the start of the gap. the gap itself is
+// not included in the cycle lengths
+func cycles(samples []float64) []float64 {
+	d := newCycleDetector()
+	for _, s := range samples {
+		d.add(s)
+	}
+	return d.periods
+}
+
+// cycleDetector measures the cycles of audio that arrives one sample at a
+// time. the periods found are the same as those returned by cycles() for all
+// of the samples added so far
+type cycleDetector struct {
+	// the length of each cycle found so far
+	periods []float64
+
+	// the position of the next sample
+	i int
+
+	// the start of the current run of silent samples and the start of the
+	// most recent gap. gap is -1 if there has been no gap since the previous
+	// crossing
+	quiet int
+	gap   int
+
+	// the state is 1 when the waveform is above the centre line and -1 when
+	// it is below. the state is zero until the first sample outside of the
+	// hysteresis range
+	state int
+
+	// position of the most recent sample at or below the centre line, and the
+	// values of that sample and of the sample after it
+	lastLow  int
+	low      float64
+	afterLow float64
+
+	// position of the previous rising crossing
+	prev float64
+}
+
+func newCycleDetector() cycleDetector {
+	return cycleDetector{quiet: -1, gap: -1, lastLow: -1, prev: -1}
+}
+
+// add the next sample of the audio
+func (d *cycleDetector) add(s float64) {
+	const hysteresis = 0.02
+
+	i := d.i
+	d.i++
+
+	if d.lastLow >= 0 && i == d.lastLow+1 {
+		d.afterLow = s
+	}
+	if s <= 0 {
+		d.lastLow = i
+		d.low = s
+	}
+	if math.Abs(s) <= hysteresis {
+		if d.quiet == -1 {
+			d.quiet = i
+		}
+	} else {
+		if d.quiet != -1 && i-d.quiet >= gapSamples {
+			d.gap = d.quiet
+		}
+		d.quiet = -1
+	}
+	switch {
+	case s > hysteresis && d.state != 1:
+		if d.state == -1 && d.lastLow >= 0 {
+			a := d.low
+			b := d.afterLow
+			x := float64(d.lastLow)
+			if b != a {
+				x += a / (a - b)
+			}
+			if d.prev >= 0 {
+				if d.gap >= 0 {
+					d.periods = append(d.periods, float64(d.gap)-d.prev)
+				} else {
+					d.periods = append(d.periods, x-d.prev)
+				}
+			}
+			d.prev = x
+			d.gap = -1
+		}
+		d.state = 1
+	case s < -hysteresis && d.state != -1:
+		d.state = -1
+	}
+}
+
+// tapeCycles returns the length of each cycle in the audio, measured between
+// either the rising or the falling zero crossings. the crossings that are
+// nearest to the boundaries between the tone cycles give the clearest
+// difference between the zero and one bits, depending on the phase of the tones
+func tapeCycles(samples []float64) []float64 {
+	rising := cycles(samples)
+
+	inverted := make([]float64, len(samples))
+	for i, s := range samples {
+		inverted[i] = -s
+	}
+	falling := cycles(inverted)
+
+	_, zr, or, errr := calibrate(rising, 0)
+	_, zf, of, errf := calibrate(falling, 0)
+	if errf == nil && (errr != nil || of/zf > or/zr) {
+		return falling
+	}
+	return rising
+}
+
+// the minimum number of alternating cycles that must be seen before the
+// calibration tone is recognised
+const calibrationCycles = 64
+
+// calibrate searches the cycle lengths, starting at index from, for the
+// calibration tone that precedes a header. the calibration tone is a run of
+// alternating zero and one bits
+//
+// returns the index of the first cycle of the calibration tone and the average
+// length of the zero and one cycles
+func calibrate(periods []float64, from int) (int, float64, float64, error) {
+	for i := from; i+calibrationCycles <= len(periods); i++ {
+		lo, hi := periods[i], periods[i]
+		for _, p := range periods[i : i+calibrationCycles] {
+			lo = math.Min(lo, p)
+			hi = math.Max(hi, p)
+		}
+
+		// the one bits should be noticeably longer than the zero bits but not
+		// excessively so
+		if lo <= 0 || hi/lo < 1.2 || hi/lo > 4 {
+			continue
+		}
+
+		// the cycles must alternate between short and long
+		mid := (lo + hi) / 2
+		ok := true
+		for j := i + 1; j < i+calibrationCycles; j++ {
+			if (periods[j] > mid) == (periods[j-1] > mid) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		var zero, one float64
+		var zeroCt, oneCt int
+		for _, p := range periods[i : i+calibrationCycles] {
+			if p > mid {
+				one += p
+				oneCt++
+			} else {
+				zero += p
+				zeroCt++
+			}
+		}
+
+		return i, zero / float64(zeroCt), one / float64(oneCt), nil
+	}
+
+	return 0, 0, 0, noCalibrationTone
+}
+
+var noCalibrationTone = errors.New("no calibration tone found")
+
+// CycleStats summarises the measured lengths, in samples, of a set of tone
+// cycles
+type CycleStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+
+	sum float64
+}
+
+func (st *CycleStats) add(p float64) {
+	if st.Count == 0 || p < st.Min {
+		st.Min = p
+	}
+	if st.Count == 0 || p > st.Max {
+		st.Max = p
+	}
+	st.Count++
+	st.sum += p
+	st.Mean = st.sum / float64(st.Count)
+}
+
+// ToneStats summarises the cycle lengths of the zero and one bits read from a
+// tape
+type ToneStats struct {
+	Zero CycleStats `json:"zero"`
+	One  CycleStats `json:"one"`
+}
+
+// tapeReader reads bits and bytes from the cycle lengths of a Supercharger
+// tape
+type tapeReader struct {
+	periods []float64
+	pos     int
+
+	// cycles longer than the threshold are one bits
+	threshold float64
+
+	stats ToneStats
+}
+
+var endOfTape = errors.New("unexpected end of tape")
+
+// sync finds the next calibration tone and the synchronisation byte that
+// follows it. when sync returns the next byte read will be the first byte of a
+// header packet
+func (t *tapeReader) sync() error {
+	start, zero, one, err := calibrate(t.periods, t.pos)
+	if err != nil {
+		return err
+	}
+	t.pos = start
+	t.threshold = (zero + one) / 2
+
+	// the calibration tone is made of $55 bytes and is followed by a single
+	// $54 byte. the bit pattern %01010100 can not occur within the calibration
+	// tone so it can be searched for one bit at a time
+	var b byte
+	for {
+		bit, err := t.readBit()
+		if err != nil {
+			return err
+		}
+		b <<= 1
+		if bit {
+			b |= 1
+		}
+		if b == 0x54 {
+			return nil
+		}
+	}
+}
+
+func (t *tapeReader) readBit() (bool, error) {
+	if t.pos >= len(t.periods) {
+		return false, endOfTape
+	}
+	p := t.periods[t.pos]
+	t.pos++
+	if p > t.threshold {
+		t.stats.One.add(p)
+		return true, nil
+	}
+	t.stats.Zero.add(p)
+	return false, nil
+}
+
+// readByte reads the next eight bits, most significant bit first
+func (t *tapeReader) readByte() (byte, error) {
+	var b byte
+	for i := 0; i < 8; i++ {
+		bit, err := t.readBit()
+		if err != nil {
+			return 0, err
+		}
+		b <<= 1
+		if bit {
+			b |= 1
+		}
+	}
+	return b, nil
+}
+
+// read fills the slice with the next bytes on the tape
+func (t *tapeReader) read(p []byte) error {
+	for i := range p {
+		b, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		p[i] = b
+	}
+	return nil
+}
+
+// readLoad reads the data packets for the header and reassembles the blocks
+// into the ROM data. packets are placed according to their block number so
+// they do not need to be in order
+func (t *tapeReader) readLoad(hdr [8]byte) ([]byte, error) {
+	bankConfig := hdr[2]
+	blockCount := int(hdr[3])
+	err := validateBankConfig(bankConfig, blockCount)
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	// the position in the ROM data of each block number
+	index := make(map[byte]int)
+	for i := 0; i < blockCount; i++ {
+		index[blockNumber(bankConfig, blockCount, i)] = i
+	}
+
+	rom := make([]byte, blockCount*256)
+	var packet [258]byte
+	for i := 0; i < blockCount; i++ {
+		err := t.read(packet[:])
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		if sum(packet[:]) != 0x55 {
+			return nil, fmt.Errorf("block %d: %w (block number %02x)", i, BadChecksum, packet[0])
+		}
+		idx, ok := index[packet[0]]
+		if !ok {
+			return nil, fmt.Errorf("block %d: unexpected block number (%02x)", i, packet[0])
+		}
+		copy(rom[idx*256:], packet[2:])
+	}
+
+	return rom, nil
+}
+
+// readLoads reads every load on the tape, in the order they appear. a header
+// that is the same as the header of the previous load is a repeated header
+// and is skipped
+func (t *tapeReader) readLoads() ([][]byte, error) {
+	var loads [][]byte
+	var prev [8]byte
+
+	for {
+		err := t.sync()
+		if err != nil {
+			// the tape ends when there are no more calibration tones. the
+			// tone that follows the last data packet can look like a
+			// calibration tone without a synchronisation byte
+			if errors.Is(err, noCalibrationTone) || errors.Is(err, endOfTape) {
+				return loads, nil
+			}
+			return nil, err
+		}
+
+		var hdr [8]byte
+		err = t.read(hdr[:])
+		if err != nil {
+			return nil, fmt.Errorf("header: %w", err)
+		}
+		if sum(hdr[:]) != 0x55 {
+			return nil, fmt.Errorf("header: %w", BadChecksum)
+		}
+		if len(loads) > 0 && hdr == prev {
+			continue
+		}
+
+		rom, err := t.readLoad(hdr)
+		if err != nil {
+			return nil, err
+		}
+		loads = append(loads, rom)
+		prev = hdr
+	}
+}
+
+// the shortest silence that is recognised as a stage marker by DecodeMultiload.
+// it is shorter than stageMarkerSilenceSeconds to allow for some inaccuracy in
+// the recording
+const stageMarkerMinimumSeconds = stageMarkerSilenceSeconds * 0.8
+
+// the largest sample value that is considered to be silence
+const silenceLevel = 0.02
+
+// stageSegments splits the samples at each stage marker. if there are no stage
+// markers the samples are returned as a single segment
+func stageSegments(samples []float64, hz uint32) [][]float64 {
+	minimum := int(stageMarkerMinimumSeconds * float64(hz))
+
+	var segments [][]float64
+	start := 0
+	run := 0
+	for i, s := range samples {
+		if math.Abs(s) <= silenceLevel {
+			run++
+			continue
+		}
+		if run >= minimum && i-run > start {
+			segments = append(segments, samples[start:i-run])
+			start = i
+		}
+		run = 0
+	}
+	segments = append(segments, samples[start:])
+
+	return segments
+}
+
+// Decode reads a WAV file containing a Supercharger tape and returns the ROM
+// data of the first load on the tape
+//
+// The lengths of the zero and one bit cycles are measured from the $55
+// calibration tone, so recordings made at a slightly different speed can be
+// read. Mono or multi-channel WAV files with 8, 16, 24 or 32 bit samples are
+// supported. Only the first channel is read
+//
+// A data packet with a bad checksum causes an error that wraps BadChecksum
+// and names the position of the packet on the tape, counting from zero
+func Decode(r io.Reader) ([]byte, error) {
+	return DecodeWithOptions(r, Default())
+}
+
+// DecodeWithOptions is the same as Decode but options that change the data
+// written to tape are reversed. The options should be the same as those used to
+// create the tape. Currently only the ByteSwapBlocks option is reversed
+func DecodeWithOptions(r io.Reader, opts ConvertOptions) ([]byte, error) {
+	p, err := readWAV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t := tapeReader{periods: tapeCycles(p.samples)}
+	err = t.sync()
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	var hdr [8]byte
+	err = t.read(hdr[:])
+	if err != nil {
+		return nil, fmt.Errorf("decode: header: %w", err)
+	}
+	if sum(hdr[:]) != 0x55 {
+		return nil, fmt.Errorf("decode: header: %w", BadChecksum)
+	}
+
+	rom, err := t.readLoad(hdr)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	// swapping the bytes a second time restores the original order
+	if opts.ByteSwapBlocks {
+		swapBytes(rom)
+	}
+
+	return rom, nil
+}
+
+// DecodeMultiload reads a WAV file containing one or more loads of a multiload
+// game. The ROM data for each load is returned in the order the loads appear on
+// the tape. The multiload index in each header is not used to order the loads
+//
+// If the tape was created with the StageMarker option the audio is first split
+// into stages at each marker. Otherwise the loads are read one after the other
+func DecodeMultiload(r io.Reader) ([][]byte, error) {
+	p, err := readWAV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// segments without any loads, such as a segment containing only the
+	// trailing silence or a resync marker, are ignored
+	var loads [][]byte
+	for i, seg := range stageSegments(p.samples, p.hz) {
+		t := tapeReader{periods: tapeCycles(seg)}
+		l, err := t.readLoads()
+		if err != nil {
+			return nil, fmt.Errorf("decode: stage %d: %w", i, err)
+		}
+		loads = append(loads, l...)
+	}
+	if len(loads) == 0 {
+		return nil, fmt.Errorf("decode: %w", noCalibrationTone)
+	}
+
+	return loads, nil
+}
+
+// DetectToneParams measures the length, in samples, of a single cycle of the
+// zero bit, one bit and start tones of a WAV file containing a Supercharger
+// tape. The measurements are taken from the first calibration tone and from the
+// start tone that precedes it. The lengths are rounded to the nearest sample
+//
+// The start cycle is zero if there is no start tone before the calibration tone
+func DetectToneParams(r io.Reader) (zeroCycle int, oneCycle int, startCycle int, err error) {
+	p, err := readWAV(r)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	periods := tapeCycles(p.samples)
+	start, zero, one, err := calibrate(periods, 0)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("detect: %w", err)
+	}
+
+	// the start tone is the run of long cycles immediately before the
+	// calibration tone
+	var sum float64
+	var ct int
+	for i := start - 1; i >= 0 && periods[i] > one*1.5; i-- {
+		sum += periods[i]
+		ct++
+	}
+	if ct > 0 {
+		startCycle = int(math.Round(sum / float64(ct)))
+	}
+
+	return int(math.Round(zero)), int(math.Round(one)), startCycle, nil
+}
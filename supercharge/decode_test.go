@@ -0,0 +1,368 @@
+package supercharge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// corruptWAV returns the WAV for the ROM with one byte of the data in the
+// numbered packet changed. the checksum of the packet is not changed so the
+// packet can not be read
+func corruptWAV(t *testing.T, rom []byte, packet int) []byte {
+	t.Helper()
+	opts := Default().WithProfile()
+	streams, reps, err := buildStreams([][]byte{rom}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams[0][8+packet*258+2+100] ^= 0xff
+
+	var b bytes.Buffer
+	_, err = convertStreams(streams, reps, NewWAVEncoder(&b), opts, newTones(opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		for i, opts := range []ConvertOptions{
+			{},
+			{BitDepth: 16},
+			{Volume: 0.25},
+			{Channels: 2, ChannelDelaySamples: 3},
+		} {
+			var b bytes.Buffer
+			_, err := ConvertWithOptions(rom, &b, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := Decode(&b)
+			if err != nil {
+				t.Fatalf("%d bytes, options %d: %v", size, i, err)
+			}
+			if !bytes.Equal(data, rom) {
+				t.Errorf("%d bytes, options %d: decoded data is different to the ROM", size, i)
+			}
+		}
+	}
+}
+
+func TestDecodeBadChecksum(t *testing.T) {
+	rom := testROM(4096)
+	for _, packet := range []int{0, 7, 15} {
+		_, err := Decode(bytes.NewReader(corruptWAV(t, rom, packet)))
+		if !errors.Is(err, BadChecksum) {
+			t.Fatalf("packet %d: decoding returned %v", packet, err)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("block %d:", packet)) {
+			t.Errorf("packet %d: error does not name the block: %v", packet, err)
+		}
+	}
+}
+
+func TestRepeatHeader(t *testing.T) {
+	rom := testROM(4096)
+	for _, repeat := range []bool{false, true} {
+		opts := ConvertOptions{RepeatHeader: repeat}
+		stream, _, err := BuildStream(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := roundTrip(t, rom, opts)
+		p, err := readWAV(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the first header is followed by the data packets
+		tr := tapeReader{periods: tapeCycles(p.samples)}
+		err = tr.sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr [8]byte
+		err = tr.read(hdr[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(hdr[:], stream[:8]) {
+			t.Fatalf("header is % 02x, expected % 02x", hdr, stream[:8])
+		}
+		_, err = tr.readLoad(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the repeated header is the only thing after the data packets
+		err = tr.sync()
+		if !repeat {
+			if err == nil {
+				t.Errorf("unexpected calibration tone after the data packets")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("repeated header: %v", err)
+		}
+		err = tr.read(hdr[:])
+		if err != nil {
+			t.Fatalf("repeated header: %v", err)
+		}
+		if !bytes.Equal(hdr[:], stream[:8]) {
+			t.Errorf("repeated header is % 02x, expected % 02x", hdr, stream[:8])
+		}
+
+		loads, err := DecodeMultiload(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loads) != 1 {
+			t.Errorf("repeated header decoded as %d loads", len(loads))
+		}
+	}
+}
+
+func TestDecodeMultiload(t *testing.T) {
+	loads := [][]byte{testROM(4096), testROM(2048), testROM(6144)}
+	for _, opts := range []ConvertOptions{
+		{},
+		{StageMarker: true},
+		{StageMarker: true, RepeatHeader: true},
+	} {
+		// the stages are recorded out of order, as they might be if each
+		// stage was converted separately. the multiload index of each header
+		// is not the position of the load on the tape
+		var streams [][]byte
+		var reps []ConvertReport
+		for i, index := range []byte{5, 2, 9} {
+			o := opts
+			o.Multiload = index
+			stream, rep, err := BuildStream(loads[i], o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streams = append(streams, stream)
+			reps = append(reps, rep)
+		}
+		var b bytes.Buffer
+		_, err := convertStreams(streams, reps, NewWAVEncoder(&b), opts, newTones(opts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = AssertLoadable(b.Bytes())
+		if err != nil {
+			t.Fatalf("%+v: output is not loadable: %v", opts, err)
+		}
+
+		// the loads are returned in the order they are on the tape
+		decoded, err := DecodeMultiload(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != len(loads) {
+			t.Fatalf("%+v: %d loads decoded but there are %d", opts, len(decoded), len(loads))
+		}
+		for i := range loads {
+			if !bytes.Equal(decoded[i], loads[i]) {
+				t.Errorf("%+v: load %d is different to the ROM", opts, i)
+			}
+		}
+	}
+}
+
+func TestDetectToneParams(t *testing.T) {
+	rom := testROM(4096)
+	for _, opts := range []ConvertOptions{
+		{},
+		{SampleRate: 48000},
+		{SampleRate: 22050},
+		{SampleRate: 96000, BitDepth: 16},
+		{Channels: 2, ChannelDelaySamples: 3},
+		{ResampleTo: 48000, Float32: true},
+	} {
+		w := roundTrip(t, rom, opts)
+		zero, one, start, err := DetectToneParams(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the cycles are generated at the generation rate and then scaled by
+		// resampling
+		scale := 1.0
+		if opts.ResampleTo > 0 {
+			scale = float64(opts.ResampleTo) / opts.generationRate()
+		}
+		for _, tc := range []struct {
+			name     string
+			measured int
+			cycle    int
+		}{
+			{"zero", zero, zeroToneCycle},
+			{"one", one, oneToneCycle},
+			{"start", start, startToneCycle},
+		} {
+			expected := int(math.Round(float64(opts.cycle(tc.cycle)) * scale))
+			if tc.measured != expected {
+				t.Errorf("%+v: %s cycle is %d samples but should be %d", opts, tc.name, tc.measured, expected)
+			}
+		}
+	}
+
+	// silence has no calibration tone
+	var b bytes.Buffer
+	enc := NewWAVEncoder(&b)
+	err := enc.WriteHeader(Format{SampleRate: 44100, Channels: 1, BitDepth: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteSamples(make([]float64, 44100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = DetectToneParams(bytes.NewReader(b.Bytes()))
+	if !errors.Is(err, noCalibrationTone) {
+		t.Errorf("error for silence is %v", err)
+	}
+}
+
+func TestPostHeaderSilence(t *testing.T) {
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		opts ConvertOptions
+		rate float64
+	}{
+		{ConvertOptions{PostHeaderSilenceSeconds: 0.1, Float32: true}, 44100},
+		{ConvertOptions{PostHeaderSilenceSeconds: 0.6, Float32: true}, 44100},
+		{ConvertOptions{PostHeaderSilenceSeconds: 0.25, Float32: true, SampleRate: 22050}, 22050},
+		{ConvertOptions{PostHeaderSilenceSeconds: 0.25, Float32: true, Channels: 2}, 44100},
+	} {
+		w := roundTrip(t, rom, tc.opts)
+		channels := OutputFormat(tc.opts).Channels
+
+		// the longest run of silent frames is the silence after the header.
+		// the run may include the zero crossing at either end
+		samples := wavSamples(t, w)
+		var start, length, run int
+		for i := 0; i < len(samples)/channels; i++ {
+			if samples[i*channels] == 0 {
+				run++
+				if run > length {
+					length = run
+					start = i - run + 1
+				}
+			} else {
+				run = 0
+			}
+		}
+		expected := int(math.Round(tc.opts.PostHeaderSilenceSeconds * tc.rate))
+		if length < expected || length > expected+2 {
+			t.Errorf("%.2fs at %.0fHz: silence is %d frames but should be %d", tc.opts.PostHeaderSilenceSeconds, tc.rate, length, expected)
+		}
+
+		// the silence ends where the first data packet begins. the zero
+		// crossing at the start of the packet is part of the run
+		rec := &packetRecorder{packets: make(map[int]bool)}
+		_, err := ConvertEncoder(rom, rec, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packets []int
+		for p := range rec.packets {
+			packets = append(packets, p)
+		}
+		sort.Ints(packets)
+		if end := start + length; end < packets[0] || end > packets[0]+1 {
+			t.Errorf("%.2fs at %.0fHz: silence ends at frame %d but the first packet is at %d", tc.opts.PostHeaderSilenceSeconds, tc.rate, end, packets[0])
+		}
+	}
+
+	// the silence can not be as long as a stage marker
+	_, err := ConvertWithOptions(rom, io.Discard, ConvertOptions{PostHeaderSilenceSeconds: 1, StageMarker: true})
+	if err == nil {
+		t.Errorf("silence as long as a stage marker should not be allowed")
+	}
+}
+
+func TestResyncMarker(t *testing.T) {
+	rom := testROM(4096)
+	var lengths [2]int
+	for i, marker := range []bool{false, true} {
+		opts := ConvertOptions{AppendResyncMarker: marker, TrailingSilenceSeconds: 0.5}
+		w := roundTrip(t, rom, opts)
+		lengths[i] = len(wavChunk(t, w, "data"))
+		p, err := readWAV(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tr := tapeReader{periods: tapeCycles(p.samples)}
+		err = tr.sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr [8]byte
+		err = tr.read(hdr[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tr.readLoad(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the marker begins with cycles of the start tone. there are no start
+		// tone cycles after the last load without a marker
+		var start int
+		for _, p := range tr.periods[tr.pos:] {
+			if math.Abs(p-startToneCycle) < 1 {
+				start++
+			}
+		}
+		expected := int(math.Floor(resyncToneSeconds * 44100 / startToneCycle))
+		if !marker {
+			if start != 0 {
+				t.Errorf("%d start tone cycles after the last load without a marker", start)
+			}
+			continue
+		}
+		if start != expected {
+			t.Errorf("%d start tone cycles in the resync marker but there should be %d", start, expected)
+		}
+
+		// the calibration bytes are not followed by a synchronisation byte
+		err = tr.sync()
+		if !errors.Is(err, endOfTape) {
+			t.Errorf("resync marker is followed by a synchronisation byte: %v", err)
+		}
+
+		// the marker is not mistaken for another load
+		loads, err := DecodeMultiload(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loads) != 1 {
+			t.Errorf("%d loads decoded from a tape with a resync marker", len(loads))
+		}
+	}
+
+	// the marker is a quarter of a second of the start tone and a quarter of
+	// a second of calibration bytes
+	seconds := float64(lengths[1]-lengths[0]) / 44100
+	if math.Abs(seconds-resyncToneSeconds-resyncCalibrationSeconds) > 0.01 {
+		t.Errorf("resync marker is %.3f seconds", seconds)
+	}
+}
@@ -0,0 +1,41 @@
+package supercharge
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// removeSpace removes all white space from the string. encoded data that has
+// been copied from a web page or a terminal is often split over several lines
+func removeSpace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// ConvertHex is the same as ConvertWithOptions except that the ROM data is
+// given as a string of hexadecimal digits. White space in the string is
+// ignored
+//
+// If the string can not be decoded the BadEncoding error is returned. Errors
+// from the validation of the decoded ROM are returned as normal
+func ConvertHex(romHex string, w io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	rom, err := hex.DecodeString(removeSpace(romHex))
+	if err != nil {
+		return ConvertReport{}, fmt.Errorf("%w (hex: %v)", BadEncoding, err)
+	}
+	return ConvertWithOptions(rom, w, opts)
+}
+
+// ConvertBase64 is the same as ConvertHex except that the ROM data is given
+// as a string of standard base64 encoded data, as described by RFC 4648. The
+// padding at the end of the string is optional
+func ConvertBase64(romBase64 string, w io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	s := strings.TrimRight(removeSpace(romBase64), "=")
+	rom, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return ConvertReport{}, fmt.Errorf("%w (base64: %v)", BadEncoding, err)
+	}
+	return ConvertWithOptions(rom, w, opts)
+}
@@ -0,0 +1,84 @@
+package supercharge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// convertHex and convertBase64 convert the encoded ROM with the default
+// options
+func convertHex(s string, b *bytes.Buffer) error {
+	_, err := ConvertHex(s, b, ConvertOptions{})
+	return err
+}
+
+func convertBase64(s string, b *bytes.Buffer) error {
+	_, err := ConvertBase64(s, b, ConvertOptions{})
+	return err
+}
+
+func TestConvertEncoded(t *testing.T) {
+	rom := testROM(4096)
+	var expected bytes.Buffer
+	_, err := ConvertWithOptions(rom, &expected, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// white space is ignored and base64 padding is optional. the base64
+	// encoding of a 4K ROM ends with two padding characters
+	h := hex.EncodeToString(rom)
+	b64 := base64.StdEncoding.EncodeToString(rom)
+	for _, tc := range []struct {
+		name    string
+		convert func(string, *bytes.Buffer) error
+		data    string
+	}{
+		{"hex", convertHex, h},
+		{"hex with white space", convertHex, h[:100] + "\n\t " + strings.ToUpper(h[100:])},
+		{"base64", convertBase64, b64},
+		{"base64 with white space", convertBase64, b64[:64] + "\r\n" + b64[64:]},
+		{"base64 without padding", convertBase64, strings.TrimRight(b64, "=")},
+	} {
+		var b bytes.Buffer
+		err := tc.convert(tc.data, &b)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(b.Bytes(), expected.Bytes()) {
+			t.Errorf("%s: output is different to the output for the ROM", tc.name)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		convert func(string, *bytes.Buffer) error
+		data    string
+	}{
+		{"hex with an odd number of digits", convertHex, h[:len(h)-1]},
+		{"hex with a bad digit", convertHex, "g" + h[1:]},
+		{"base64 with a bad character", convertBase64, "*" + base64.StdEncoding.EncodeToString(rom)[1:]},
+		{"base64 with a bad length", convertBase64, base64.StdEncoding.EncodeToString(rom)[:5]},
+		{"base64 url encoding", convertBase64, strings.Repeat("-_", 8)},
+	} {
+		var b bytes.Buffer
+		err := tc.convert(tc.data, &b)
+		if !errors.Is(err, BadEncoding) {
+			t.Errorf("%s: error is %v", tc.name, err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("%s: %d bytes written", tc.name, b.Len())
+		}
+	}
+
+	// a ROM of the wrong size is an error of the ROM and not of the encoding
+	var b bytes.Buffer
+	err = convertHex(h[:4000], &b)
+	if !errors.Is(err, UnsupportedSize) || errors.Is(err, BadEncoding) {
+		t.Errorf("error for a hex encoded ROM of the wrong size is %v", err)
+	}
+}
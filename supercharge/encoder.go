@@ -0,0 +1,248 @@
+package supercharge
+
+import (
+	"io"
+	"math"
+)
+
+// Format describes the audio that is passed to an Encoder
+type Format struct {
+	// the number of samples per second for each channel
+	SampleRate uint32
+
+	// the number of channels. samples for each channel are interleaved
+	Channels int
+
+	// how sample values should be quantized by encoders that store samples
+	// as integer values
+	Rounding Rounding
+
+	// encoders that support floating point samples should store samples as
+	// 32 bit floating point values
+	Float32 bool
+
+	// the number of bits in each integer sample. either 8 (unsigned) or 16
+	// (signed). encoders that can not store the depth should store 8 bit
+	// samples
+	BitDepth int
+
+	// encoders that write WAV files should use the WAVE_FORMAT_EXTENSIBLE
+	// layout of the fmt chunk
+	Extensible bool
+}
+
+// Encoder is the interface for types that write the audio produced by a
+// conversion to a container format, such as a WAV file
+//
+// WriteHeader is called once, before any samples are written. WriteSamples is
+// called any number of times with samples in the range -1 to +1. The samples
+// for each channel are interleaved. Finalize is called once after the last
+// sample has been written
+//
+// If any method returns an error the conversion stops and the error is
+// returned to the caller. Finalize is not called in that case
+type Encoder interface {
+	WriteHeader(f Format) error
+	WriteSamples(samples []float64) error
+	Finalize() error
+}
+
+// encoders that can mark positions in the output implement cueEncoder. the cue
+// method is called with the frame position at the start of each data packet
+type cueEncoder interface {
+	cue(frame int)
+}
+
+// encoders that need to know where each packet begins, whether or not cue
+// points have been requested, implement packetEncoder. the packet method is
+// called with the frame position at the start of each packet
+type packetEncoder interface {
+	packet(frame int)
+}
+
+// encoders that can write their output as it is generated, if they know the
+// length of the output in advance, implement sizedEncoder. the expect method
+// is called before WriteHeader with the number of frames and the number of cue
+// points that will be written
+type sizedEncoder interface {
+	expect(frames int, cues int)
+}
+
+// quantize8 converts a sample in the range -1 to +1 to an unsigned 8 bit value
+func quantize8(s float64, rounding Rounding) byte {
+	y := (s + 1) * 128
+	if rounding == RoundNearest {
+		y = math.Round(y)
+	}
+	return byte(math.Max(0, math.Min(255, y)))
+}
+
+// quantize16 converts a sample in the range -1 to +1 to a signed 16 bit value.
+// the scaling is the same as quantize8 so that an 8 bit sample is the high
+// byte of the 16 bit sample, offset by 128
+func quantize16(s float64, rounding Rounding) int16 {
+	y := (s + 1) * 32768
+	if rounding == RoundNearest {
+		y = math.Round(y)
+	}
+	return int16(int(math.Max(0, math.Min(65535, y))) - 32768)
+}
+
+// NewRawEncoder returns an Encoder that writes samples as unsigned 8 bit
+// values, or as signed 16 bit little-endian values if the BitDepth field of the
+// Format is 16, without any header. Samples are written to the io.Writer as
+// soon as they are received
+func NewRawEncoder(w io.Writer) Encoder {
+	return &rawEncoder{w: w}
+}
+
+type rawEncoder struct {
+	w        io.Writer
+	rounding Rounding
+	depth    int
+	buf      []byte
+}
+
+func (enc *rawEncoder) WriteHeader(f Format) error {
+	enc.rounding = f.Rounding
+	enc.depth = f.BitDepth
+	return nil
+}
+
+func (enc *rawEncoder) WriteSamples(samples []float64) error {
+	enc.buf = enc.buf[:0]
+	for _, s := range samples {
+		if enc.depth == 16 {
+			v := quantize16(s, enc.rounding)
+			enc.buf = append(enc.buf, byte(v), byte(v>>8))
+			continue
+		}
+		enc.buf = append(enc.buf, quantize8(s, enc.rounding))
+	}
+	_, err := enc.w.Write(enc.buf)
+	return err
+}
+
+func (enc *rawEncoder) Finalize() error {
+	return nil
+}
+
+// splitEncoder passes each channel of a stereo conversion to a separate mono
+// Encoder
+type splitEncoder struct {
+	left  Encoder
+	right Encoder
+	buf   []float64
+}
+
+func (enc *splitEncoder) WriteHeader(f Format) error {
+	f.Channels = 1
+	err := enc.left.WriteHeader(f)
+	if err != nil {
+		return err
+	}
+	return enc.right.WriteHeader(f)
+}
+
+func (enc *splitEncoder) WriteSamples(samples []float64) error {
+	for ch, e := range []Encoder{enc.left, enc.right} {
+		enc.buf = enc.buf[:0]
+		for i := ch; i < len(samples); i += 2 {
+			enc.buf = append(enc.buf, samples[i])
+		}
+		err := e.WriteSamples(enc.buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *splitEncoder) expect(frames int, cues int) {
+	for _, e := range []Encoder{enc.left, enc.right} {
+		if s, ok := e.(sizedEncoder); ok {
+			s.expect(frames, cues)
+		}
+	}
+}
+
+func (enc *splitEncoder) cue(frame int) {
+	for _, e := range []Encoder{enc.left, enc.right} {
+		if c, ok := e.(cueEncoder); ok {
+			c.cue(frame)
+		}
+	}
+}
+
+func (enc *splitEncoder) Finalize() error {
+	err := enc.left.Finalize()
+	if err != nil {
+		return err
+	}
+	return enc.right.Finalize()
+}
+
+// MultiEncoder returns an Encoder that passes the audio to every Encoder in the
+// list, in the same way as io.MultiWriter. An error from any Encoder stops the
+// conversion
+func MultiEncoder(encs ...Encoder) Encoder {
+	return &multiEncoder{encs: encs}
+}
+
+type multiEncoder struct {
+	encs []Encoder
+}
+
+func (enc *multiEncoder) WriteHeader(f Format) error {
+	for _, e := range enc.encs {
+		err := e.WriteHeader(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *multiEncoder) WriteSamples(samples []float64) error {
+	for _, e := range enc.encs {
+		err := e.WriteSamples(samples)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *multiEncoder) expect(frames int, cues int) {
+	for _, e := range enc.encs {
+		if s, ok := e.(sizedEncoder); ok {
+			s.expect(frames, cues)
+		}
+	}
+}
+
+func (enc *multiEncoder) cue(frame int) {
+	for _, e := range enc.encs {
+		if c, ok := e.(cueEncoder); ok {
+			c.cue(frame)
+		}
+	}
+}
+
+func (enc *multiEncoder) packet(frame int) {
+	for _, e := range enc.encs {
+		if p, ok := e.(packetEncoder); ok {
+			p.packet(frame)
+		}
+	}
+}
+
+func (enc *multiEncoder) Finalize() error {
+	for _, e := range enc.encs {
+		err := e.Finalize()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package supercharge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRawOutput(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{Channels: 2, ChannelDelaySamples: 5},
+		{BitDepth: 16},
+		{ResampleTo: 48000, Rounding: RoundNearest},
+		{Dither: true, Seed: 7, CueChunk: true},
+	} {
+		var w bytes.Buffer
+		_, err := ConvertWithOptions(rom, &w, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var raw bytes.Buffer
+		rep, err := ConvertToPlayer(rom, &raw, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(raw.Bytes(), wavChunk(t, w.Bytes(), "data")) {
+			t.Errorf("options %d: raw output is different to the data chunk of the WAV", i)
+		}
+
+		// the report describes the raw samples
+		if rep.SampleRate != opts.sampleRate() || rep.Channels != opts.channels() || rep.BitDepth != opts.bitDepth() {
+			t.Errorf("options %d: report is %dHz, %d channels, %d bits", i, rep.SampleRate, rep.Channels, rep.BitDepth)
+		}
+	}
+}
+
+// formatEncoder records the Format given to WriteHeader
+type formatEncoder struct {
+	format Format
+}
+
+func (enc *formatEncoder) WriteHeader(f Format) error           { enc.format = f; return nil }
+func (enc *formatEncoder) WriteSamples(samples []float64) error { return nil }
+func (enc *formatEncoder) Finalize() error                      { return nil }
+
+func TestOutputFormat(t *testing.T) {
+	f := OutputFormat(Default())
+	if f.SampleRate != 44100 || f.Channels != 1 || f.BitDepth != 8 || f.Float32 {
+		t.Errorf("default format is %+v", f)
+	}
+
+	rom := testROM(4096)
+	for i, opts := range measureOptions {
+		enc := &formatEncoder{}
+		_, err := ConvertEncoder(rom, enc, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := OutputFormat(opts); f != enc.format {
+			t.Errorf("options %d: format is %+v but the encoder was given %+v", i, f, enc.format)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	// the zero bit tone at the default volume. the samples are 0, 0.8487,
+	// 0.8487, 0, -0.8487 and -0.8487
+	zero := tone(zeroToneCycle, zeroToneVolume, 0, WaveformSine)
+
+	for _, tc := range []struct {
+		rounding Rounding
+		q8       []byte
+		q16      []int16
+	}{
+		{RoundTruncate, []byte{128, 236, 236, 128, 19, 19}, []int16{0, 27810, 27810, 0, -27811, -27811}},
+		{RoundNearest, []byte{128, 237, 237, 128, 19, 19}, []int16{0, 27810, 27810, 0, -27810, -27810}},
+	} {
+		for i, s := range zero {
+			if q := quantize8(s, tc.rounding); q != tc.q8[i] {
+				t.Errorf("rounding %d: 8 bit sample %d is %d but should be %d", tc.rounding, i, q, tc.q8[i])
+			}
+			if q := quantize16(s, tc.rounding); q != tc.q16[i] {
+				t.Errorf("rounding %d: 16 bit sample %d is %d but should be %d", tc.rounding, i, q, tc.q16[i])
+			}
+		}
+
+		// the output of a conversion is quantized in the same way
+		var raw bytes.Buffer
+		_, err := ConvertToPlayer(testROM(2048), &raw, ConvertOptions{Rounding: tc.rounding})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(raw.Bytes(), tc.q8) {
+			t.Errorf("rounding %d: output does not contain the quantized zero bit tone", tc.rounding)
+		}
+	}
+}
+
+// recordingPlayer records each write made to it
+type recordingPlayer struct {
+	writes [][]byte
+}
+
+func (p *recordingPlayer) Write(b []byte) (int, error) {
+	p.writes = append(p.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func TestConvertToPlayer(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{BitDepth: 16},
+		{Channels: 2, ChannelDelaySamples: 3},
+		{ResampleTo: 48000},
+	} {
+		var w bytes.Buffer
+		_, err := ConvertWithOptions(rom, &w, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := &recordingPlayer{}
+		_, err = ConvertToPlayer(rom, p, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the samples are written as they are generated, not all at once
+		if len(p.writes) < 2 {
+			t.Errorf("options %d: %d writes to the player", i, len(p.writes))
+		}
+
+		if !bytes.Equal(bytes.Join(p.writes, nil), wavChunk(t, w.Bytes(), "data")) {
+			t.Errorf("options %d: samples written to the player are different to the data chunk of the WAV", i)
+		}
+	}
+}
+
+// sampleEncoder keeps a copy of every sample it is given, and records the
+// order in which its methods are called
+type sampleEncoder struct {
+	format   Format
+	samples  []float64
+	calls    []string
+	finalize int
+}
+
+func (enc *sampleEncoder) WriteHeader(f Format) error {
+	enc.format = f
+	enc.calls = append(enc.calls, "header")
+	return nil
+}
+
+func (enc *sampleEncoder) WriteSamples(samples []float64) error {
+	// the slice may be reused by the caller after WriteSamples returns
+	enc.samples = append(enc.samples, samples...)
+	if len(enc.calls) == 0 || enc.calls[len(enc.calls)-1] != "samples" {
+		enc.calls = append(enc.calls, "samples")
+	}
+	return nil
+}
+
+func (enc *sampleEncoder) Finalize() error {
+	enc.finalize++
+	enc.calls = append(enc.calls, "finalize")
+	return nil
+}
+
+func TestCustomEncoder(t *testing.T) {
+	rom := testROM(4096)
+	for _, opts := range []ConvertOptions{
+		{Float32: true},
+		{Float32: true, Channels: 2, ChannelDelaySamples: 3},
+		{Float32: true, ResampleTo: 48000},
+	} {
+		enc := &sampleEncoder{}
+		_, err := ConvertEncoder(rom, enc, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(enc.calls, ",") != "header,samples,finalize" {
+			t.Errorf("%+v: encoder methods called in the order %v", opts, enc.calls)
+		}
+		if enc.format != OutputFormat(opts) {
+			t.Errorf("%+v: encoder format is %+v but should be %+v", opts, enc.format, OutputFormat(opts))
+		}
+
+		// the samples given to the encoder are the samples of the WAV file
+		samples := wavSamples(t, roundTrip(t, rom, opts))
+		if len(enc.samples) != len(samples) {
+			t.Fatalf("%+v: encoder received %d samples but the WAV file has %d", opts, len(enc.samples), len(samples))
+		}
+		for i, s := range enc.samples {
+			if float64(float32(s)) != samples[i] {
+				t.Fatalf("%+v: sample %d is %f but the WAV file sample is %f", opts, i, s, samples[i])
+			}
+		}
+	}
+
+	// the encoder is not finalized if the conversion fails
+	enc := &sampleEncoder{}
+	_, err := ConvertEncoder(make([]byte, 100), enc, ConvertOptions{})
+	if err == nil {
+		t.Fatal("conversion of a bad ROM should fail")
+	}
+	if enc.finalize != 0 {
+		t.Errorf("encoder finalized after a failed conversion")
+	}
+}
@@ -0,0 +1,681 @@
+package supercharge
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// NewFLACEncoder returns an Encoder that writes samples to a FLAC file. FLAC is
+// lossless so the samples are exactly the same as those in a WAV file, but the
+// file is smaller. The samples are 8 bit values, or 16 bit values if the
+// BitDepth field of the Format is 16. FLAC has no floating point samples so the
+// Float32 field of the Format is ignored
+//
+// The encoded frames are kept until the Encoder is finalized, when the entire
+// FLAC file is written. The stream information at the start of the file
+// includes the number of samples and the MD5 signature of the audio
+func NewFLACEncoder(w io.Writer) Encoder {
+	return &flacEncoder{w: w}
+}
+
+// the number of samples in each channel of a FLAC frame. the last frame may be
+// shorter
+const flacBlockSize = 4096
+
+// the largest Rice parameter that can be written with the four bit parameter
+// field. the value 15 is reserved as an escape code
+const flacMaxRiceParameter = 14
+
+type flacEncoder struct {
+	w io.Writer
+
+	channels int
+	hz       uint32
+	depth    int
+	rounding Rounding
+
+	// the samples of each channel that have not yet been encoded
+	block [][]int32
+
+	// the encoded frames
+	frames bytes.Buffer
+	count  uint64
+
+	// the number of samples in each channel written so far
+	samples uint64
+
+	// the smallest and largest encoded frame, in bytes
+	minFrame int
+	maxFrame int
+
+	// signature of the samples as signed little-endian values
+	md5 hash.Hash
+	buf []byte
+}
+
+func (enc *flacEncoder) WriteHeader(f Format) error {
+	if f.Channels < 1 || f.Channels > 2 {
+		return fmt.Errorf("flac: unsupported number of channels (%d)", f.Channels)
+	}
+	enc.channels = f.Channels
+	enc.hz = f.SampleRate
+	enc.depth = 8
+	if f.BitDepth == 16 {
+		enc.depth = 16
+	}
+	enc.rounding = f.Rounding
+	enc.block = make([][]int32, f.Channels)
+	enc.md5 = md5.New()
+	return nil
+}
+
+func (enc *flacEncoder) WriteSamples(samples []float64) error {
+	// samples are always written as complete frames
+	if len(samples)%enc.channels != 0 {
+		return fmt.Errorf("flac: %d samples is not a whole number of frames", len(samples))
+	}
+
+	enc.buf = enc.buf[:0]
+	for i, s := range samples {
+		var v int32
+		if enc.depth == 16 {
+			v = int32(quantize16(s, enc.rounding))
+			enc.buf = append(enc.buf, byte(v), byte(v>>8))
+		} else {
+			v = int32(quantize8(s, enc.rounding)) - 128
+			enc.buf = append(enc.buf, byte(v))
+		}
+
+		ch := i % enc.channels
+		enc.block[ch] = append(enc.block[ch], v)
+		if ch == enc.channels-1 && len(enc.block[ch]) == flacBlockSize {
+			enc.encodeFrame()
+		}
+	}
+	enc.md5.Write(enc.buf)
+
+	return nil
+}
+
+func (enc *flacEncoder) Finalize() error {
+	if len(enc.block[0]) > 0 {
+		enc.encodeFrame()
+	}
+
+	var w bytes.Buffer
+	w.WriteString("fLaC")
+
+	// the stream information is the only metadata block
+	var info flacBits
+	info.write(1, 1)
+	info.write(0, 7)
+	info.write(34, 24)
+	blockSize := uint64(flacBlockSize)
+	if enc.count == 1 {
+		blockSize = enc.samples
+	}
+	info.write(blockSize, 16)
+	info.write(blockSize, 16)
+	info.write(uint64(enc.minFrame), 24)
+	info.write(uint64(enc.maxFrame), 24)
+	info.write(uint64(enc.hz), 20)
+	info.write(uint64(enc.channels-1), 3)
+	info.write(uint64(enc.depth-1), 5)
+	info.write(enc.samples, 36)
+	w.Write(info.bytes())
+	w.Write(enc.md5.Sum(nil))
+
+	w.Write(enc.frames.Bytes())
+
+	_, err := enc.w.Write(w.Bytes())
+	return err
+}
+
+// encodeFrame encodes the samples in the current block as a single frame
+func (enc *flacEncoder) encodeFrame() {
+	n := len(enc.block[0])
+
+	// the channels are coded independently unless a stereo frame is smaller
+	// when coded as the left channel and the difference between the channels.
+	// the difference needs one more bit than the samples
+	assignment := uint64(enc.channels - 1)
+	subframes := make([]flacBits, enc.channels)
+	for ch, samples := range enc.block {
+		subframes[ch].subframe(samples, enc.depth)
+	}
+	if enc.channels == 2 {
+		side := make([]int32, n)
+		for i := range side {
+			side[i] = enc.block[0][i] - enc.block[1][i]
+		}
+		var s flacBits
+		s.subframe(side, enc.depth+1)
+		if s.len() < subframes[1].len() {
+			assignment = 0x8
+			subframes[1] = s
+		}
+	}
+
+	var b flacBits
+
+	// frame header. the block size is always given at the end of the header
+	// so that the last frame can be any length
+	b.write(0x3ffe, 14)
+	b.write(0, 1)
+	b.write(0, 1)
+	b.write(0x7, 4)
+	rateCode, rateBits, rate := flacRateCode(enc.hz)
+	b.write(rateCode, 4)
+	b.write(assignment, 4)
+	if enc.depth == 16 {
+		b.write(0x4, 3)
+	} else {
+		b.write(0x1, 3)
+	}
+	b.write(0, 1)
+	b.writeBytes(flacFrameNumber(enc.count))
+	b.write(uint64(n-1), 16)
+	if rateBits > 0 {
+		b.write(rate, rateBits)
+	}
+	b.writeBytes([]byte{flacCRC8(b.bytes())})
+
+	for i := range subframes {
+		b.append(&subframes[i])
+	}
+
+	// the frame ends on a byte boundary and is followed by the checksum of the
+	// whole frame
+	b.align()
+	crc := flacCRC16(b.bytes())
+	b.write(uint64(crc), 16)
+
+	frame := b.bytes()
+	if enc.count == 0 || len(frame) < enc.minFrame {
+		enc.minFrame = len(frame)
+	}
+	if len(frame) > enc.maxFrame {
+		enc.maxFrame = len(frame)
+	}
+	enc.frames.Write(frame)
+	enc.count++
+	enc.samples += uint64(n)
+
+	for ch := range enc.block {
+		enc.block[ch] = enc.block[ch][:0]
+	}
+}
+
+// flacRateCode returns the sample rate code of a frame header for the rate.
+// rates that do not have a code of their own are written at the end of the
+// header, in which case the value and the number of bits are also returned
+func flacRateCode(hz uint32) (code uint64, bits int, value uint64) {
+	switch hz {
+	case 88200:
+		return 0x1, 0, 0
+	case 176400:
+		return 0x2, 0, 0
+	case 192000:
+		return 0x3, 0, 0
+	case 8000:
+		return 0x4, 0, 0
+	case 16000:
+		return 0x5, 0, 0
+	case 22050:
+		return 0x6, 0, 0
+	case 24000:
+		return 0x7, 0, 0
+	case 32000:
+		return 0x8, 0, 0
+	case 44100:
+		return 0x9, 0, 0
+	case 48000:
+		return 0xa, 0, 0
+	case 96000:
+		return 0xb, 0, 0
+	}
+	if hz%1000 == 0 && hz/1000 <= 0xff {
+		return 0xc, 8, uint64(hz / 1000)
+	}
+	if hz <= 0xffff {
+		return 0xd, 16, uint64(hz)
+	}
+	if hz%10 == 0 && hz/10 <= 0xffff {
+		return 0xe, 16, uint64(hz / 10)
+	}
+
+	// the rate is taken from the stream information
+	return 0x0, 0, 0
+}
+
+// flacFrameNumber returns the frame number coded in the same way as a UTF-8
+// character, extended to 36 bits
+func flacFrameNumber(n uint64) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	// the number of continuation bytes needed for the value
+	var c int
+	for c = 1; n >= 1<<(6*c+6-c); c++ {
+	}
+
+	b := make([]byte, c+1)
+	for i := c; i > 0; i-- {
+		b[i] = 0x80 | byte(n&0x3f)
+		n >>= 6
+	}
+	b[0] = byte(0xff<<(7-c)) | byte(n)
+	return b
+}
+
+// the largest order of the linear predictors tried for each subframe, and the
+// precision of the quantized predictor coefficients
+const (
+	flacMaxLPCOrder  = 32
+	flacLPCPrecision = 12
+)
+
+// the largest partition order tried for the Rice coded residual
+const flacMaxPartitionOrder = 8
+
+// flacPredictor is a way of predicting the samples of a subframe. the order is
+// the number of warm-up samples. a fixed predictor has no coefficients
+type flacPredictor struct {
+	order    int
+	fixed    bool
+	coefs    []int32
+	shift    int
+	residual []int32
+}
+
+// subframe writes the samples of one channel using the predictor that codes
+// the samples in the fewest bits. a block of samples that are all the same
+// value is written as a constant subframe
+func (b *flacBits) subframe(samples []int32, depth int) {
+	constant := true
+	for _, s := range samples {
+		if s != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		b.write(0, 8)
+		b.writeSigned(samples[0], depth)
+		return
+	}
+
+	var best flacPredictor
+	bestBits := -1
+	bestPartition := 0
+	for _, p := range flacPredictors(samples) {
+		partition, bits := flacPartitionOrder(p.residual, len(samples), p.order)
+		bits += p.order * depth
+		if !p.fixed {
+			bits += 4 + 5 + len(p.coefs)*flacLPCPrecision
+		}
+		if bestBits == -1 || bits < bestBits {
+			best = p
+			bestBits = bits
+			bestPartition = partition
+		}
+	}
+
+	// a verbatim subframe is used if prediction does not help
+	if bestBits == -1 || bestBits >= len(samples)*depth {
+		b.write(0x02, 8)
+		for _, s := range samples {
+			b.writeSigned(s, depth)
+		}
+		return
+	}
+
+	b.write(0, 1)
+	if best.fixed {
+		b.write(uint64(0x08|best.order), 6)
+	} else {
+		b.write(uint64(0x20|(best.order-1)), 6)
+	}
+	b.write(0, 1)
+	for _, s := range samples[:best.order] {
+		b.writeSigned(s, depth)
+	}
+	if !best.fixed {
+		b.write(flacLPCPrecision-1, 4)
+		b.write(uint64(best.shift), 5)
+		for _, c := range best.coefs {
+			b.writeSigned(c, flacLPCPrecision)
+		}
+	}
+	b.residual(best.residual, len(samples), best.order, bestPartition)
+}
+
+// flacPredictors returns the fixed predictors and a selection of linear
+// predictors for the samples, with the residual of each
+func flacPredictors(samples []int32) []flacPredictor {
+	var predictors []flacPredictor
+
+	// the residual of each fixed predictor is the difference of the residual
+	// of the fixed predictor of the order below
+	residual := samples
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		if order > 0 {
+			r := make([]int32, len(residual)-1)
+			for i := range r {
+				r[i] = residual[i+1] - residual[i]
+			}
+			residual = r
+		}
+		predictors = append(predictors, flacPredictor{order: order, fixed: true, residual: residual})
+	}
+
+	// the linear predictors of every order are found together
+	maxOrder := flacMaxLPCOrder
+	if maxOrder >= len(samples) {
+		maxOrder = len(samples) - 1
+	}
+	lpc := flacLPC(samples, maxOrder)
+	for _, order := range []int{2, 4, 6, 8, 10, 12, 16, 20, 24, 32} {
+		if order > len(lpc) || lpc[order-1] == nil {
+			continue
+		}
+		coefs, shift, ok := flacQuantize(lpc[order-1])
+		if !ok {
+			continue
+		}
+		r := make([]int32, len(samples)-order)
+		for i := order; i < len(samples); i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += int64(c) * int64(samples[i-1-j])
+			}
+			r[i-order] = samples[i] - int32(sum>>shift)
+		}
+		predictors = append(predictors, flacPredictor{order: order, coefs: coefs, shift: shift, residual: r})
+	}
+
+	return predictors
+}
+
+// flacLPC returns the coefficients of the linear predictors of each order up
+// to the maximum, found from the autocorrelation of the samples with the
+// Levinson-Durbin method. the coefficient for the most recent sample is first.
+// the list stops at the first order that can not be found
+func flacLPC(samples []int32, maxOrder int) [][]float64 {
+	if maxOrder < 1 {
+		return nil
+	}
+
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < len(samples); i++ {
+			sum += float64(samples[i]) * float64(samples[i-lag])
+		}
+		autoc[lag] = sum
+	}
+	if autoc[0] == 0 {
+		return nil
+	}
+
+	var lpc [][]float64
+	a := make([]float64, 0, maxOrder)
+	err := autoc[0]
+	for order := 1; order <= maxOrder; order++ {
+		k := autoc[order]
+		for j, c := range a {
+			k -= c * autoc[order-1-j]
+		}
+		k /= err
+
+		next := make([]float64, order)
+		for j, c := range a {
+			next[j] = c - k*a[order-2-j]
+		}
+		next[order-1] = k
+		a = next
+
+		err *= 1 - k*k
+		lpc = append(lpc, a)
+		if err <= 0 {
+			break
+		}
+	}
+
+	return lpc
+}
+
+// flacQuantize converts the coefficients of a linear predictor to integers of
+// flacLPCPrecision bits and a shift. returns false if the coefficients can not
+// be quantized
+func flacQuantize(lpc []float64) ([]int32, int, bool) {
+	var cmax float64
+	for _, c := range lpc {
+		if c < 0 {
+			c = -c
+		}
+		if c > cmax {
+			cmax = c
+		}
+	}
+	if cmax == 0 {
+		return nil, 0, false
+	}
+
+	// the shift gives the largest coefficient all of the precision
+	shift := flacLPCPrecision - 1
+	for v := cmax; v >= 1; v /= 2 {
+		shift--
+	}
+	if shift > 15 {
+		shift = 15
+	}
+	if shift < 0 {
+		return nil, 0, false
+	}
+
+	// the error of each rounded coefficient is carried into the next
+	limit := float64(int(1)<<(flacLPCPrecision-1)) - 1
+	coefs := make([]int32, len(lpc))
+	var carry float64
+	for i, c := range lpc {
+		v := c*float64(int(1)<<shift) + carry
+		q := v
+		if q > limit {
+			q = limit
+		} else if q < -limit-1 {
+			q = -limit - 1
+		}
+		if q < 0 {
+			q = -float64(int64(-q + 0.5))
+		} else {
+			q = float64(int64(q + 0.5))
+		}
+		carry = v - q
+		coefs[i] = int32(q)
+	}
+
+	return coefs, shift, true
+}
+
+// zigzag maps a signed residual to an unsigned value for Rice coding
+func zigzag(r int32) uint64 {
+	return uint64(uint32(r<<1) ^ uint32(r>>31))
+}
+
+// flacPartitionOrder returns the partition order that codes the residual in
+// the fewest bits, and the number of bits. the residual begins after the
+// warm-up samples of the predictor
+func flacPartitionOrder(residual []int32, blockSize int, order int) (int, int) {
+	bestOrder := 0
+	bestBits := -1
+	for po := 0; po <= flacMaxPartitionOrder; po++ {
+		if blockSize%(1<<po) != 0 || blockSize>>po <= order {
+			break
+		}
+		bits := 2 + 4
+		for _, part := range flacPartitions(residual, blockSize, order, po) {
+			_, b := flacRiceParameter(part)
+			bits += 4 + b
+		}
+		if bestBits == -1 || bits < bestBits {
+			bestOrder = po
+			bestBits = bits
+		}
+	}
+	return bestOrder, bestBits
+}
+
+// flacPartitions divides the residual into the partitions of the partition
+// order. the first partition is shorter than the others by the number of
+// warm-up samples
+func flacPartitions(residual []int32, blockSize int, order int, po int) [][]int32 {
+	n := blockSize >> po
+	parts := [][]int32{residual[:n-order]}
+	for s := n - order; s < len(residual); s += n {
+		parts = append(parts, residual[s:s+n])
+	}
+	return parts
+}
+
+// residual writes the residual with Rice coding in partitions of the
+// partition order
+func (b *flacBits) residual(residual []int32, blockSize int, order int, po int) {
+	b.write(0, 2)
+	b.write(uint64(po), 4)
+	for _, part := range flacPartitions(residual, blockSize, order, po) {
+		k, _ := flacRiceParameter(part)
+		b.write(uint64(k), 4)
+		for _, r := range part {
+			u := zigzag(r)
+			for q := u >> k; q > 0; q-- {
+				b.write(0, 1)
+			}
+			b.write(1, 1)
+			b.write(u&(1<<k-1), k)
+		}
+	}
+}
+
+// flacRiceParameter returns the Rice parameter that codes the residuals in the
+// fewest bits, and the number of bits
+func flacRiceParameter(residual []int32) (int, int) {
+	var sum uint64
+	for _, r := range residual {
+		sum += zigzag(r)
+	}
+
+	best := 0
+	var bestBits uint64
+	for k := 0; k <= flacMaxRiceParameter; k++ {
+		bits := uint64(len(residual)*(k+1)) + sum>>k
+		if k == 0 || bits < bestBits {
+			best = k
+			bestBits = bits
+		}
+	}
+	return best, int(bestBits)
+}
+
+// flacBits collects values of any number of bits, most significant bit first
+type flacBits struct {
+	data []byte
+	acc  uint64
+	n    int
+}
+
+// write adds the low bits of the value
+func (b *flacBits) write(v uint64, bits int) {
+	for bits > 0 {
+		c := 8 - b.n
+		if c > bits {
+			c = bits
+		}
+		bits -= c
+		b.acc = b.acc<<c | (v>>bits)&(1<<c-1)
+		b.n += c
+		if b.n == 8 {
+			b.data = append(b.data, byte(b.acc))
+			b.acc = 0
+			b.n = 0
+		}
+	}
+}
+
+// writeSigned adds a two's complement value
+func (b *flacBits) writeSigned(v int32, bits int) {
+	b.write(uint64(v)&(1<<bits-1), bits)
+}
+
+func (b *flacBits) writeBytes(p []byte) {
+	for _, v := range p {
+		b.write(uint64(v), 8)
+	}
+}
+
+// align pads the bits with zeros to a byte boundary
+func (b *flacBits) align() {
+	if b.n > 0 {
+		b.write(0, 8-b.n)
+	}
+}
+
+// len returns the number of bits written
+func (b *flacBits) len() int {
+	return len(b.data)*8 + b.n
+}
+
+// append adds all the bits written to another flacBits
+func (b *flacBits) append(o *flacBits) {
+	if b.n == 0 {
+		b.data = append(b.data, o.data...)
+	} else {
+		for _, v := range o.data {
+			b.write(uint64(v), 8)
+		}
+	}
+	b.write(o.acc, o.n)
+}
+
+// bytes returns the complete bytes written so far
+func (b *flacBits) bytes() []byte {
+	return b.data
+}
+
+// flacCRC8 is the checksum of a frame header. the polynomial is
+// x^8 + x^2 + x^1 + x^0
+func flacCRC8(data []byte) byte {
+	var crc byte
+	for _, v := range data {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// flacCRC16 is the checksum of a frame. the polynomial is
+// x^16 + x^15 + x^2 + x^0
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, v := range data {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
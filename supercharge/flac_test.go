@@ -0,0 +1,327 @@
+package supercharge
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// flacReader reads values of any number of bits, most significant bit first
+type flacReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *flacReader) bits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.pos>>3 >= len(r.data) {
+			return 0, fmt.Errorf("unexpected end of data")
+		}
+		v = v<<1 | uint64(r.data[r.pos>>3]>>(7-r.pos&7)&1)
+		r.pos++
+	}
+	return v, nil
+}
+
+func (r *flacReader) signed(n int) (int32, error) {
+	v, err := r.bits(n)
+	if v&(1<<(n-1)) != 0 {
+		return int32(int64(v) - 1<<n), err
+	}
+	return int32(v), err
+}
+
+// flacStream is the result of decoding a FLAC file
+type flacStream struct {
+	hz       uint32
+	channels int
+	depth    int
+	total    uint64
+	md5      []byte
+
+	// the samples of each channel
+	samples [][]int32
+}
+
+// the coefficients of the fixed predictors of each order
+var flacFixedCoefs = [][]int32{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+// decodeFLAC decodes the subset of FLAC written by NewFLACEncoder
+func decodeFLAC(data []byte) (flacStream, error) {
+	var st flacStream
+	if len(data) < 42 || string(data[:4]) != "fLaC" {
+		return st, fmt.Errorf("no fLaC marker")
+	}
+	r := &flacReader{data: data, pos: 32}
+
+	last, _ := r.bits(1)
+	typ, _ := r.bits(7)
+	length, _ := r.bits(24)
+	if last != 1 || typ != 0 || length != 34 {
+		return st, fmt.Errorf("metadata block is not a single STREAMINFO block")
+	}
+	r.bits(16 + 16 + 24 + 24)
+	hz, _ := r.bits(20)
+	channels, _ := r.bits(3)
+	depth, _ := r.bits(5)
+	total, _ := r.bits(36)
+	st.hz = uint32(hz)
+	st.channels = int(channels) + 1
+	st.depth = int(depth) + 1
+	st.total = total
+	st.md5 = data[r.pos>>3 : r.pos>>3+16]
+	r.pos += 128
+
+	st.samples = make([][]int32, st.channels)
+	for frame := 0; r.pos>>3 < len(data); frame++ {
+		start := r.pos >> 3
+
+		sync, _ := r.bits(14)
+		if sync != 0x3ffe {
+			return st, fmt.Errorf("frame %d: no sync code", frame)
+		}
+		r.bits(2)
+		bsCode, _ := r.bits(4)
+		rateCode, _ := r.bits(4)
+		assignment, _ := r.bits(4)
+		r.bits(3 + 1)
+		if bsCode != 0x7 {
+			return st, fmt.Errorf("frame %d: unexpected block size code %x", frame, bsCode)
+		}
+
+		// the frame number is coded like a UTF-8 character
+		b, _ := r.bits(8)
+		for b&0x80 != 0 {
+			c, _ := r.bits(8)
+			if c&0xc0 != 0x80 {
+				break
+			}
+			b <<= 1
+		}
+		n, _ := r.bits(16)
+		blockSize := int(n) + 1
+		switch rateCode {
+		case 0xc:
+			r.bits(8)
+		case 0xd, 0xe:
+			r.bits(16)
+		}
+
+		crc, _ := r.bits(8)
+		if byte(crc) != flacCRC8(data[start:r.pos>>3-1]) {
+			return st, fmt.Errorf("frame %d: bad header checksum", frame)
+		}
+
+		sub := make([][]int32, st.channels)
+		for ch := range sub {
+			depth := st.depth
+			if assignment == 0x8 && ch == 1 {
+				depth++
+			}
+			s, err := decodeFLACSubframe(r, depth, blockSize)
+			if err != nil {
+				return st, fmt.Errorf("frame %d: channel %d: %w", frame, ch, err)
+			}
+			sub[ch] = s
+		}
+
+		// left and side stereo
+		if assignment == 0x8 {
+			for i := range sub[1] {
+				sub[1][i] = sub[0][i] - sub[1][i]
+			}
+		} else if int(assignment) != st.channels-1 {
+			return st, fmt.Errorf("frame %d: unexpected channel assignment %x", frame, assignment)
+		}
+
+		if r.pos&7 != 0 {
+			r.pos += 8 - r.pos&7
+		}
+		crc16, _ := r.bits(16)
+		if uint16(crc16) != flacCRC16(data[start:r.pos>>3-2]) {
+			return st, fmt.Errorf("frame %d: bad frame checksum", frame)
+		}
+
+		for ch := range sub {
+			st.samples[ch] = append(st.samples[ch], sub[ch]...)
+		}
+	}
+
+	return st, nil
+}
+
+func decodeFLACSubframe(r *flacReader, depth int, blockSize int) ([]int32, error) {
+	hdr, err := r.bits(8)
+	if err != nil {
+		return nil, err
+	}
+	if hdr&0x81 != 0 {
+		return nil, fmt.Errorf("padding or wasted bits are set")
+	}
+	t := int(hdr >> 1)
+
+	samples := make([]int32, 0, blockSize)
+	switch {
+	case t == 0:
+		v, err := r.signed(depth)
+		for i := 0; i < blockSize; i++ {
+			samples = append(samples, v)
+		}
+		return samples, err
+	case t == 1:
+		for i := 0; i < blockSize; i++ {
+			v, err := r.signed(depth)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, v)
+		}
+		return samples, nil
+	case t >= 8 && t <= 12, t >= 32:
+	default:
+		return nil, fmt.Errorf("unsupported subframe type %d", t)
+	}
+
+	order := t - 8
+	if t >= 32 {
+		order = t&0x1f + 1
+	}
+	for i := 0; i < order; i++ {
+		v, err := r.signed(depth)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, v)
+	}
+
+	var coefs []int32
+	var shift int32
+	if t >= 32 {
+		p, _ := r.bits(4)
+		prec := int(p) + 1
+		shift, _ = r.signed(5)
+		for i := 0; i < order; i++ {
+			c, err := r.signed(prec)
+			if err != nil {
+				return nil, err
+			}
+			coefs = append(coefs, c)
+		}
+	} else {
+		coefs = flacFixedCoefs[order]
+	}
+
+	method, _ := r.bits(2)
+	po, _ := r.bits(4)
+	if method != 0 {
+		return nil, fmt.Errorf("unsupported residual coding method %d", method)
+	}
+	for part := 0; part < 1<<po; part++ {
+		ct := blockSize >> po
+		if part == 0 {
+			ct -= order
+		}
+		k, _ := r.bits(4)
+		for i := 0; i < ct; i++ {
+			var q uint64
+			for {
+				b, err := r.bits(1)
+				if err != nil {
+					return nil, err
+				}
+				if b == 1 {
+					break
+				}
+				q++
+			}
+			low, err := r.bits(int(k))
+			if err != nil {
+				return nil, err
+			}
+			u := q<<k | low
+			res := int32(u >> 1)
+			if u&1 != 0 {
+				res = -res - 1
+			}
+
+			var sum int64
+			for j, c := range coefs {
+				sum += int64(c) * int64(samples[len(samples)-1-j])
+			}
+			samples = append(samples, int32(sum>>shift)+res)
+		}
+	}
+
+	return samples, nil
+}
+
+func TestFLAC(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{BitDepth: 16},
+		{Channels: 2, ChannelDelaySamples: 3},
+		{Channels: 2, BitDepth: 16, ChannelMode: ChannelLeft},
+		{ResampleTo: 48000, Dither: true},
+		{ResampleTo: 37800},
+	} {
+		var w bytes.Buffer
+		_, err := ConvertWithOptions(rom, &w, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var f bytes.Buffer
+		_, err = ConvertEncoder(rom, NewFLACEncoder(&f), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		st, err := decodeFLAC(f.Bytes())
+		if err != nil {
+			t.Fatalf("options %d: %v", i, err)
+		}
+
+		// the stream information describes the same samples as the WAV
+		format := OutputFormat(opts)
+		data := wavChunk(t, w.Bytes(), "data")
+		frames := len(data) / (format.Channels * format.BitDepth / 8)
+		if st.hz != format.SampleRate || st.channels != format.Channels || st.depth != format.BitDepth {
+			t.Errorf("options %d: stream information is %dHz, %d channels, %d bits", i, st.hz, st.channels, st.depth)
+		}
+		if st.total != uint64(frames) {
+			t.Errorf("options %d: stream information gives %d samples but there are %d", i, st.total, frames)
+		}
+
+		// the signature is of the signed little-endian samples
+		signed := append([]byte{}, data...)
+		if format.BitDepth == 8 {
+			for j := range signed {
+				signed[j] ^= 0x80
+			}
+		}
+		if sig := md5.Sum(signed); !bytes.Equal(st.md5, sig[:]) {
+			t.Errorf("options %d: MD5 signature is %x but should be %x", i, st.md5, sig)
+		}
+
+		// the decoded samples are the same as the samples of the WAV
+		if len(st.samples[0]) != frames {
+			t.Fatalf("options %d: decoded %d samples but there are %d", i, len(st.samples[0]), frames)
+		}
+		for j := 0; j < frames; j++ {
+			for ch := 0; ch < format.Channels; ch++ {
+				var v int32
+				if format.BitDepth == 16 {
+					v = int32(int16(binary.LittleEndian.Uint16(data[(j*format.Channels+ch)*2:])))
+				} else {
+					v = int32(data[j*format.Channels+ch]) - 128
+				}
+				if st.samples[ch][j] != v {
+					t.Fatalf("options %d: sample %d of channel %d is %d but should be %d", i, j, ch, st.samples[ch][j], v)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package supercharge
+
+import (
+	"fmt"
+	"io"
+)
+
+// Header describes the 8 byte header packet of a load. See BuildStream() for
+// the layout of the header packet
+type Header struct {
+	Address       uint16 `json:"address"`
+	BankConfig    byte   `json:"bank_config"`
+	BlockCount    int    `json:"block_count"`
+	Multiload     byte   `json:"multiload"`
+	ProgressSpeed uint16 `json:"progress_speed"`
+	Checksum      byte   `json:"checksum"`
+}
+
+// Bytes returns the header packet as it is written to tape
+func (h Header) Bytes() [8]byte {
+	return [8]byte{
+		byte(h.Address),
+		byte(h.Address >> 8),
+		h.BankConfig,
+		byte(h.BlockCount),
+		h.Checksum,
+		h.Multiload,
+		byte(h.ProgressSpeed),
+		byte(h.ProgressSpeed >> 8),
+	}
+}
+
+// newHeader returns the header for a ROM with the start address and number of
+// blocks. the RawHeader option replaces the header entirely
+func newHeader(address uint16, blockCount int, opts ConvertOptions) (Header, error) {
+	if opts.RawHeader != nil {
+		hdr := *opts.RawHeader
+		if opts.CheckRawHeader && sum(hdr[:]) != 0x55 {
+			return Header{}, fmt.Errorf("raw header: %w (sums to %02x)", BadChecksum, sum(hdr[:]))
+		}
+		return Header{
+			Address:       uint16(hdr[1])<<8 | uint16(hdr[0]),
+			BankConfig:    hdr[2],
+			BlockCount:    int(hdr[3]),
+			Checksum:      hdr[4],
+			Multiload:     hdr[5],
+			ProgressSpeed: uint16(hdr[7])<<8 | uint16(hdr[6]),
+		}, nil
+	}
+
+	h := Header{
+		Address:       address,
+		BankConfig:    opts.bankConfig(),
+		BlockCount:    blockCount,
+		Multiload:     opts.Multiload,
+		ProgressSpeed: opts.progressSpeed(blockCount),
+	}
+
+	// the progress speed is written low byte first. the checksum is
+	// calculated over the other seven bytes of the header
+	b := h.Bytes()
+	h.Checksum = PacketChecksum(0x55, []byte{b[0], b[1], b[2], b[3], b[5], b[6], b[7]})
+
+	return h, nil
+}
+
+// HeaderFromReaderAt returns the header that would be written by
+// ConvertWithOptions for the ROM in the io.ReaderAt. The size argument is the
+// length of the ROM in bytes
+//
+// The size of the ROM is checked before the data is read, so the data is only
+// read if it is small enough to be a valid ROM. The ROM is validated in the
+// same way as for a conversion
+func HeaderFromReaderAt(r io.ReaderAt, size int64, opts ConvertOptions) (Header, error) {
+	opts = opts.WithProfile()
+
+	if int64(opts.TrimTrailing) > size {
+		return Header{}, fmt.Errorf("trim: %d bytes is longer than the ROM (%d bytes)", opts.TrimTrailing, size)
+	}
+	size -= int64(opts.TrimTrailing)
+
+	if opts.TrimFooter {
+		for _, n := range footerSizes {
+			trimmed := size - int64(n)
+			if validateSize(int(trimmed)) == nil {
+				footer := make([]byte, n)
+				err := readAt(r, footer, trimmed)
+				if err != nil {
+					return Header{}, err
+				}
+				if isFooter(footer) {
+					size = trimmed
+					break
+				}
+			}
+		}
+	}
+
+	err := checkEncoding(r, size)
+	if err != nil {
+		return Header{}, err
+	}
+	err = validateSize(int(size))
+	if err != nil {
+		return Header{}, err
+	}
+
+	// the content of the ROM is checked for a blank image and for the reset
+	// vector, which is in the last four bytes of the ROM
+	rom := make([]byte, size)
+	err = readAt(r, rom, 0)
+	if err != nil {
+		return Header{}, err
+	}
+	err = validateContent(rom)
+	if err != nil {
+		return Header{}, err
+	}
+	address := uint16(rom[size-3])<<8 | uint16(rom[size-4])
+	blockCount := int(size / 256)
+
+	err = validateBankConfig(opts.bankConfig(), blockCount)
+	if err != nil {
+		return Header{}, err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return Header{}, err
+	}
+
+	return newHeader(address, blockCount, opts)
+}
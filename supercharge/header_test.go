@@ -0,0 +1,130 @@
+package supercharge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// eofReaderAt returns io.EOF with every read that reaches the end of the data,
+// as the io.ReaderAt interface allows
+type eofReaderAt struct {
+	data []byte
+}
+
+func (r eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if off+int64(n) == int64(len(r.data)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestHeaderFromReaderAt(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		hdr, err := HeaderFromReaderAt(bytes.NewReader(rom), int64(len(rom)), ConvertOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rep, err := BuildStream(rom, ConvertOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr != rep.Header {
+			t.Errorf("%d bytes: header is %+v but should be %+v", size, hdr, rep.Header)
+		}
+
+		// a read that ends at the end of the ROM can return io.EOF
+		hdr, err = HeaderFromReaderAt(eofReaderAt{data: rom}, int64(len(rom)), ConvertOptions{TrimFooter: true})
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if hdr != rep.Header {
+			t.Errorf("%d bytes: header is %+v but should be %+v", size, hdr, rep.Header)
+		}
+	}
+
+	// a short read is still an error
+	rom := testROM(4096)
+	_, err := HeaderFromReaderAt(eofReaderAt{data: rom[:4000]}, int64(len(rom)), ConvertOptions{})
+	if err != io.EOF {
+		t.Errorf("short ROM returned %v", err)
+	}
+
+	// the content is validated in the same way as for a conversion
+	blank := make([]byte, 4096)
+	_, err = HeaderFromReaderAt(bytes.NewReader(blank), int64(len(blank)), ConvertOptions{})
+	if !errors.Is(err, BlankImage) {
+		t.Errorf("blank ROM returned %v", err)
+	}
+
+	rom = testROM(4096)
+	rom[len(rom)-3] = 0x00
+	_, err = HeaderFromReaderAt(bytes.NewReader(rom), int64(len(rom)), ConvertOptions{})
+	if !errors.Is(err, BadStartAddress) {
+		t.Errorf("ROM with a bad start address returned %v", err)
+	}
+}
+
+func TestRawHeader(t *testing.T) {
+	rom := testROM(4096)
+
+	// a header with a different progress speed and multiload index. the
+	// checksum is correct
+	hdr := [8]byte{0x00, 0xf0, 0x1d, 0x10, 0x00, 0x07, 0x34, 0x12}
+	hdr[4] = PacketChecksum(0x55, hdr[:])
+	for _, check := range []bool{false, true} {
+		opts := ConvertOptions{RawHeader: &hdr, CheckRawHeader: check}
+		stream, rep, err := BuildStream(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stream[:8], hdr[:]) {
+			t.Errorf("stream starts with % 02x but the raw header is % 02x", stream[:8], hdr)
+		}
+		if rep.Header.Bytes() != hdr {
+			t.Errorf("report header is % 02x but the raw header is % 02x", rep.Header.Bytes(), hdr)
+		}
+
+		// the raw header is read from the tape in place of the header for
+		// the ROM
+		w := roundTrip(t, rom, opts)
+		vrep, err := VerifyWAV(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vrep.Header != hdr {
+			t.Errorf("header on the tape is % 02x but the raw header is % 02x", vrep.Header, hdr)
+		}
+	}
+
+	bad := hdr
+	bad[4]++
+
+	// without the check the bad header is written as it is and the tape
+	// can not be loaded
+	var b bytes.Buffer
+	_, err := ConvertWithOptions(rom, &b, ConvertOptions{RawHeader: &bad})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Decode(bytes.NewReader(b.Bytes()))
+	if !errors.Is(err, BadChecksum) {
+		t.Errorf("decoding a tape with a bad raw header: %v", err)
+	}
+
+	b.Reset()
+	_, err = ConvertWithOptions(rom, &b, ConvertOptions{RawHeader: &bad, CheckRawHeader: true})
+	if !errors.Is(err, BadChecksum) || !strings.Contains(err.Error(), "sums to 56") {
+		t.Errorf("error for a bad raw header is %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("%d bytes written for a bad raw header", b.Len())
+	}
+}
@@ -0,0 +1,50 @@
+package supercharge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HexDump returns a hex dump of the stream that would be created by
+// BuildStream for the ROM and options. Each section of the stream (the header,
+// each data packet and the parity packet of the AppendParity option) is
+// labelled. Each line of the dump shows the offset in
+// the stream followed by up to 16 bytes
+func HexDump(rom []byte, opts ConvertOptions) (string, error) {
+	stream, rep, err := BuildStream(rom, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var s strings.Builder
+	s.WriteString("header\n")
+	hexLines(&s, stream[:8], 0)
+	for i, b := range rep.Blocks {
+		p := 8 + i*258
+		s.WriteString(fmt.Sprintf("block %d (block number %02x, checksum %02x)\n", i, b.Page, b.Checksum))
+		hexLines(&s, stream[p:p+258], p)
+	}
+	if rep.Parity != nil {
+		p := 8 + len(rep.Blocks)*258
+		s.WriteString(fmt.Sprintf("parity (block number %02x, checksum %02x)\n", rep.Parity.Page, rep.Parity.Checksum))
+		hexLines(&s, stream[p:p+258], p)
+	}
+
+	return s.String(), nil
+}
+
+// hexLines writes the data as lines of 16 bytes. offset is the position of the
+// data in the stream
+func hexLines(s *strings.Builder, data []byte, offset int) {
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		s.WriteString(fmt.Sprintf("\t%04x:", offset+i))
+		for _, b := range data[i:end] {
+			s.WriteString(fmt.Sprintf(" %02x", b))
+		}
+		s.WriteString("\n")
+	}
+}
@@ -0,0 +1,76 @@
+package supercharge
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHexDump(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{},
+		{AppendParity: true},
+		{BlockOrder: []int{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}},
+	} {
+		dump, err := HexDump(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, rep, err := BuildStream(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the first line of the dump is the label of the header and the second
+		// line is the header bytes
+		lines := strings.Split(strings.TrimSuffix(dump, "\n"), "\n")
+		var hdr strings.Builder
+		hdr.WriteString("\t0000:")
+		for _, b := range rep.Header.Bytes() {
+			hdr.WriteString(fmt.Sprintf(" %02x", b))
+		}
+		if lines[0] != "header" || lines[1] != hdr.String() {
+			t.Errorf("options %d: dump does not start with the header: %q", i, lines[:2])
+		}
+
+		label := fmt.Sprintf("block 0 (block number %02x, checksum %02x)", rep.Blocks[0].Page, rep.Blocks[0].Checksum)
+		if lines[2] != label {
+			t.Errorf("options %d: label of block 0 is %q but should be %q", i, lines[2], label)
+		}
+
+		hasParity := strings.Contains(dump, fmt.Sprintf("parity (block number %02x,", parityBlockNumber))
+		if hasParity != opts.AppendParity {
+			t.Errorf("options %d: parity packet is labelled: %v", i, hasParity)
+		}
+
+		// the bytes of the dump are the bytes of the stream, at the offsets
+		// given on each line
+		var dumped []byte
+		for _, l := range lines {
+			if !strings.HasPrefix(l, "\t") {
+				continue
+			}
+			f := strings.Fields(l)
+			offset, err := strconv.ParseUint(strings.TrimSuffix(f[0], ":"), 16, 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(offset) != len(dumped) {
+				t.Fatalf("options %d: line at offset %04x follows %d bytes", i, offset, len(dumped))
+			}
+			for _, h := range f[1:] {
+				b, err := strconv.ParseUint(h, 16, 8)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dumped = append(dumped, byte(b))
+			}
+		}
+		if !bytes.Equal(dumped, stream) {
+			t.Errorf("options %d: bytes of the dump are different to the stream", i)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package supercharge
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// WriteID3 writes an ID3v2.4 tag containing the title and the comment. The tag
+// belongs at the start of an MP3 file, before the first MP3 frame. The text is
+// encoded as UTF-8
+//
+// The supercharge package does not create MP3 files. The tag is for programs
+// that pass the output of a conversion to an external MP3 encoder, such as the
+// raw samples written by ConvertToPlayer()
+func WriteID3(w io.Writer, title string, comment string) error {
+	var frames bytes.Buffer
+
+	// the title frame is the text encoding followed by the text
+	frames.Write(id3Frame("TIT2", append([]byte{id3UTF8}, title...)))
+
+	// the comment frame is the text encoding, a three letter language code, an
+	// empty description terminated by a zero byte and the text
+	body := append([]byte{id3UTF8}, "eng"...)
+	body = append(body, 0x00)
+	body = append(body, comment...)
+	frames.Write(id3Frame("COMM", body))
+
+	if frames.Len() >= 1<<28 {
+		return fmt.Errorf("id3: tag is too large (%d bytes)", frames.Len())
+	}
+
+	// the header is the identifier, version 4.0, no flags and the size of the
+	// frames
+	hdr := append([]byte("ID3"), 0x04, 0x00, 0x00)
+	hdr = append(hdr, synchsafe(frames.Len())...)
+
+	_, err := w.Write(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frames.Bytes())
+	return err
+}
+
+// the text encoding byte for UTF-8 in an ID3v2.4 frame
+const id3UTF8 = 0x03
+
+// id3Frame returns an ID3v2.4 frame with the identifier and the body. no frame
+// flags are set
+func id3Frame(id string, body []byte) []byte {
+	f := append([]byte(id), synchsafe(len(body))...)
+	f = append(f, 0x00, 0x00)
+	return append(f, body...)
+}
+
+// synchsafe returns the size as a four byte synchsafe integer. the top bit of
+// each byte is zero so the size can not be mistaken for an MP3 frame header
+func synchsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
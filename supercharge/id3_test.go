@@ -0,0 +1,52 @@
+package supercharge
+
+import (
+	"bytes"
+	"testing"
+)
+
+// readID3 returns the bodies of the frames in the ID3v2.4 tag at the start of
+// the data, indexed by frame identifier
+func readID3(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	if len(data) < 10 || string(data[0:3]) != "ID3" || data[3] != 4 {
+		t.Fatalf("no ID3v2.4 tag")
+	}
+	size := func(b []byte) int {
+		return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+	}
+	n := size(data[6:10])
+	if 10+n != len(data) {
+		t.Fatalf("tag size is %d but the data is %d bytes", n, len(data)-10)
+	}
+
+	frames := make(map[string][]byte)
+	for p := 10; p < len(data); {
+		l := size(data[p+4 : p+8])
+		frames[string(data[p:p+4])] = data[p+10 : p+10+l]
+		p += 10 + l
+	}
+	return frames
+}
+
+func TestWriteID3(t *testing.T) {
+	const title = "Suicide Mission ™"
+	const comment = "sha1:0123456789abcdef0123456789abcdef01234567"
+
+	var b bytes.Buffer
+	err := WriteID3(&b, title, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := readID3(t, b.Bytes())
+
+	tit2, ok := frames["TIT2"]
+	if !ok || tit2[0] != id3UTF8 || string(tit2[1:]) != title {
+		t.Errorf("title frame is % x", tit2)
+	}
+
+	comm, ok := frames["COMM"]
+	if !ok || comm[0] != id3UTF8 || string(comm[1:4]) != "eng" || comm[4] != 0x00 || string(comm[5:]) != comment {
+		t.Errorf("comment frame is % x", comm)
+	}
+}
@@ -0,0 +1,36 @@
+package supercharge
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// OutputRMS returns the RMS level, in dBFS, of the audio in a WAV file. A full
+// scale sine wave has an RMS level of about -3 dBFS. Only the first channel of
+// the audio is measured
+//
+// The result can be used to check that the volume of a conversion is as
+// intended before it is recorded to tape
+func OutputRMS(r io.Reader) (float64, error) {
+	p, err := readWAV(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(p.samples) == 0 {
+		return 0, fmt.Errorf("rms: no samples")
+	}
+
+	var sum float64
+	for _, s := range p.samples {
+		sum += s * s
+	}
+	rms := math.Sqrt(sum / float64(len(p.samples)))
+
+	// silence has no meaningful level in decibels
+	if rms == 0 {
+		return math.Inf(-1), nil
+	}
+
+	return 20 * math.Log10(rms), nil
+}
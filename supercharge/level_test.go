@@ -0,0 +1,88 @@
+package supercharge
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// sineWAV returns a WAV file of whole cycles of a sine wave with the amplitude.
+// the right channel of a stereo file is silent
+func sineWAV(t *testing.T, amplitude float64, channels int) []byte {
+	t.Helper()
+	var samples []float64
+	for i := 0; i < 44100; i++ {
+		samples = append(samples, amplitude*math.Sin(float64(i)*2*math.Pi/100))
+		if channels == 2 {
+			samples = append(samples, 0)
+		}
+	}
+
+	var b bytes.Buffer
+	enc := NewWAVEncoder(&b)
+	err := enc.WriteHeader(Format{SampleRate: 44100, Channels: channels, Float32: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteSamples(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestOutputRMS(t *testing.T) {
+	for _, tc := range []struct {
+		amplitude float64
+		channels  int
+		level     float64
+	}{
+		{1, 1, -3.0103},
+		{0.5, 1, -9.0309},
+		{0.1, 1, -23.0103},
+
+		// only the first channel is measured
+		{0.5, 2, -9.0309},
+	} {
+		level, err := OutputRMS(bytes.NewReader(sineWAV(t, tc.amplitude, tc.channels)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(level-tc.level) > 0.001 {
+			t.Errorf("level of a sine wave with amplitude %.2f is %.4f dBFS but should be %.4f", tc.amplitude, level, tc.level)
+		}
+	}
+
+	level, err := OutputRMS(bytes.NewReader(sineWAV(t, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(level, -1) {
+		t.Errorf("level of silence is %f dBFS", level)
+	}
+
+	// the tape is made of sine wave cycles so its level is the same as a sine
+	// wave with the amplitude of the volume. the default volume is 0.98
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		volume float64
+		level  float64
+	}{
+		{0, -3.1858},
+		{1, -3.0103},
+		{0.5, -9.0309},
+	} {
+		w := roundTrip(t, rom, ConvertOptions{Float32: true, Volume: tc.volume})
+		level, err := OutputRMS(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(level-tc.level) > 0.01 {
+			t.Errorf("level of a tape at volume %.2f is %.4f dBFS but should be %.4f", tc.volume, level, tc.level)
+		}
+	}
+}
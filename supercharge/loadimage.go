@@ -0,0 +1,165 @@
+package supercharge
+
+import (
+	"fmt"
+	"io"
+)
+
+// the layout of the 256 byte header block that follows the data of each load
+// in a Supercharger load image. the header packet is at the start of the block
+// and is followed by the block number and the checksum of each data packet
+const (
+	loadImageDataSize       = 8192
+	loadImageBlockNumbers   = 16
+	loadImageBlockChecksums = 64
+)
+
+// IsLoadImage returns true if the data is a Supercharger load image, such as an
+// .ar file or a multiload (.mlt) file. A load image is one or more loads, each of which is
+// 8448 bytes long, and the header packet of every load must have a valid
+// checksum
+func IsLoadImage(data []byte) bool {
+	if len(data) == 0 || len(data)%loadImageSize != 0 {
+		return false
+	}
+	for s := 0; s < len(data); s += loadImageSize {
+		if sum(data[s+loadImageDataSize:s+loadImageDataSize+8]) != 0x55 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadImageStream returns the stream for a single load of a load image. the
+// header, block numbers and checksums are taken from the load image as they are.
+// the checksum of each data packet must be correct
+func loadImageStream(img []byte) ([]byte, ConvertReport, error) {
+	hdr := img[loadImageDataSize : loadImageDataSize+8]
+	blockCount := int(hdr[3])
+	if blockCount == 0 || blockCount*256 > loadImageDataSize {
+		return nil, ConvertReport{}, fmt.Errorf("load image: %w (block count is %d)", NoBlocks, blockCount)
+	}
+
+	rep := ConvertReport{
+		Header: Header{
+			Address:       uint16(hdr[1])<<8 | uint16(hdr[0]),
+			BankConfig:    hdr[2],
+			BlockCount:    blockCount,
+			Checksum:      hdr[4],
+			Multiload:     hdr[5],
+			ProgressSpeed: uint16(hdr[7])<<8 | uint16(hdr[6]),
+		},
+	}
+
+	stream := append([]byte{}, hdr...)
+	for i := 0; i < blockCount; i++ {
+		page := img[loadImageDataSize+loadImageBlockNumbers+i]
+		checksum := img[loadImageDataSize+loadImageBlockChecksums+i]
+		data := img[i*256 : (i+1)*256]
+		if page+checksum+sum(data) != 0x55 {
+			return nil, ConvertReport{}, fmt.Errorf("load image: block %d: %w (block number %02x)", i, BadChecksum, page)
+		}
+		rep.Blocks = append(rep.Blocks, BlockReport{Page: page, Checksum: checksum})
+		stream = append(stream, page, checksum)
+		stream = append(stream, data...)
+	}
+
+	return stream, rep, nil
+}
+
+// ConvertLoadImage converts a Supercharger load image to a WAV. Each load in the
+// image is written in turn, in the same way as ConvertMultiload. The header and
+// the block numbers and checksums of the data packets are taken from the load
+// image without change, so the options that change the header or the data
+// packets have no effect
+//
+// The details of each load are returned in a ConvertReport, in the same order
+// as the loads
+func ConvertLoadImage(data []byte, w io.Writer, opts ConvertOptions) ([]ConvertReport, error) {
+	return ConvertLoadImageEncoder(data, NewWAVEncoder(w), opts)
+}
+
+// ConvertLoadImageEncoder is the same as ConvertLoadImage except that the
+// output is written by the Encoder. The Encoder is finalized if the conversion
+// succeeds
+func ConvertLoadImageEncoder(data []byte, enc Encoder, opts ConvertOptions) ([]ConvertReport, error) {
+	if !IsLoadImage(data) {
+		return nil, fmt.Errorf("load image: not a Supercharger load image (%d bytes)", len(data))
+	}
+
+	opts = opts.WithProfile()
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var streams [][]byte
+	var reps []ConvertReport
+	for s := 0; s < len(data); s += loadImageSize {
+		stream, rep, err := loadImageStream(data[s : s+loadImageSize])
+		if err != nil {
+			return nil, fmt.Errorf("load %d: %w", s/loadImageSize, err)
+		}
+		streams = append(streams, stream)
+		reps = append(reps, rep)
+	}
+
+	return convertStreams(streams, reps, enc, opts, newTones(opts))
+}
+
+// ConvertToAR packages the ROM as a Supercharger load image, such as an .ar
+// file, and writes it to w. The image is a single load in the format read by
+// ConvertLoadImage(). It can be used with emulators and flash cartridges that
+// load images instead of audio
+//
+// The header and the data packets are the same as those created by
+// BuildStream(), including the effect of any options that change them. A
+// parity packet added by the AppendParity option is not included because it is
+// not counted by the block count in the header
+func ConvertToAR(rom []byte, w io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	stream, rep, err := BuildStream(rom, opts)
+	if err != nil {
+		return ConvertReport{}, err
+	}
+	_, err = w.Write(loadImage(stream))
+	if err != nil {
+		return ConvertReport{}, err
+	}
+	return rep, nil
+}
+
+// loadImage returns the load image for a stream created by BuildStream(). the
+// data of each packet is placed in the order it appears in the stream
+func loadImage(stream []byte) []byte {
+	img := make([]byte, loadImageSize)
+	hdr := img[loadImageDataSize:]
+	copy(hdr, stream[:8])
+	for i := 0; i < int(stream[3]); i++ {
+		packet := stream[8+i*258 : 8+(i+1)*258]
+		hdr[loadImageBlockNumbers+i] = packet[0]
+		hdr[loadImageBlockChecksums+i] = packet[1]
+		copy(img[i*256:], packet[2:])
+	}
+	return img
+}
+
+// SplitLoads divides the data into loads of loadSize bytes each. The length of
+// the data must be a multiple of the load size. The loads share the same
+// underlying data as the data argument. Each load is checked with Validate()
+func SplitLoads(data []byte, loadSize int) ([][]byte, error) {
+	if loadSize <= 0 || len(data) == 0 || len(data)%loadSize != 0 {
+		return nil, fmt.Errorf("split loads: %d bytes is not a multiple of the load size (%d)", len(data), loadSize)
+	}
+
+	var loads [][]byte
+	for s := 0; s < len(data); s += loadSize {
+		l := data[s : s+loadSize]
+		err := Validate(l)
+		if err != nil {
+			return nil, fmt.Errorf("split loads: load %d: %w", s/loadSize, err)
+		}
+		loads = append(loads, l)
+	}
+
+	return loads, nil
+}
@@ -0,0 +1,178 @@
+package supercharge
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// measurement is the length of the output of a conversion
+type measurement struct {
+	// the number of frames at the rate of the output
+	frames int
+
+	// the number of cue points
+	cues int
+}
+
+// wavSize returns the number of bytes in the WAV file for the measurement
+func (m measurement) wavSize(opts ConvertOptions) int64 {
+	w := &wav{}
+	_ = w.WriteHeader(opts.format())
+	return w.sizeFor(m.frames*int(w.frameSize()), m.cues)
+}
+
+// frameCounter counts the frames that the generator would write, without
+// generating any samples. the methods follow the methods of the generator
+type frameCounter struct {
+	// the rate at which samples are generated
+	rate float64
+
+	// the lengths of the tone cycles
+	start   int
+	zeroBit int
+	oneBit  int
+
+	// the number of frames counted so far
+	frames int
+
+	// the state of the resampler. the same as the fields of the generator
+	resampleStep float64
+	resamplePos  float64
+}
+
+// generated counts the frames produced by samples at the generation rate. the
+// position of the resampler is advanced in exactly the same way as by the
+// generator, so that the count is the same
+func (c *frameCounter) generated(samples int) {
+	if c.resampleStep == 0 {
+		c.frames += samples
+		return
+	}
+	for i := 0; i < samples; i++ {
+		for c.resamplePos < 1 {
+			c.frames++
+			c.resamplePos += c.resampleStep
+		}
+		c.resamplePos--
+	}
+}
+
+// direct counts frames that are written at the rate of the output
+func (c *frameCounter) direct(frames int) {
+	c.frames += frames
+}
+
+// byteLength returns the number of samples in the tones for a single byte
+func (c *frameCounter) byteLength(b byte) int {
+	ones := bits.OnesCount8(b)
+	return ones*c.oneBit + (8-ones)*c.zeroBit
+}
+
+// writeByte counts the samples for a single byte. the same as the writeByte
+// function of bitPacker
+func (c *frameCounter) writeByte(b byte) {
+	c.generated(c.byteLength(b))
+}
+
+// byteDuration counts the samples written by the writeByteDuration function of
+// bitPacker. the function writes $55 bytes for the duration, whichever byte it
+// is given
+func (c *frameCounter) byteDuration(duration float64) {
+	bytesPerSecond := uint32(c.rate) / uint32(c.zeroBit+c.oneBit) / 4
+	ct := duration * float64(bytesPerSecond)
+	c.generated(int(ct) * c.byteLength(0x55))
+}
+
+// startTone counts the samples of the start tone for the duration
+func (c *frameCounter) startTone(duration float64) {
+	ct := duration * c.rate / float64(c.start)
+	c.generated(int(ct) * c.start)
+}
+
+// measureStreams returns the length of the output for loads that have already
+// been built into streams. the length is worked out from the lengths of the
+// tones and the content of the streams, in the same order as convertStreams()
+// and writeLoad(). no samples are generated
+func measureStreams(streams [][]byte, opts ConvertOptions, t tones) (measurement, error) {
+	var m measurement
+
+	c := frameCounter{
+		rate:    opts.generationRate(),
+		start:   len(t.start),
+		zeroBit: len(t.zeroBit),
+		oneBit:  len(t.oneBit),
+	}
+	hz := float64(opts.sampleRate())
+	if opts.ResampleTo > 0 {
+		c.resampleStep = c.rate / float64(opts.ResampleTo)
+		c.resamplePos = 1
+	}
+
+	for i, stream := range streams {
+		if i == 0 {
+			if opts.LabelBeeps > 0 {
+				beep := cycleLength(labelBeepCycle, c.rate)
+				ct := labelBeepSeconds * c.rate / float64(beep)
+				for n := 0; n < opts.LabelBeeps; n++ {
+					c.generated(int(ct) * beep)
+					c.generated(int(labelSilenceSeconds * c.rate))
+				}
+			}
+		} else if opts.MultiloadSilenceSeconds > 0 {
+			c.generated(int(math.Round(opts.MultiloadSilenceSeconds * c.rate)))
+		}
+		if opts.StageMarker {
+			c.generated(int(stageMarkerSilenceSeconds * c.rate))
+			c.startTone(stageMarkerToneSeconds)
+		}
+
+		// the load. see writeLoad() for details
+		c.startTone(toneSeconds(opts.StartToneSeconds, startToneSeconds))
+		c.byteDuration(toneSeconds(opts.HeaderToneSeconds, headerToneSeconds))
+		c.writeByte(0x54)
+		for i, b := range stream {
+			if i >= 8 && (i-8)%258 == 0 && opts.CueChunk {
+				m.cues++
+			}
+			c.writeByte(b)
+			if i == 7 && opts.PostHeaderSilenceSeconds > 0 {
+				c.generated(int(math.Round(opts.PostHeaderSilenceSeconds * c.rate)))
+			}
+		}
+		if opts.RepeatHeader {
+			c.byteDuration(toneSeconds(opts.HeaderToneSeconds, headerToneSeconds))
+			c.writeByte(0x54)
+			for _, b := range stream[:8] {
+				c.writeByte(b)
+			}
+		}
+		c.byteDuration(toneSeconds(opts.EndToneSeconds, endToneSeconds))
+	}
+
+	// the end of the output after the last load
+	c.direct(opts.ChannelDelaySamples)
+
+	silence := opts.TrailingSilenceSeconds
+	if opts.MaxTrailingSilenceSeconds > 0 && silence > opts.MaxTrailingSilenceSeconds {
+		silence = opts.MaxTrailingSilenceSeconds
+	}
+	c.direct(int(math.Round(silence * hz)))
+
+	if opts.AppendResyncMarker {
+		c.startTone(resyncToneSeconds)
+		c.byteDuration(resyncCalibrationSeconds)
+		c.direct(opts.ChannelDelaySamples)
+	}
+
+	if opts.PadToSeconds > 0 {
+		n := int(math.Round(opts.PadToSeconds * hz))
+		if c.frames > n {
+			return measurement{}, fmt.Errorf("pad to seconds: content is already %.3f seconds", float64(c.frames)/hz)
+		}
+		c.frames = n
+	}
+
+	m.frames = c.frames
+	return m, nil
+}
@@ -0,0 +1,737 @@
+package supercharge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rounding specifies how sample values are quantized to the integer values
+// stored in the output
+type Rounding int
+
+// List of valid Rounding values
+const (
+	// the fractional part of the sample value is discarded
+	RoundTruncate Rounding = iota
+
+	// the sample value is rounded to the nearest integer value
+	RoundNearest
+)
+
+// ChannelMode specifies which channels of a stereo output carry the tones
+type ChannelMode int
+
+// List of valid ChannelMode values
+const (
+	// the tones are written to both channels
+	ChannelBoth ChannelMode = iota
+
+	// the tones are written to the left channel only. the right channel is
+	// silent
+	ChannelLeft
+
+	// the tones are written to the right channel only. the left channel is
+	// silent
+	ChannelRight
+)
+
+// the names of the channel modes, as used by ParseChannelMode
+var channelModeNames = map[ChannelMode]string{
+	ChannelBoth:  "both",
+	ChannelLeft:  "left",
+	ChannelRight: "right",
+}
+
+func (m ChannelMode) String() string {
+	if n, ok := channelModeNames[m]; ok {
+		return n
+	}
+	return fmt.Sprintf("channel(%d)", int(m))
+}
+
+// ParseChannelMode returns the ChannelMode with the name. Names are the same
+// as those returned by the String() function, eg. "left"
+func ParseChannelMode(name string) (ChannelMode, error) {
+	for m, n := range channelModeNames {
+		if strings.EqualFold(n, name) {
+			return m, nil
+		}
+	}
+	return ChannelBoth, fmt.Errorf("unknown channel mode: %s", name)
+}
+
+// Waveform specifies the shape of each tone cycle
+type Waveform int
+
+// List of valid Waveform values
+const (
+	// each cycle is a sine wave
+	WaveformSine Waveform = iota
+
+	// each cycle is a square wave. the level changes at the zero crossings
+	// of the sine wave
+	WaveformSquare
+
+	// each cycle is a trapezoid. the level ramps between the peaks around
+	// the zero crossings of the sine wave and is flat in between
+	WaveformTrapezoid
+)
+
+// the names of the waveforms, as used by ParseWaveform
+var waveformNames = map[Waveform]string{
+	WaveformSine:      "sine",
+	WaveformSquare:    "square",
+	WaveformTrapezoid: "trapezoid",
+}
+
+func (w Waveform) String() string {
+	if n, ok := waveformNames[w]; ok {
+		return n
+	}
+	return fmt.Sprintf("waveform(%d)", int(w))
+}
+
+// ParseWaveform returns the Waveform with the name. Names are the same as
+// those returned by the String() function, eg. "square"
+func ParseWaveform(name string) (Waveform, error) {
+	for w, n := range waveformNames {
+		if strings.EqualFold(n, name) {
+			return w, nil
+		}
+	}
+	return WaveformSine, fmt.Errorf("unknown waveform: %s", name)
+}
+
+// ProgressPreset selects a progress bar speed suitable for the size of the ROM
+//
+// A larger progress bar speed moves the bars more slowly. This is why the
+// value recommended for a ROM grows with the number of blocks, so that the
+// bars meet as the last block is loaded
+type ProgressPreset int
+
+// List of valid ProgressPreset values
+//
+// The normal speed is the value recommended by the sctech.txt document for the
+// number of blocks in the ROM: $00B6 for 2K, $016D for 4K and $0224 for 6K.
+// Other sizes are in proportion to the 4K value. The slow speed is twice the
+// normal value, so the bars move at half the normal rate and are only half way
+// when the load finishes. The fast speed is half the normal value, so the bars
+// move at twice the normal rate and meet when half of the ROM has been loaded.
+// For a 4K ROM the speeds are:
+//
+//	ProgressSlow:   $02DA
+//	ProgressNormal: $016D
+//	ProgressFast:   $00B6
+const (
+	// no preset. the ProgressSpeed option is used
+	ProgressNone ProgressPreset = iota
+
+	ProgressSlow
+	ProgressNormal
+	ProgressFast
+)
+
+// ParseProgressSpeed parses a progress bar speed. The speed is either the name
+// of a preset (slow, normal or fast) or a 16 bit value written in hexadecimal,
+// with or without a $ or 0x prefix. One of the speed and the preset is
+// returned, the other is zero
+func ParseProgressSpeed(s string) (uint16, ProgressPreset, error) {
+	switch strings.ToLower(s) {
+	case "slow":
+		return 0, ProgressSlow, nil
+	case "normal":
+		return 0, ProgressNormal, nil
+	case "fast":
+		return 0, ProgressFast, nil
+	}
+	h := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	v, err := strconv.ParseUint(h, 16, 16)
+	if err != nil || v == 0 {
+		return 0, ProgressNone, fmt.Errorf("progress speed: not a preset or a non-zero hexadecimal word (%s)", s)
+	}
+	return uint16(v), ProgressNone, nil
+}
+
+// the normal progress speeds for the sizes given in sctech.txt, indexed by
+// block count
+var progressPresetSpeeds = map[int]uint16{
+	8:  0x00b6,
+	16: 0x016d,
+	24: 0x0224,
+}
+
+// ConvertOptions changes how a ROM is converted. The zero value for each field
+// leaves the conversion unchanged from the default behaviour
+type ConvertOptions struct {
+	// AmplitudeEnvelope scales the volume of each sample according to its
+	// position in the output, in seconds. Values returned by the function are
+	// clamped to the range 0 to 1. Scaling happens around the centre line of
+	// the waveform so the zero crossings of the tones are not affected
+	//
+	// If nil the volume is constant for the entirety of the output
+	AmplitudeEnvelope func(positionSeconds float64) float64
+
+	// BlockTransform is called for each 256 byte block of the ROM before it is
+	// packed. The data argument is a copy of the block and may be modified.
+	// The returned slice must also be 256 bytes long. The block checksum is
+	// computed over the transformed data
+	//
+	// If nil the blocks are packed unchanged
+	BlockTransform func(blockIndex int, data []byte) []byte
+
+	// PadToSeconds adds silence to the end of the output so that the total
+	// duration is the specified number of seconds. It is an error if the
+	// output is already longer than the duration requested
+	//
+	// If zero no padding is added
+	PadToSeconds float64
+
+	// Rounding controls how sample values are quantized. The default of
+	// RoundTruncate matches the output of earlier versions of the package
+	Rounding Rounding
+
+	// Channels is the number of audio channels in the output. It can be 1 or
+	// 2. The same data is written to both channels of a stereo output unless
+	// the ChannelMode option says otherwise
+	//
+	// If zero the output is mono
+	Channels int
+
+	// ChannelDelaySamples delays the second channel of stereo output by the
+	// specified number of samples. The start of the second channel is padded
+	// with silence and the end of the first channel is padded by the same
+	// amount
+	ChannelDelaySamples int
+
+	// ChannelMode selects which channels of a stereo output carry the tones.
+	// A single channel can be used with playback equipment that only reads
+	// one channel, and leaves the other channel free for a different signal
+	//
+	// The default of ChannelBoth writes the tones to both channels. Any other
+	// mode requires stereo output
+	ChannelMode ChannelMode
+
+	// BankConfig is the bank configuration byte written to the header. It
+	// controls which banks of Supercharger RAM are mapped into memory when
+	// the game starts and must be suitable for the size of the ROM
+	//
+	// If zero the bank configuration $1D is used. The configuration $00 can
+	// not be chosen but it maps the BIOS ROM where RAM is needed and is not
+	// suitable for any ROM
+	BankConfig byte
+
+	// RepeatHeader writes a second copy of the header packet, preceded by
+	// its own calibration tone and synchronisation byte, after the last data
+	// packet. A loader that failed to read the first header can use the
+	// second copy
+	RepeatHeader bool
+
+	// Multiload is the multiload index written to the header. It should be
+	// zero for the first or only load of a game. For ConvertMultiload() it is
+	// the index of the first load
+	Multiload byte
+
+	// Dither adds triangular (TPDF) dither to each sample before it is
+	// quantized. This reduces the harmonic distortion caused by quantization
+	// at the cost of a small amount of noise. The dither is generated from
+	// the Seed option so the output is the same for every conversion with
+	// the same options
+	//
+	// The dither is the size of a single step at the BitDepth of the output.
+	// Float32 output is not quantized and no dither is added
+	Dither bool
+
+	// NoDither prevents a device profile from adding dither. It can not be
+	// used with the Dither option
+	NoDither bool
+
+	// FastLoad sets the progress bar speed in the header to its maximum value
+	// of $FFFF. This is for games that have no need of the progress bars.
+	// Because larger values move the bars more slowly, the bars will barely
+	// move while the game is loading
+	FastLoad bool
+
+	// ProgressSpeed is the progress bar speed written to the header. Larger
+	// values move the bars more slowly. The value that suits a ROM is
+	// proportional to its size. The sctech.txt document gives $00B6 for 2K,
+	// $016D for 4K and $0224 for 6K. It can not be used with the FastLoad or
+	// ProgressPreset options
+	//
+	// If zero the speed is the same as the ProgressNormal preset, which is
+	// the sctech.txt value for the number of blocks in the ROM
+	ProgressSpeed uint16
+
+	// ProgressPreset chooses the progress bar speed from the size of the ROM.
+	// See the ProgressPreset type for the values used. It can not be used with
+	// the FastLoad or ProgressSpeed options
+	ProgressPreset ProgressPreset
+
+	// CueChunk adds a RIFF cue chunk to the WAV file with a cue point at the
+	// start of each data packet. This allows audio editors to jump straight
+	// to each block. AIFF files have a marker chunk instead
+	CueChunk bool
+
+	// RawHeader is written to the output as the header packet in place of
+	// the header that would otherwise be created for the ROM. The bytes are
+	// written verbatim and the checksum is not recomputed. The data packets
+	// are created in the normal way
+	//
+	// If nil the header is created from the ROM and the other options
+	RawHeader *[8]byte
+
+	// CheckRawHeader causes the conversion to fail if the bytes of RawHeader
+	// do not sum to $55. Without this a header with a bad checksum is
+	// written as is
+	CheckRawHeader bool
+
+	// ResampleTo is the sample rate of the output in Hz. The tones are
+	// generated at the normal rate of 44100Hz, where the length of each tone
+	// cycle is a whole number of samples, and then resampled to the requested
+	// rate by linear interpolation. The frequencies of the tones and the
+	// duration of the output are unchanged
+	//
+	// If zero the output is not resampled
+	ResampleTo uint32
+
+	// StageMarker writes a marker before each load. The marker is one second
+	// of silence followed by half a second of the start tone. The marker
+	// allows DecodeMultiload() to separate the loads of a multiload game
+	// without relying on the multiload index of each load
+	StageMarker bool
+
+	// Float32 writes WAV output with 32 bit floating point samples in the
+	// range -1 to +1, instead of 8 bit integer samples. The Rounding option
+	// has no effect on floating point samples
+	Float32 bool
+
+	// WAVExtensible writes the fmt chunk of WAV output in the
+	// WAVE_FORMAT_EXTENSIBLE layout, with the valid bits per sample, the
+	// speaker positions of the channels and the sample format. Some strict
+	// players expect the extensible layout for anything other than 8 or 16 bit
+	// PCM, such as the output of the Float32 option
+	//
+	// The samples are the same whichever layout is used
+	WAVExtensible bool
+
+	// SampleRate is the rate in Hz at which the tones are generated. The
+	// length of each tone cycle is scaled to the rate and rounded to a whole
+	// number of samples, so the tone frequencies reported by
+	// ToneFrequencies() may differ slightly from those at the normal rate.
+	// The rate must be between 8000Hz and 192000Hz and high enough for the
+	// zero bit tone
+	//
+	// If zero the tones are generated at 44100Hz. The ResampleTo option
+	// resamples the generated tones to a different output rate
+	SampleRate uint32
+
+	// BitDepth is the number of bits in each integer sample of the output.
+	// It can be 8 (unsigned samples) or 16 (signed little-endian samples).
+	// A depth of 16 can not be used with the Float32 option
+	//
+	// If zero the samples are 8 bit
+	BitDepth int
+
+	// StartToneSeconds, HeaderToneSeconds and EndToneSeconds are the lengths
+	// of the start tone, the calibration tone before the header and the zero
+	// bytes after the last packet of each load. If zero the lengths used by
+	// makewav are used: 0.1, 0.5 and 0.5 seconds respectively
+	StartToneSeconds  float64
+	HeaderToneSeconds float64
+	EndToneSeconds    float64
+
+	// Volume is the peak level of the start tone and the bit tones in the
+	// range 0 to 1. If zero the volume used by makewav (0.98) is used
+	Volume float64
+
+	// StartToneVolume, ZeroToneVolume and OneToneVolume override the Volume
+	// option for the start tone, the tone of zero bits and the tone of one
+	// bits. Each is in the range 0 to 1. If zero the Volume option is used
+	StartToneVolume float64
+	ZeroToneVolume  float64
+	OneToneVolume   float64
+
+	// Progress is called after each data packet has been written to the
+	// output. The block argument is the number of data packets written so
+	// far and total is the number of data packets in the entire output,
+	// including every load of a multiload conversion
+	//
+	// If nil no progress is reported
+	Progress func(block int, total int)
+
+	// Context allows a conversion to be cancelled. The context is checked
+	// after each data packet. If the context is done the conversion stops
+	// and the error of the context is returned. Output that has already
+	// been written is truncated and is not a valid WAV file
+	//
+	// If nil the conversion can not be cancelled
+	Context context.Context
+
+	// TrailingSilenceSeconds adds silence to the end of the output, after
+	// the last load. The silence is added before any padding requested by
+	// PadToSeconds. A warning is added to the ConvertReport if the silence is
+	// longer than one minute
+	TrailingSilenceSeconds float64
+
+	// MaxTrailingSilenceSeconds is the longest trailing silence that will be
+	// added. Longer silences are shortened to this length and a warning is
+	// added to the ConvertReport
+	//
+	// If zero the trailing silence is not limited
+	MaxTrailingSilenceSeconds float64
+
+	// MaxOutputBytes is the largest WAV file, in bytes, that a conversion
+	// may produce. The size is measured with EstimateSize() before any
+	// output is written. The OutputTooLarge error is returned if the WAV would
+	// be larger. The option only applies to WAV output
+	//
+	// If zero the size of the output is not limited
+	MaxOutputBytes int64
+
+	// PostHeaderSilenceSeconds adds silence between the header packet and the
+	// first data packet. Some hardware needs a short gap after the header
+	//
+	// If zero the first data packet immediately follows the header packet
+	PostHeaderSilenceSeconds float64
+
+	// PhaseOffset is the position in the waveform of the first sample of
+	// every tone cycle, as a fraction of a cycle. A value of 0.25 starts each
+	// cycle at the peak of the waveform and a value of 0.5 starts each cycle
+	// at the falling zero crossing. It must be at least zero and less than one
+	//
+	// If zero each cycle starts at the rising zero crossing
+	PhaseOffset float64
+
+	// Waveform is the shape of each cycle of the start tone and the bit
+	// tones. Some sound cards and cheap DACs reproduce square waves more
+	// reliably than sine waves at these frequencies. The zero crossings are
+	// the same for every waveform
+	//
+	// The default of WaveformSine generates each cycle as a sine wave
+	Waveform Waveform
+
+	// BandLimited removes the harmonics of the square and trapezoid waveforms
+	// that are above the Nyquist frequency. At the short cycle lengths of the
+	// bit tones these harmonics alias and add noise to the output. The cycles
+	// are built from the remaining harmonics and scaled so that the peak is the
+	// volume of the tone
+	//
+	// A sampled sine wave has no harmonics so the option has no effect on
+	// WaveformSine
+	BandLimited bool
+
+	// TrimTrailing is the number of bytes to remove from the end of the ROM
+	// before it is validated and converted. Some ROM dumps have a short
+	// footer after the game data
+	TrimTrailing int
+
+	// TrimFooter removes a recognised footer from the end of the ROM before
+	// it is validated and converted. See TrimROM() for the footers that are
+	// recognised. It is applied after TrimTrailing
+	TrimFooter bool
+
+	// BlockOrder is the order in which the blocks of the ROM are written to
+	// the output. Each entry is the index of a 256 byte block in the ROM and
+	// every block must appear exactly once. The block number in each packet
+	// still identifies where the block is loaded, so the order is not
+	// important to the Supercharger. The Blocks field of the ConvertReport is
+	// in the order the blocks are written
+	//
+	// If nil the blocks are written in the order they appear in the ROM
+	BlockOrder []int
+
+	// AppendResyncMarker writes a marker at the end of the output, after
+	// any trailing silence but before any padding requested by PadToSeconds.
+	// The marker is a quarter of a second of the start tone followed by a
+	// quarter of a second of $55 calibration bytes. It is not followed by a
+	// synchronisation byte so it is not mistaken for another load. The marker
+	// helps the Supercharger to settle before the start of the next file when
+	// several files are played one after the other
+	AppendResyncMarker bool
+
+	// Seed is the seed for the random numbers used by options such as Dither.
+	// Conversions with the same options and the same seed produce identical
+	// output. The seed is included in the ConvertReport
+	Seed int64
+
+	// RandomSeed replaces the Seed option with a seed taken from the current
+	// time. The output of conversions with random options will be different
+	// each time
+	RandomSeed bool
+
+	// LabelBeeps is the number of short beeps written at the very start of
+	// the output, before the start tone of the first load. Each beep is a
+	// tenth of a second long and is followed by a tenth of a second of
+	// silence. The beeps are not data and are ignored by the Supercharger.
+	// They help to identify a tape by ear, for example by giving each tape in
+	// a collection a different number of beeps
+	LabelBeeps int
+
+	// ByteSwapBlocks swaps each pair of adjacent bytes in every 256 byte
+	// block before the block is packed. It is applied after BlockTransform
+	// and the block checksum is computed over the swapped data
+	//
+	// This is not part of the Supercharger tape format. A stock Supercharger
+	// will load the swapped data as it is and the game will not run. The
+	// option is intended for experimental firmware that expects the swapped
+	// byte order. DecodeWithOptions with the same option recovers the
+	// original ROM
+	ByteSwapBlocks bool
+
+	// AppendParity adds a parity packet after the last data packet. The data
+	// of the parity packet is the exclusive-or of the data of every data
+	// packet, as written to tape. Any one damaged block can be recovered by
+	// an exclusive-or of the parity data with all the other blocks
+	//
+	// The parity packet has the same layout as a data packet. Its block
+	// number is $1F, which refers to the ROM bank and so can not be the block
+	// number of a data packet. The block count in the header does not include
+	// the parity packet, so a stock Supercharger stops loading before the
+	// parity packet and ignores it. Only a modified loader will use it
+	AppendParity bool
+
+	// DeviceProfile sets several options to values that suit a type of
+	// playback device. See the DeviceProfile type for the options set by each
+	// profile. Options that are set in the ConvertOptions take precedence
+	// over the profile. Options that are on or off can only be turned on by
+	// a profile
+	DeviceProfile DeviceProfile
+
+	// SelfCheck decodes the tones of each packet as the output is created
+	// and compares the decoded bytes with the packet. The conversion fails
+	// with the SelfCheckFailed error as soon as a packet does not match. The
+	// check is made on the tones before any resampling, amplitude envelope or
+	// dither is applied. This makes the conversion much slower
+	SelfCheck bool
+
+	// MultiloadSilenceSeconds is the length of the silence written before
+	// each load of a multiload conversion, other than the first. The silence
+	// gives the player time to respond to the prompt for the next load. It
+	// is written before any stage marker
+	MultiloadSilenceSeconds float64
+}
+
+// Default returns the ConvertOptions used by Convert
+func Default() ConvertOptions {
+	return ConvertOptions{}
+}
+
+// validate returns an error if the options can not be used for a conversion
+func (opts ConvertOptions) validate() error {
+	if opts.Channels < 0 || opts.Channels > 2 {
+		return fmt.Errorf("options: unsupported number of channels (%d)", opts.Channels)
+	}
+	if opts.ChannelDelaySamples < 0 {
+		return fmt.Errorf("options: channel delay can not be negative")
+	}
+	if opts.ChannelDelaySamples > 0 && opts.Channels != 2 {
+		return fmt.Errorf("options: channel delay requires stereo output")
+	}
+	if _, ok := waveformNames[opts.Waveform]; !ok {
+		return fmt.Errorf("options: unknown waveform (%d)", opts.Waveform)
+	}
+	if _, ok := channelModeNames[opts.ChannelMode]; !ok {
+		return fmt.Errorf("options: unknown channel mode (%d)", opts.ChannelMode)
+	}
+	if opts.ChannelMode != ChannelBoth && opts.Channels != 2 {
+		return fmt.Errorf("options: channel mode %s requires stereo output", opts.ChannelMode)
+	}
+	if opts.TrimTrailing < 0 {
+		return fmt.Errorf("options: trim trailing can not be negative")
+	}
+	if opts.PhaseOffset < 0 || opts.PhaseOffset >= 1 {
+		return fmt.Errorf("options: phase offset must be at least zero and less than one")
+	}
+	if opts.PostHeaderSilenceSeconds < 0 {
+		return fmt.Errorf("options: post header silence can not be negative")
+	}
+	if opts.StageMarker && opts.PostHeaderSilenceSeconds >= stageMarkerMinimumSeconds {
+		return fmt.Errorf("options: post header silence is too long to use with stage markers")
+	}
+	if opts.MultiloadSilenceSeconds < 0 {
+		return fmt.Errorf("options: multiload silence can not be negative")
+	}
+	if opts.LabelBeeps < 0 {
+		return fmt.Errorf("options: label beeps can not be negative")
+	}
+	if opts.MaxOutputBytes < 0 {
+		return fmt.Errorf("options: maximum output size can not be negative")
+	}
+	if opts.TrailingSilenceSeconds < 0 || opts.MaxTrailingSilenceSeconds < 0 {
+		return fmt.Errorf("options: trailing silence can not be negative")
+	}
+	if opts.Dither && opts.NoDither {
+		return fmt.Errorf("options: dither can not be used with no dither")
+	}
+	if opts.ProgressSpeed != 0 && opts.FastLoad {
+		return fmt.Errorf("options: progress speed can not be used with fast load")
+	}
+	if opts.ProgressPreset != ProgressNone && (opts.ProgressSpeed != 0 || opts.FastLoad) {
+		return fmt.Errorf("options: progress preset can not be used with progress speed or fast load")
+	}
+	if opts.ProgressPreset < ProgressNone || opts.ProgressPreset > ProgressFast {
+		return fmt.Errorf("options: unknown progress preset (%d)", opts.ProgressPreset)
+	}
+	if opts.DeviceProfile < ProfileNone || opts.DeviceProfile > ProfileEmulator {
+		return fmt.Errorf("options: unknown device profile (%d)", opts.DeviceProfile)
+	}
+	if opts.SampleRate != 0 && (opts.SampleRate < minSampleRate || opts.SampleRate > maxSampleRate) {
+		return fmt.Errorf("options: sample rate must be between %dHz and %dHz (%d)", minSampleRate, maxSampleRate, opts.SampleRate)
+	}
+	if opts.SampleRate != 0 && float64(opts.SampleRate) < 2*sampleRate/zeroToneCycle {
+		return fmt.Errorf("options: sample rate is too low for the bit tones (%d)", opts.SampleRate)
+	}
+	if opts.ResampleTo > 0 && float64(opts.ResampleTo) < 2*opts.generationRate()/float64(opts.cycle(zeroToneCycle)) {
+		return fmt.Errorf("options: resample rate is too low for the bit tones (%d)", opts.ResampleTo)
+	}
+	if opts.BitDepth != 0 && opts.BitDepth != 8 && opts.BitDepth != 16 {
+		return fmt.Errorf("options: bit depth must be 8 or 16 (%d)", opts.BitDepth)
+	}
+	if opts.BitDepth == 16 && opts.Float32 {
+		return fmt.Errorf("options: bit depth of 16 can not be used with floating point samples")
+	}
+	if opts.StartToneSeconds < 0 || opts.HeaderToneSeconds < 0 || opts.EndToneSeconds < 0 {
+		return fmt.Errorf("options: tone lengths can not be negative")
+	}
+	if opts.Volume < 0 || opts.Volume > 1 {
+		return fmt.Errorf("options: volume must be between 0 and 1 (%.2f)", opts.Volume)
+	}
+	for _, v := range []float64{opts.StartToneVolume, opts.ZeroToneVolume, opts.OneToneVolume} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("options: tone volume must be between 0 and 1 (%.2f)", v)
+		}
+	}
+	return nil
+}
+
+// bankConfig returns the bank configuration byte for the options
+func (opts ConvertOptions) bankConfig() byte {
+	if opts.BankConfig == 0 {
+		return defaultBankConfig
+	}
+	return opts.BankConfig
+}
+
+// progressSpeed returns the progress bar speed for the options and the number
+// of blocks in the ROM
+func (opts ConvertOptions) progressSpeed(blockCount int) uint16 {
+	if opts.FastLoad {
+		return 0xffff
+	}
+	if opts.ProgressSpeed != 0 {
+		return opts.ProgressSpeed
+	}
+
+	// the normal speed is used if there is no preset
+	speed, ok := progressPresetSpeeds[blockCount]
+	if !ok {
+		speed = uint16(int(progressPresetSpeeds[16]) * blockCount / 16)
+	}
+	switch opts.ProgressPreset {
+	case ProgressSlow:
+		return speed * 2
+	case ProgressFast:
+		return speed / 2
+	}
+	return speed
+}
+
+// channels returns the number of output channels for the options
+func (opts ConvertOptions) channels() int {
+	if opts.Channels == 0 {
+		return 1
+	}
+	return opts.Channels
+}
+
+// sampleRate returns the sample rate of the output for the options
+func (opts ConvertOptions) sampleRate() uint32 {
+	if opts.ResampleTo == 0 {
+		return uint32(opts.generationRate())
+	}
+	return opts.ResampleTo
+}
+
+// format returns the Format of the output for the options
+func (opts ConvertOptions) format() Format {
+	return Format{
+		SampleRate: opts.sampleRate(),
+		Channels:   opts.channels(),
+		Rounding:   opts.Rounding,
+		Float32:    opts.Float32,
+		Extensible: opts.WAVExtensible,
+		BitDepth:   opts.bitDepth(),
+	}
+}
+
+// generationRate returns the rate at which the tones are generated
+func (opts ConvertOptions) generationRate() float64 {
+	if opts.SampleRate == 0 {
+		return sampleRate
+	}
+	return float64(opts.SampleRate)
+}
+
+// cycle returns the length in samples of a tone cycle at the generation rate.
+// the length is given for the normal rate of 44100Hz
+func (opts ConvertOptions) cycle(length int) int {
+	return cycleLength(length, opts.generationRate())
+}
+
+// bitDepth returns the number of bits in each integer sample
+func (opts ConvertOptions) bitDepth() int {
+	if opts.BitDepth == 0 {
+		return 8
+	}
+	return opts.BitDepth
+}
+
+// ditherStep returns the size of a single step of the quantized output, which
+// is the size of the dither. floating point output is not quantized and has no
+// dither
+func (opts ConvertOptions) ditherStep() float64 {
+	if !opts.Dither || opts.Float32 {
+		return 0
+	}
+	if opts.bitDepth() == 16 {
+		return 1.0 / 32768
+	}
+	return 1.0 / 128
+}
+
+// toneSeconds returns the value if it is not zero and the default otherwise
+func toneSeconds(value float64, def float64) float64 {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+// toneVolume returns the volume of a tone for the options. the override is the
+// volume option for the tone
+func (opts ConvertOptions) toneVolume(def float64, override float64) float64 {
+	if override != 0 {
+		return override
+	}
+	if opts.Volume == 0 {
+		return def
+	}
+	return opts.Volume
+}
+
+// seed returns the seed for random numbers
+func (opts ConvertOptions) seed() int64 {
+	if opts.RandomSeed {
+		return time.Now().UnixNano()
+	}
+	return opts.Seed
+}
+
+// cacheable returns true if the options can be used as part of a Cache key.
+// options that contain functions can not be compared and are not cacheable.
+// a random seed is different for every conversion
+func (opts ConvertOptions) cacheable() bool {
+	return opts.AmplitudeEnvelope == nil && opts.BlockTransform == nil && opts.Progress == nil && opts.Context == nil && !opts.RandomSeed
+}
@@ -0,0 +1,51 @@
+package supercharge
+
+import (
+	"testing"
+)
+
+func TestToneVolume(t *testing.T) {
+	rom := testROM(2048)
+	for i, tc := range []struct {
+		opts ConvertOptions
+
+		// the expected volume of the start, zero bit and one bit tones
+		start float64
+		zero  float64
+		one   float64
+	}{
+		{ConvertOptions{}, 0.98, 0.98, 0.98},
+		{ConvertOptions{Volume: 0.5}, 0.5, 0.5, 0.5},
+		{ConvertOptions{StartToneVolume: 0.3}, 0.3, 0.98, 0.98},
+		{ConvertOptions{ZeroToneVolume: 0.4, OneToneVolume: 0.6}, 0.98, 0.4, 0.6},
+		{ConvertOptions{Volume: 0.5, OneToneVolume: 0.9}, 0.5, 0.5, 0.9},
+		{ConvertOptions{Volume: 0.25, StartToneVolume: 1, ZeroToneVolume: 0.7, OneToneVolume: 0.8}, 1, 0.7, 0.8},
+	} {
+		opts := tc.opts
+		tn := newTones(opts)
+		for _, v := range []struct {
+			name     string
+			samples  []float64
+			cycle    int
+			expected float64
+		}{
+			{"start", tn.start, startToneCycle, tc.start},
+			{"zero bit", tn.zeroBit, zeroToneCycle, tc.zero},
+			{"one bit", tn.oneBit, oneToneCycle, tc.one},
+		} {
+			expected := tone(opts.cycle(v.cycle), v.expected, opts.PhaseOffset, opts.Waveform)
+			if len(v.samples) != len(expected) {
+				t.Fatalf("case %d: %s tone is %d samples", i, v.name, len(v.samples))
+			}
+			for j := range expected {
+				if v.samples[j] != expected[j] {
+					t.Errorf("case %d: %s tone does not have a volume of %.2f", i, v.name, v.expected)
+					break
+				}
+			}
+		}
+
+		// the output at each volume can be loaded
+		roundTrip(t, rom, opts)
+	}
+}
@@ -0,0 +1,96 @@
+package supercharge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeviceProfile selects a set of options suited to the device that will play
+// the output
+type DeviceProfile int
+
+// List of valid DeviceProfile values
+//
+// The options set by each profile are:
+//
+//	ProfileModernSoundcard: ResampleTo 48000, Rounding RoundNearest, Dither (unless NoDither)
+//	ProfileVintageDeck:     RepeatHeader, Rounding RoundNearest, TrailingSilenceSeconds 3
+//	ProfileEmulator:        FastLoad
+const (
+	// no profile. the options are used as they are
+	ProfileNone DeviceProfile = iota
+
+	// sound cards that play at 48000Hz. the output is resampled so that the
+	// sound card does not need to
+	ProfileModernSoundcard
+
+	// recording to cassette with an old tape deck. the repeated header helps
+	// with tapes that are slow to settle
+	ProfileVintageDeck
+
+	// emulators that load from a WAV file. there is no need for the progress
+	// bars
+	ProfileEmulator
+)
+
+// the names of the profiles, as used by ParseDeviceProfile
+var profileNames = map[DeviceProfile]string{
+	ProfileNone:            "none",
+	ProfileModernSoundcard: "modern-soundcard",
+	ProfileVintageDeck:     "vintage-deck",
+	ProfileEmulator:        "emulator",
+}
+
+func (p DeviceProfile) String() string {
+	if n, ok := profileNames[p]; ok {
+		return n
+	}
+	return fmt.Sprintf("profile(%d)", int(p))
+}
+
+// ParseDeviceProfile returns the DeviceProfile with the name. Names are the
+// same as those returned by the String() function, eg. "vintage-deck"
+func ParseDeviceProfile(name string) (DeviceProfile, error) {
+	for p, n := range profileNames {
+		if strings.EqualFold(n, name) {
+			return p, nil
+		}
+	}
+	return ProfileNone, fmt.Errorf("unknown device profile: %s", name)
+}
+
+// WithProfile returns the options with the settings of the device profile
+// applied. Settings are only applied to fields that have their zero value so
+// any field set by the caller takes precedence over the profile. The Dither
+// setting is not applied if the NoDither field is set
+//
+// The conversion functions apply the profile themselves. WithProfile is useful
+// to find the options that a conversion will use, eg. the sample rate
+func (opts ConvertOptions) WithProfile() ConvertOptions {
+	switch opts.DeviceProfile {
+	case ProfileModernSoundcard:
+		if opts.ResampleTo == 0 {
+			opts.ResampleTo = 48000
+		}
+		if opts.Rounding == RoundTruncate {
+			opts.Rounding = RoundNearest
+		}
+		if !opts.NoDither {
+			opts.Dither = true
+		}
+	case ProfileVintageDeck:
+		opts.RepeatHeader = true
+		if opts.Rounding == RoundTruncate {
+			opts.Rounding = RoundNearest
+		}
+		if opts.TrailingSilenceSeconds == 0 {
+			opts.TrailingSilenceSeconds = 3
+		}
+	case ProfileEmulator:
+		// fast load can not be combined with the other progress options
+		if opts.ProgressSpeed == 0 && opts.ProgressPreset == ProgressNone {
+			opts.FastLoad = true
+		}
+	}
+	return opts
+}
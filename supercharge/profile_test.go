@@ -0,0 +1,123 @@
+package supercharge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestNoDither(t *testing.T) {
+	rom := testROM(4096)
+
+	var profile bytes.Buffer
+	_, err := ConvertWithOptions(rom, &profile, ConvertOptions{DeviceProfile: ProfileModernSoundcard, NoDither: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the profile without the dither
+	var b bytes.Buffer
+	_, err = ConvertWithOptions(rom, &b, ConvertOptions{ResampleTo: 48000, Rounding: RoundNearest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(profile.Bytes(), b.Bytes()) {
+		t.Errorf("output of the profile with no dither is different to output without dither")
+	}
+
+	opts := ConvertOptions{DeviceProfile: ProfileModernSoundcard}.WithProfile()
+	if !opts.Dither || opts.ResampleTo != 48000 {
+		t.Errorf("profile does not set dither and the sample rate")
+	}
+
+	err = ConvertOptions{Dither: true, NoDither: true}.validate()
+	if err == nil {
+		t.Errorf("dither and no dither are accepted together")
+	}
+}
+
+func TestDeviceProfiles(t *testing.T) {
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		profile DeviceProfile
+		rate    uint32
+		repeat  bool
+	}{
+		{ProfileNone, 44100, false},
+		{ProfileModernSoundcard, 48000, false},
+		{ProfileVintageDeck, 44100, true},
+		{ProfileEmulator, 44100, false},
+	} {
+		opts := ConvertOptions{DeviceProfile: tc.profile}
+		applied := opts.WithProfile()
+		if applied.RepeatHeader != tc.repeat {
+			t.Errorf("%s: RepeatHeader is %v", tc.profile, applied.RepeatHeader)
+		}
+		if tc.rate != 44100 && applied.ResampleTo != tc.rate {
+			t.Errorf("%s: ResampleTo is %d", tc.profile, applied.ResampleTo)
+		}
+		if tc.rate == 44100 && applied.ResampleTo != 0 {
+			t.Errorf("%s: unexpected ResampleTo of %d", tc.profile, applied.ResampleTo)
+		}
+
+		// the output of the profile is loadable
+		w := roundTrip(t, rom, opts)
+
+		// the fmt chunk has the sample rate of the profile
+		f := wavChunk(t, w, "fmt ")
+		hz := binary.LittleEndian.Uint32(f[4:8])
+		if hz != tc.rate {
+			t.Errorf("%s: sample rate is %dHz, expected %dHz", tc.profile, hz, tc.rate)
+		}
+
+		// the header is repeated after the data packets only if the profile
+		// asks for it
+		stream, _, err := BuildStream(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := readWAV(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tapeReader{periods: tapeCycles(p.samples)}
+		err = tr.sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr [8]byte
+		err = tr.read(hdr[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tr.readLoad(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tr.sync()
+		if !tc.repeat {
+			if err == nil {
+				t.Errorf("%s: unexpected header after the data packets", tc.profile)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: repeated header: %v", tc.profile, err)
+		}
+		err = tr.read(hdr[:])
+		if err != nil {
+			t.Fatalf("%s: repeated header: %v", tc.profile, err)
+		}
+		if !bytes.Equal(hdr[:], stream[:8]) {
+			t.Errorf("%s: repeated header is % 02x, expected % 02x", tc.profile, hdr, stream[:8])
+		}
+	}
+
+	// fields set by the caller take precedence over the profile
+	opts := ConvertOptions{DeviceProfile: ProfileModernSoundcard, ResampleTo: 22050}
+	w := roundTrip(t, rom, opts)
+	f := wavChunk(t, w, "fmt ")
+	if hz := binary.LittleEndian.Uint32(f[4:8]); hz != 22050 {
+		t.Errorf("ResampleTo of 22050Hz with the profile gives a rate of %dHz", hz)
+	}
+}
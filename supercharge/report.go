@@ -0,0 +1,85 @@
+package supercharge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConvertReport describes the header and data packets written by a conversion
+type ConvertReport struct {
+	Header
+	Blocks []BlockReport `json:"blocks"`
+
+	// the parity packet written by the AppendParity option. nil if there is no
+	// parity packet
+	Parity *BlockReport `json:"parity,omitempty"`
+
+	// duration of the audio output in seconds. zero if no audio was produced
+	Duration float64 `json:"duration"`
+
+	// the position in the output, in seconds and in sample frames, of the
+	// start of the load. the position is after any silence that separates
+	// the load from the previous load. zero for the first load
+	Offset        float64 `json:"offset,omitempty"`
+	OffsetSamples int     `json:"offset_samples,omitempty"`
+
+	// the sample rate in Hz, the number of channels and the number of bits in
+	// each sample of the audio output. a depth of 32 indicates floating point
+	// samples. zero if no audio was produced
+	SampleRate uint32 `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	BitDepth   int    `json:"bit_depth,omitempty"`
+
+	// the seed for any random numbers used in the audio output
+	Seed int64 `json:"seed"`
+
+	// problems with the conversion that did not prevent the output from
+	// being created
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// BlockReport describes a single data packet written by a conversion
+type BlockReport struct {
+	Page     byte `json:"page"`
+	Checksum byte `json:"checksum"`
+}
+
+// copy returns a deep copy of the report
+func (rep ConvertReport) copy() ConvertReport {
+	rep.Blocks = append([]BlockReport{}, rep.Blocks...)
+	if rep.Parity != nil {
+		p := *rep.Parity
+		rep.Parity = &p
+	}
+	if rep.Warnings != nil {
+		rep.Warnings = append([]string{}, rep.Warnings...)
+	}
+	return rep
+}
+
+// String returns the report as tab-indented text, one field per line
+func (rep ConvertReport) String() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("\taddress: %04x\n", rep.Address))
+	s.WriteString(fmt.Sprintf("\tbank config: %02x (%s)\n", rep.BankConfig, DescribeBankConfig(rep.BankConfig)))
+	s.WriteString(fmt.Sprintf("\tblock count: %02x\n", rep.BlockCount))
+	s.WriteString(fmt.Sprintf("\tmultiload: %02x\n", rep.Multiload))
+	s.WriteString(fmt.Sprintf("\tload speed: %04x\n", rep.ProgressSpeed))
+	s.WriteString(fmt.Sprintf("\tchecksum: %02x\n", rep.Checksum))
+	for i, b := range rep.Blocks {
+		s.WriteString(fmt.Sprintf("\tblock %d: checksum %02x\n", i, b.Checksum))
+	}
+	if rep.Parity != nil {
+		s.WriteString(fmt.Sprintf("\tparity: checksum %02x\n", rep.Parity.Checksum))
+	}
+	if rep.Duration > 0 {
+		s.WriteString(fmt.Sprintf("\tduration: %.2fs\n", rep.Duration))
+	}
+	if rep.OffsetSamples > 0 {
+		s.WriteString(fmt.Sprintf("\toffset: %.2fs (sample %d)\n", rep.Offset, rep.OffsetSamples))
+	}
+	for _, w := range rep.Warnings {
+		s.WriteString(fmt.Sprintf("\twarning: %s\n", w))
+	}
+	return s.String()
+}
@@ -0,0 +1,132 @@
+package supercharge
+
+import (
+	"fmt"
+	"io"
+)
+
+// NewRotatingEncoder returns an Encoder that writes the audio to a sequence of
+// WAV files, each of which is no larger than maxBytes. The create function is
+// called with the sequence number of each file, starting at zero, and returns
+// the io.Writer for that file. A good choice of name for file n is the name of
+// the ROM with a two digit suffix (eg. game_00.wav, game_01.wav, ...)
+//
+// A new file is only started at the beginning of a packet, so the header and
+// each data packet are always whole in one file. A file is larger than
+// maxBytes only if a single packet and the tones around it do not fit into an
+// otherwise empty file
+//
+// Each file is a complete WAV file. Joining the audio of the files in order
+// gives the same audio as a single WAV file
+func NewRotatingEncoder(create func(n int) (io.Writer, error), maxBytes int64) Encoder {
+	return &rotatingEncoder{create: create, maxBytes: maxBytes}
+}
+
+type rotatingEncoder struct {
+	create   func(n int) (io.Writer, error)
+	maxBytes int64
+
+	format Format
+
+	// the current file and the number of files created so far
+	current *wav
+	count   int
+
+	// the frame position of the start of the current file
+	start int
+
+	// the samples written since the start of the most recent packet, and any
+	// cues in that part of the output
+	pending      []float64
+	pendingFrame int
+	pendingCues  []int
+
+	// an error that occurred in packet(). it is returned by the next call to
+	// WriteSamples() or Finalize()
+	err error
+}
+
+func (enc *rotatingEncoder) WriteHeader(f Format) error {
+	if enc.maxBytes <= 0 {
+		return fmt.Errorf("rotating encoder: maximum size must be positive (%d)", enc.maxBytes)
+	}
+	enc.format = f
+	return enc.next()
+}
+
+// next starts a new file
+func (enc *rotatingEncoder) next() error {
+	w, err := enc.create(enc.count)
+	if err != nil {
+		return fmt.Errorf("rotating encoder: file %d: %w", enc.count, err)
+	}
+	enc.count++
+	enc.current = &wav{w: w}
+	enc.start = enc.pendingFrame
+	return enc.current.WriteHeader(enc.format)
+}
+
+func (enc *rotatingEncoder) WriteSamples(samples []float64) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	enc.pending = append(enc.pending, samples...)
+	return nil
+}
+
+func (enc *rotatingEncoder) cue(frame int) {
+	enc.pendingCues = append(enc.pendingCues, frame)
+}
+
+func (enc *rotatingEncoder) packet(frame int) {
+	if enc.err == nil {
+		enc.err = enc.commit()
+	}
+	enc.pendingFrame = frame
+}
+
+// commit adds the pending samples to the current file, starting a new file
+// first if the samples would make the current file too large
+func (enc *rotatingEncoder) commit() error {
+	if len(enc.pending) == 0 {
+		return nil
+	}
+
+	size := enc.current.size() + int64(len(enc.pending)*int(enc.current.depth/8))
+	if len(enc.pendingCues) > 0 {
+		// a cue chunk header and padding byte may also be required
+		size += int64(len(enc.pendingCues)*24 + 13)
+	}
+
+	if size > enc.maxBytes && enc.current.data.Len() > 0 {
+		err := enc.current.Finalize()
+		if err != nil {
+			return err
+		}
+		err = enc.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	// cue positions are relative to the start of the file
+	for _, c := range enc.pendingCues {
+		enc.current.cue(c - enc.start)
+	}
+	enc.pendingCues = enc.pendingCues[:0]
+
+	err := enc.current.WriteSamples(enc.pending)
+	enc.pending = enc.pending[:0]
+	return err
+}
+
+func (enc *rotatingEncoder) Finalize() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	err := enc.commit()
+	if err != nil {
+		return err
+	}
+	return enc.current.Finalize()
+}
@@ -0,0 +1,132 @@
+package supercharge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// packetRecorder records the frame position of each packet
+type packetRecorder struct {
+	packets map[int]bool
+}
+
+func (enc *packetRecorder) WriteHeader(f Format) error           { return nil }
+func (enc *packetRecorder) WriteSamples(samples []float64) error { return nil }
+func (enc *packetRecorder) Finalize() error                      { return nil }
+func (enc *packetRecorder) packet(frame int)                     { enc.packets[frame] = true }
+
+// wavCues returns the position of each cue point in the WAV file. a file with
+// no cue chunk has no cue points
+func wavCues(t *testing.T, data []byte) []int {
+	t.Helper()
+	var c []byte
+	for p := 12; p+8 <= len(data); {
+		l := int(binary.LittleEndian.Uint32(data[p+4 : p+8]))
+		if string(data[p:p+4]) == "cue " {
+			c = wavChunk(t, data, "cue ")
+			break
+		}
+		p += 8 + l + l&1
+	}
+	if c == nil {
+		return nil
+	}
+	var cues []int
+	for i := 0; i < int(binary.LittleEndian.Uint32(c[0:4])); i++ {
+		cues = append(cues, int(binary.LittleEndian.Uint32(c[4+i*24+4:])))
+	}
+	return cues
+}
+
+func TestRotatingEncoder(t *testing.T) {
+	rom := testROM(6144)
+	for i, opts := range []ConvertOptions{
+		{},
+		{CueChunk: true},
+		{Channels: 2, BitDepth: 16, ChannelDelaySamples: 3},
+	} {
+		var single bytes.Buffer
+		_, err := ConvertWithOptions(rom, &single, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := wavChunk(t, single.Bytes(), "data")
+		frameSize := OutputFormat(opts).Channels * OutputFormat(opts).BitDepth / 8
+
+		rec := &packetRecorder{packets: make(map[int]bool)}
+		_, err = ConvertEncoder(rom, rec, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the limits are larger than a single packet so that no file is
+		// larger than the limit
+		for _, maxBytes := range []int64{int64(single.Len()) * 2, int64(single.Len()) / 3, int64(single.Len()) / 8} {
+			var files []*bytes.Buffer
+			create := func(n int) (io.Writer, error) {
+				if n != len(files) {
+					t.Fatalf("options %d, %d bytes: file %d created after %d files", i, maxBytes, n, len(files))
+				}
+				files = append(files, &bytes.Buffer{})
+				return files[n], nil
+			}
+			_, err = ConvertEncoder(rom, NewRotatingEncoder(create, maxBytes), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a limit larger than the output gives the same file as a single
+			// conversion
+			if maxBytes > int64(single.Len()) {
+				if len(files) != 1 || !bytes.Equal(files[0].Bytes(), single.Bytes()) {
+					t.Errorf("options %d, %d bytes: output is different to a single WAV file", i, maxBytes)
+				}
+				continue
+			}
+			if len(files) < 2 {
+				t.Errorf("options %d, %d bytes: only %d files for %d bytes of output", i, maxBytes, len(files), single.Len())
+			}
+
+			// each file is a valid WAV file. the samples of the files joined
+			// in order are the samples of the single file
+			var joined []byte
+			var cues []int
+			for n, f := range files {
+				if int64(f.Len()) > maxBytes {
+					t.Errorf("options %d, %d bytes: file %d is %d bytes", i, maxBytes, n, f.Len())
+				}
+				start := len(joined) / frameSize
+				if n > 0 && !rec.packets[start] {
+					t.Errorf("options %d, %d bytes: file %d does not start at a packet", i, maxBytes, n)
+				}
+				d := wavChunk(t, f.Bytes(), "data")
+				if opts.CueChunk {
+					for _, c := range wavCues(t, f.Bytes()) {
+						if c >= len(d)/frameSize {
+							t.Errorf("options %d, %d bytes: cue at %d is after the end of file %d", i, maxBytes, c, n)
+						}
+						cues = append(cues, start+c)
+					}
+				}
+				joined = append(joined, d...)
+			}
+			if !bytes.Equal(joined, data) {
+				t.Errorf("options %d, %d bytes: joined samples are different to the single WAV file", i, maxBytes)
+			}
+			if opts.CueChunk {
+				expected := wavCues(t, single.Bytes())
+				if len(cues) != len(expected) {
+					t.Fatalf("options %d, %d bytes: %d cues but there should be %d", i, maxBytes, len(cues), len(expected))
+				}
+				for j := range cues {
+					if cues[j] != expected[j] {
+						t.Errorf("options %d, %d bytes: cue %d is at %d but should be at %d", i, maxBytes, j, cues[j], expected[j])
+						break
+					}
+				}
+			}
+		}
+	}
+}
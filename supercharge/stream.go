@@ -0,0 +1,294 @@
+package supercharge
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BuildStream returns the sequence of bytes that is represented by tones in the
+// output of ConvertWithOptions. It is the same data that a Supercharger
+// receives when loading from tape, without any of the tones. The details of the
+// header and packets are returned as a ConvertReport
+//
+// The stream begins with the 8 byte header packet:
+//
+//	offset 0: low byte of the start address
+//	offset 1: high byte of the start address
+//	offset 2: bank configuration
+//	offset 3: block count
+//	offset 4: header checksum
+//	offset 5: multiload index
+//	offset 6: low byte of the progress bar speed
+//	offset 7: high byte of the progress bar speed
+//
+// The header is followed by one packet for each 256 byte block of the ROM.
+// Each packet is 258 bytes long:
+//
+//	offset 0: block number (page offset * 4 plus bank number)
+//	offset 1: block checksum
+//	offset 2: the 256 bytes of block data
+//
+// The AppendParity option adds a parity packet with the same layout after the
+// last data packet. See the ConvertOptions type for details
+//
+// The $55 calibration bytes and the $54 synchronisation byte that precede the
+// header on tape, and the zero bytes that follow the last packet, are not part
+// of the stream
+func BuildStream(rom []byte, opts ConvertOptions) ([]byte, ConvertReport, error) {
+	opts = opts.WithProfile()
+
+	// 1) comments in quotation marks are from the sctech.txt document
+	// 2) double asterisks are used to additional commentary on the content of
+	//    sctech.txt
+
+	// "An 8 byte header packet follows [...]
+	//
+	// The header indicates the starting point of execution, how many packets
+	// of game data, the bank switching configuration for the game, how
+	// quickly to scroll inward the blue progress bars, and a checksum"
+	// Its format is:
+	// - Low order byte of the address to start executing the game's startup code
+	// - High order byte of same
+	// - Bank configuration as noted below
+	// - Block count (number of 256 byte program data packets)
+	// - Checksum: computed like game data checksums.  Sum of whole header is $55.
+	// - Multiload index #.  Set to 0 for first or only load of the game.
+	//   Each new multiload game was assigned new numbers sequentially so that
+	//   no other multiload stage from another game would be accidentally
+	//   loaded
+	// - (Low, high) 16 bit speed value for progress bars.  $224 is perfect
+	//   for a 6K game image.  $16D is right for 4K, and $00B6 is right for 2K
+	//   game images"
+
+	rom, err := TrimROM(rom, opts)
+	if err != nil {
+		return nil, ConvertReport{}, err
+	}
+
+	// a header without any data packets would load nothing. this is checked
+	// before the ROM is validated so that a ROM that is empty after trimming
+	// is not reported as an unsupported size
+	blockCount := byte(len(rom) / 256)
+	if blockCount == 0 {
+		return nil, ConvertReport{}, fmt.Errorf("%w (%d bytes)", NoBlocks, len(rom))
+	}
+
+	address, err := startAddress(rom)
+	if err != nil {
+		return nil, ConvertReport{}, err
+	}
+	bankConfig := opts.bankConfig()
+
+	err = validateBankConfig(bankConfig, int(blockCount))
+	if err != nil {
+		return nil, ConvertReport{}, err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return nil, ConvertReport{}, err
+	}
+
+	// a raw header replaces the header on tape. the report describes the
+	// header as it is written
+	hdr, err := newHeader(address, int(blockCount), opts)
+	if err != nil {
+		return nil, ConvertReport{}, err
+	}
+	rep := ConvertReport{Header: hdr}
+	b := hdr.Bytes()
+	stream := b[:]
+
+	// "The game data
+	// -------------
+	// For each 256 bytes of data in the game, a packet is written consisting
+	// of a block number that encodes the address page offset * 4 plus the
+	// bank number, and a checksum that encompasses all 256 bytes of data plus
+	// the block number as written to tape.  The data then follows in normal
+	// linear fashion, all 256 bytes being written.
+
+	// The checksum is calculated by adding all checksummed data, ignoring
+	// carries or overflows.  The value [$55 minus the sum], again ignoring
+	// carries and underflows, is the checksum to write to tape.  Hence, the
+	// sum of the whole data packet including the checksum byte itself will
+	// be $55"
+	err = validateBlockOrder(opts.BlockOrder, int(blockCount))
+	if err != nil {
+		return nil, ConvertReport{}, err
+	}
+
+	var parity [256]byte
+
+	for n := byte(0); n < blockCount; n++ {
+		// the order in which the blocks are written
+		block := n
+		if opts.BlockOrder != nil {
+			block = byte(opts.BlockOrder[n])
+		}
+
+		page := blockNumber(bankConfig, int(blockCount), int(block))
+
+		// block data
+		s := int(block) * 256
+		data := rom[s : s+256]
+		if opts.BlockTransform != nil {
+			data = opts.BlockTransform(int(block), append([]byte{}, data...))
+			if len(data) != 256 {
+				return nil, ConvertReport{}, fmt.Errorf("block transform: block %d is %d bytes", block, len(data))
+			}
+		}
+		if opts.ByteSwapBlocks {
+			data = swapBytes(append([]byte{}, data...))
+		}
+
+		// checksum. the block number is included in the checksum along with
+		// the block data
+		checksum := PacketChecksum(0x55-page, data)
+		rep.Blocks = append(rep.Blocks, BlockReport{Page: page, Checksum: checksum})
+
+		// block number and checksum followed by the block data
+		stream = append(stream, page, checksum)
+		stream = append(stream, data...)
+
+		for i, b := range data {
+			parity[i] ^= b
+		}
+	}
+
+	// the parity packet follows the last data packet
+	if opts.AppendParity {
+		checksum := PacketChecksum(0x55-parityBlockNumber, parity[:])
+		rep.Parity = &BlockReport{Page: parityBlockNumber, Checksum: checksum}
+		stream = append(stream, parityBlockNumber, checksum)
+		stream = append(stream, parity[:]...)
+	}
+
+	return stream, rep, nil
+}
+
+// the block number of the parity packet written by the AppendParity option.
+// the number refers to the ROM bank so it is never the block number of a data
+// packet
+const parityBlockNumber = 0x1f
+
+// swapBytes swaps each pair of adjacent bytes in the data. the data is changed
+// in place and returned
+func swapBytes(data []byte) []byte {
+	for i := 0; i+1 < len(data); i += 2 {
+		data[i], data[i+1] = data[i+1], data[i]
+	}
+	return data
+}
+
+// validateBlockOrder checks that the order contains each block index exactly
+// once. a nil order is valid
+func validateBlockOrder(order []int, blockCount int) error {
+	if order == nil {
+		return nil
+	}
+	if len(order) != blockCount {
+		return fmt.Errorf("block order: %d entries for %d blocks", len(order), blockCount)
+	}
+	seen := make([]bool, blockCount)
+	for _, b := range order {
+		if b < 0 || b >= blockCount {
+			return fmt.Errorf("block order: block %d does not exist", b)
+		}
+		if seen[b] {
+			return fmt.Errorf("block order: block %d appears more than once", b)
+		}
+		seen[b] = true
+	}
+	return nil
+}
+
+// PacketChecksum returns the checksum byte for a packet. The checksum is the
+// target value minus the sum of the data, ignoring carries and underflows. The
+// sum of the data and the checksum is therefore the target value
+//
+// Supercharger header and data packets use a target of $55
+func PacketChecksum(target byte, data []byte) byte {
+	checksum := target
+	for _, b := range data {
+		checksum -= b
+	}
+	return checksum
+}
+
+// StartAddress returns the address at which execution of the ROM begins, as it
+// would be written to the header by ConvertWithOptions. The address is taken
+// from the reset vector in the last four bytes of the ROM
+//
+// The ROM is trimmed with TrimROM() and validated before the address is read
+func StartAddress(rom []byte, opts ConvertOptions) (uint16, error) {
+	rom, err := TrimROM(rom, opts)
+	if err != nil {
+		return 0, err
+	}
+	return startAddress(rom)
+}
+
+// startAddress validates the ROM and reads the start address from the reset
+// vector
+func startAddress(rom []byte) (uint16, error) {
+	err := Validate(rom)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(rom[len(rom)-3])<<8 | uint16(rom[len(rom)-4]), nil
+}
+
+// CompareChecksums checks that the header and block checksums computed for the
+// ROM with the default options are the same as those in the reference. The
+// reference is a stream of header and packet bytes in the format described by
+// BuildStream, as extracted from the output of another tool such as makewav
+//
+// Returns nil if every checksum matches. Otherwise the error describes the
+// first checksum that differs
+func CompareChecksums(rom []byte, reference []byte) error {
+	stream, _, err := BuildStream(rom, Default())
+	if err != nil {
+		return err
+	}
+
+	if len(reference) != len(stream) {
+		return fmt.Errorf("compare checksums: reference is %d bytes but stream is %d bytes", len(reference), len(stream))
+	}
+
+	if stream[4] != reference[4] {
+		return fmt.Errorf("compare checksums: header checksum is %02x but reference is %02x", stream[4], reference[4])
+	}
+
+	for i, s := 0, 8; s < len(stream); i, s = i+1, s+258 {
+		if stream[s+1] != reference[s+1] {
+			return fmt.Errorf("compare checksums: block %d checksum is %02x but reference is %02x", i, stream[s+1], reference[s+1])
+		}
+	}
+
+	return nil
+}
+
+// BlockDiff returns the indices of the 256 byte blocks that are different in
+// the two ROMs. Both ROMs must pass Validate() and be the same size
+func BlockDiff(a []byte, b []byte) ([]int, error) {
+	err := Validate(a)
+	if err != nil {
+		return nil, fmt.Errorf("block diff: first ROM: %w", err)
+	}
+	err = Validate(b)
+	if err != nil {
+		return nil, fmt.Errorf("block diff: second ROM: %w", err)
+	}
+	if len(a) != len(b) {
+		return nil, fmt.Errorf("block diff: ROMs are different sizes (%d and %d bytes)", len(a), len(b))
+	}
+
+	var diff []int
+	for i := 0; i < len(a)/256; i++ {
+		if !bytes.Equal(a[i*256:(i+1)*256], b[i*256:(i+1)*256]) {
+			diff = append(diff, i)
+		}
+	}
+
+	return diff, nil
+}
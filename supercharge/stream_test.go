@@ -0,0 +1,721 @@
+package supercharge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDefaultProgressSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		size  int
+		speed uint16
+	}{
+		{2048, 0x00b6},
+		{4096, 0x016d},
+		{6144, 0x0224},
+	} {
+		stream, rep, err := BuildStream(testROM(tc.size), ConvertOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Header.ProgressSpeed != tc.speed {
+			t.Errorf("%d bytes: progress speed is %04x but should be %04x", tc.size, rep.Header.ProgressSpeed, tc.speed)
+		}
+		if stream[6] != byte(tc.speed) || stream[7] != byte(tc.speed>>8) {
+			t.Errorf("%d bytes: progress speed bytes are %02x %02x", tc.size, stream[6], stream[7])
+		}
+		if sum(stream[:8]) != 0x55 {
+			t.Errorf("%d bytes: header sums to %02x", tc.size, sum(stream[:8]))
+		}
+
+		// the default is the same as the normal preset
+		_, normal, err := BuildStream(testROM(tc.size), ConvertOptions{ProgressPreset: ProgressNormal})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normal.Header.ProgressSpeed != rep.Header.ProgressSpeed {
+			t.Errorf("%d bytes: normal preset speed is %04x but the default is %04x", tc.size, normal.Header.ProgressSpeed, rep.Header.ProgressSpeed)
+		}
+	}
+}
+
+func TestROMSizes(t *testing.T) {
+	for _, tc := range []struct {
+		size    int
+		packets int
+		speed   [2]byte
+	}{
+		{2048, 8, [2]byte{0xb6, 0x00}},
+		{4096, 16, [2]byte{0x6d, 0x01}},
+		{6144, 24, [2]byte{0x24, 0x02}},
+	} {
+		rom := testROM(tc.size)
+		stream, rep, err := BuildStream(rom, ConvertOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stream) != 8+tc.packets*258 || len(rep.Blocks) != tc.packets {
+			t.Fatalf("%d bytes: stream is %d bytes with %d blocks", tc.size, len(stream), len(rep.Blocks))
+		}
+		if int(stream[3]) != tc.packets {
+			t.Errorf("%d bytes: block count in the header is %d", tc.size, stream[3])
+		}
+		if stream[6] != tc.speed[0] || stream[7] != tc.speed[1] {
+			t.Errorf("%d bytes: speed bytes are %02x %02x but should be %02x %02x", tc.size, stream[6], stream[7], tc.speed[0], tc.speed[1])
+		}
+
+		// every packet has a different block number and holds the next page
+		// of the ROM
+		numbers := make(map[byte]bool)
+		for n := 0; n < tc.packets; n++ {
+			packet := stream[8+n*258 : 8+(n+1)*258]
+			if numbers[packet[0]] || packet[0] >= parityBlockNumber || packet[0] != rep.Blocks[n].Page {
+				t.Errorf("%d bytes: packet %d has block number %02x", tc.size, n, packet[0])
+			}
+			numbers[packet[0]] = true
+			if sum(packet) != 0x55 {
+				t.Errorf("%d bytes: packet %d sums to %02x", tc.size, n, sum(packet))
+			}
+			if !bytes.Equal(packet[2:], rom[n*256:(n+1)*256]) {
+				t.Errorf("%d bytes: packet %d does not hold page %d of the ROM", tc.size, n, n)
+			}
+		}
+
+		roundTrip(t, rom, ConvertOptions{})
+	}
+}
+
+func TestProgressPreset(t *testing.T) {
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		opts  ConvertOptions
+		speed uint16
+	}{
+		{ConvertOptions{ProgressPreset: ProgressSlow}, 0x02da},
+		{ConvertOptions{ProgressPreset: ProgressNormal}, 0x016d},
+		{ConvertOptions{ProgressPreset: ProgressFast}, 0x00b6},
+		{ConvertOptions{FastLoad: true}, 0xffff},
+	} {
+		_, rep, err := BuildStream(rom, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Header.ProgressSpeed != tc.speed {
+			t.Errorf("preset %d, fast load %v: progress speed is %04x but should be %04x", tc.opts.ProgressPreset, tc.opts.FastLoad, rep.Header.ProgressSpeed, tc.speed)
+		}
+	}
+}
+
+func TestNoBlocks(t *testing.T) {
+	for _, tc := range []struct {
+		rom  []byte
+		opts ConvertOptions
+	}{
+		{nil, ConvertOptions{}},
+		{make([]byte, 100), ConvertOptions{}},
+		{testROM(2048), ConvertOptions{TrimTrailing: 2048}},
+	} {
+		_, _, err := BuildStream(tc.rom, tc.opts)
+		if !errors.Is(err, NoBlocks) {
+			t.Errorf("%d bytes, trimmed by %d: BuildStream returned %v", len(tc.rom), tc.opts.TrimTrailing, err)
+		}
+	}
+}
+
+func TestBlockTransform(t *testing.T) {
+	rom := testROM(4096)
+
+	// the key is different for each block
+	xor := func(block int, data []byte) []byte {
+		for i := range data {
+			data[i] ^= byte(0xa5 + block)
+		}
+		return data
+	}
+	opts := ConvertOptions{BlockTransform: xor}
+
+	stream, rep, err := BuildStream(rom, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the checksum of each packet is over the transformed bytes
+	for i, b := range rep.Blocks {
+		p := stream[8+i*258 : 8+(i+1)*258]
+		if sum(p) != 0x55 {
+			t.Errorf("packet %d sums to %02x", i, sum(p))
+		}
+		expected := xor(i, append([]byte{}, rom[i*256:(i+1)*256]...))
+		if !bytes.Equal(p[2:], expected) {
+			t.Errorf("data of packet %d is not transformed", i)
+		}
+		if b.Checksum != PacketChecksum(0x55-b.Page, expected) || p[1] != b.Checksum {
+			t.Errorf("checksum of packet %d is %02x", i, p[1])
+		}
+	}
+
+	// the tape decodes to the transformed data. reversing the transform gives
+	// the ROM
+	var w bytes.Buffer
+	_, err = ConvertWithOptions(rom, &w, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AssertLoadable(w.Bytes())
+	if err != nil {
+		t.Fatalf("output is not loadable: %v", err)
+	}
+	data, err := Decode(bytes.NewReader(w.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(rom) {
+		t.Fatalf("decoded %d bytes", len(data))
+	}
+	for i := 0; i < len(data)/256; i++ {
+		xor(i, data[i*256:(i+1)*256])
+	}
+	if !bytes.Equal(data, rom) {
+		t.Errorf("reversing the transform of the decoded data does not give the ROM")
+	}
+}
+
+func TestStreamReference(t *testing.T) {
+	// a 4K ROM in which each byte is the low byte of its offset, apart from
+	// the reset vector of $F000
+	rom := make([]byte, 4096)
+	for i := range rom {
+		rom[i] = byte(i)
+	}
+	rom[4092] = 0x00
+	rom[4093] = 0xf0
+
+	stream, _, err := BuildStream(rom, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream) != 8+16*258 {
+		t.Fatalf("stream is %d bytes", len(stream))
+	}
+
+	// the header and the block numbers and checksums are worked out from the
+	// description in sctech.txt. the blocks are in bank 1 and then bank 2 for
+	// the default bank configuration of $1D
+	hdr := []byte{0x00, 0xf0, 0x1d, 0x10, 0xca, 0x00, 0x6d, 0x01}
+	if !bytes.Equal(stream[:8], hdr) {
+		t.Errorf("header is % x but should be % x", stream[:8], hdr)
+	}
+	for _, tc := range []struct {
+		block    int
+		page     byte
+		checksum byte
+	}{
+		{0, 0x01, 0xd4},
+		{1, 0x05, 0xd0},
+		{7, 0x1d, 0xb8},
+		{8, 0x02, 0xd3},
+		{15, 0x1e, 0xc0},
+	} {
+		p := stream[8+tc.block*258:]
+		if p[0] != tc.page || p[1] != tc.checksum {
+			t.Errorf("block %d: block number %02x and checksum %02x but should be %02x and %02x", tc.block, p[0], p[1], tc.page, tc.checksum)
+		}
+		if !bytes.Equal(p[2:258], rom[tc.block*256:(tc.block+1)*256]) {
+			t.Errorf("block %d: data is different to the ROM", tc.block)
+		}
+	}
+}
+
+func TestCompareChecksums(t *testing.T) {
+	rom := make([]byte, 4096)
+	for i := range rom {
+		rom[i] = byte(i)
+	}
+	rom[4092] = 0x00
+	rom[4093] = 0xf0
+
+	// the reference is built by hand in the way makewav builds its stream.
+	// the bytes of every packet sum to $55
+	ref := []byte{0x00, 0xf0, 0x1d, 0x10, 0xca, 0x00, 0x6d, 0x01}
+	for i := 0; i < 16; i++ {
+		page := byte(i%8*4 + i/8 + 1)
+		data := rom[i*256 : (i+1)*256]
+		ref = append(ref, page, 0x55-page-sum(data))
+		ref = append(ref, data...)
+	}
+	if ref[8+258] != 0x05 || ref[9] != 0xd4 {
+		t.Fatalf("reference is not correct")
+	}
+
+	err := CompareChecksums(rom, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		offset int
+		err    string
+	}{
+		{4, "header checksum is ca but reference is cb"},
+		{8 + 8*258 + 1, "block 8 checksum is d3 but reference is d4"},
+		{8 + 15*258 + 1, "block 15 checksum is c0 but reference is c1"},
+	} {
+		bad := bytes.Clone(ref)
+		bad[tc.offset]++
+		err := CompareChecksums(rom, bad)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("error for a changed byte at offset %d is %v", tc.offset, err)
+		}
+	}
+
+	// only the checksums are compared. data that is different is not noticed
+	bad := bytes.Clone(ref)
+	bad[8+2]++
+	err = CompareChecksums(rom, bad)
+	if err != nil {
+		t.Errorf("different data should not change the result: %v", err)
+	}
+
+	err = CompareChecksums(rom, ref[:len(ref)-1])
+	if err == nil || !strings.Contains(err.Error(), "reference is 4135 bytes but stream is 4136 bytes") {
+		t.Errorf("error for a short reference is %v", err)
+	}
+}
+
+func TestStartAddress(t *testing.T) {
+	for _, tc := range []struct {
+		size  int
+		reset uint16
+		opts  ConvertOptions
+	}{
+		{2048, 0xf800, ConvertOptions{}},
+		{4096, 0xf123, ConvertOptions{}},
+		{6144, 0xfabc, ConvertOptions{}},
+
+		// the reset vector is read from the trimmed ROM
+		{4096, 0xf456, ConvertOptions{TrimTrailing: 16}},
+		{4096, 0xf789, ConvertOptions{TrimFooter: true}},
+	} {
+		rom := testROM(tc.size)
+		rom[tc.size-4] = byte(tc.reset)
+		rom[tc.size-3] = byte(tc.reset >> 8)
+		if tc.opts.TrimTrailing > 0 {
+			rom = append(rom, make([]byte, tc.opts.TrimTrailing)...)
+		}
+		if tc.opts.TrimFooter {
+			rom = append(rom, bytes.Repeat([]byte("signature "), 10)[:64]...)
+		}
+
+		addr, err := StartAddress(rom, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != tc.reset {
+			t.Errorf("start address is %04x but should be %04x", addr, tc.reset)
+		}
+
+		// the address is the first two bytes of the header, low byte first
+		var w bytes.Buffer
+		_, err = ConvertWithOptions(rom, &w, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := Decode(bytes.NewReader(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, rom[:tc.size]) {
+			t.Errorf("decoded data is different to the trimmed ROM")
+		}
+		p, err := readWAV(bytes.NewReader(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tapeReader{periods: tapeCycles(p.samples)}
+		err = tr.sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr [8]byte
+		err = tr.read(hdr[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint16(hdr[1])<<8|uint16(hdr[0]) != addr {
+			t.Errorf("header starts with %02x %02x but the start address is %04x", hdr[0], hdr[1], addr)
+		}
+	}
+}
+
+func TestFastLoad(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		for _, opts := range []ConvertOptions{
+			{FastLoad: true},
+
+			// the emulator profile sets fast load
+			{DeviceProfile: ProfileEmulator},
+		} {
+			stream, _, err := BuildStream(rom, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stream[6] != 0xff || stream[7] != 0xff {
+				t.Errorf("%d bytes: progress speed bytes are %02x %02x but should be ff ff", size, stream[6], stream[7])
+			}
+			if sum(stream[:8]) != 0x55 {
+				t.Errorf("%d bytes: header sums to %02x", size, sum(stream[:8]))
+			}
+			roundTrip(t, rom, opts)
+		}
+	}
+
+	// a progress speed chosen by the user is not replaced by the profile
+	stream, _, err := BuildStream(testROM(4096), ConvertOptions{DeviceProfile: ProfileEmulator, ProgressSpeed: 0x1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream[6] != 0x34 || stream[7] != 0x12 {
+		t.Errorf("progress speed bytes are %02x %02x but should be 34 12", stream[6], stream[7])
+	}
+
+	for _, opts := range []ConvertOptions{
+		{FastLoad: true, ProgressSpeed: 0x1234},
+		{FastLoad: true, ProgressPreset: ProgressSlow},
+	} {
+		_, _, err := BuildStream(testROM(4096), opts)
+		if err == nil {
+			t.Errorf("fast load should not be allowed with %+v", opts)
+		}
+	}
+}
+
+func TestProgressSpeed(t *testing.T) {
+	rom := testROM(4096)
+	for _, speed := range []uint16{0x0001, 0x00ff, 0x0100, 0x1234, 0xabcd, 0xfffe} {
+		opts := ConvertOptions{ProgressSpeed: speed}
+		stream, rep, err := BuildStream(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Header.ProgressSpeed != speed {
+			t.Errorf("%04x: progress speed in the report is %04x", speed, rep.Header.ProgressSpeed)
+		}
+
+		// the speed is written low byte first. the header checksum is
+		// changed so that the header still sums to $55
+		if stream[6] != byte(speed) || stream[7] != byte(speed>>8) {
+			t.Errorf("%04x: progress speed bytes are %02x %02x", speed, stream[6], stream[7])
+		}
+		if sum(stream[:8]) != 0x55 {
+			t.Errorf("%04x: header sums to %02x", speed, sum(stream[:8]))
+		}
+
+		w := roundTrip(t, rom, opts)
+		vrep, err := VerifyWAV(bytes.NewReader(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(vrep.Header[:], stream[:8]) {
+			t.Errorf("%04x: header on the tape is % 02x but should be % 02x", speed, vrep.Header, stream[:8])
+		}
+	}
+}
+
+func TestPacketChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		target   byte
+		data     []byte
+		checksum byte
+	}{
+		{0x55, nil, 0x55},
+		{0x55, []byte{0x00}, 0x55},
+		{0x55, []byte{0x55}, 0x00},
+		{0x55, []byte{0x56}, 0xff},
+		{0x55, []byte{0xff, 0xff}, 0x57},
+		{0x00, []byte{0x01, 0x02, 0x03}, 0xfa},
+
+		// the header of the reference stream, without its checksum byte
+		{0x55, []byte{0x00, 0xf0, 0x1d, 0x10, 0x00, 0x6d, 0x01}, 0xca},
+	} {
+		checksum := PacketChecksum(tc.target, tc.data)
+		if checksum != tc.checksum {
+			t.Errorf("checksum of % 02x for target %02x is %02x but should be %02x", tc.data, tc.target, checksum, tc.checksum)
+		}
+		if sum(append(bytes.Clone(tc.data), checksum)) != tc.target {
+			t.Errorf("data and checksum of % 02x do not sum to %02x", tc.data, tc.target)
+		}
+	}
+
+	// the checksum of every packet in a stream. the checksum byte is the
+	// second byte of the packet and is not included in the data
+	stream, _, err := BuildStream(testROM(6144), ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := 0, stream[8:]; len(p) > 0; i, p = i+1, p[258:] {
+		data := append([]byte{p[0]}, p[2:258]...)
+		if PacketChecksum(0x55, data) != p[1] {
+			t.Errorf("block %d: checksum is %02x but should be %02x", i, p[1], PacketChecksum(0x55, data))
+		}
+	}
+}
+
+func TestBlockOrder(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		count := size / 256
+		// five has no common factor with any of the block counts so the
+		// shuffled order has every block exactly once
+		reversed := make([]int, count)
+		shuffled := make([]int, count)
+		for i := range reversed {
+			reversed[i] = count - 1 - i
+			shuffled[i] = (i * 5) % count
+		}
+
+		for _, order := range [][]int{reversed, shuffled} {
+			opts := ConvertOptions{BlockOrder: order}
+			_, plain, err := BuildStream(rom, ConvertOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream, rep, err := BuildStream(rom, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the packets are in the order given. each packet is the same as
+			// the packet for the block in the normal order
+			for n, b := range order {
+				p := stream[8+n*258:]
+				if rep.Blocks[n] != plain.Blocks[b] || p[0] != plain.Blocks[b].Page {
+					t.Errorf("%d bytes: packet %d is %+v but block %d is %+v", size, n, rep.Blocks[n], b, plain.Blocks[b])
+				}
+				if !bytes.Equal(p[2:258], rom[b*256:(b+1)*256]) {
+					t.Errorf("%d bytes: packet %d does not contain block %d", size, n, b)
+				}
+			}
+
+			// the block numbers read from the tape are in the same order
+			w := roundTrip(t, rom, opts)
+			p, err := readWAV(bytes.NewReader(w))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tapeReader{periods: tapeCycles(p.samples)}
+			err = tr.sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var hdr [8]byte
+			err = tr.read(hdr[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for n, b := range order {
+				var packet [258]byte
+				err = tr.read(packet[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if packet[0] != plain.Blocks[b].Page {
+					t.Errorf("%d bytes: packet %d on the tape has block number %02x but should be %02x", size, n, packet[0], plain.Blocks[b].Page)
+				}
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		order []int
+		err   string
+	}{
+		{[]int{0, 1, 2, 3, 4, 5, 6}, "7 entries for 8 blocks"},
+		{[]int{0, 1, 2, 3, 4, 5, 6, 8}, "block 8 does not exist"},
+		{[]int{0, 1, 2, 3, 4, 5, 6, -1}, "block -1 does not exist"},
+		{[]int{0, 1, 2, 3, 4, 5, 6, 6}, "block 6 appears more than once"},
+	} {
+		_, _, err := BuildStream(testROM(2048), ConvertOptions{BlockOrder: tc.order})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("error for the order %v is %v", tc.order, err)
+		}
+	}
+}
+
+func TestBlockDiff(t *testing.T) {
+	a := testROM(4096)
+	for _, tc := range []struct {
+		changes []int
+		diff    []int
+	}{
+		{nil, nil},
+		{[]int{0}, []int{0}},
+		{[]int{255, 256}, []int{0, 1}},
+		{[]int{1000, 1001, 3000}, []int{3, 11}},
+		{[]int{4095 - 4}, []int{15}},
+	} {
+		b := bytes.Clone(a)
+		for _, c := range tc.changes {
+			b[c] ^= 0x01
+		}
+		diff, err := BlockDiff(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(diff) != fmt.Sprint(tc.diff) {
+			t.Errorf("changes at %v: blocks %v are different but should be %v", tc.changes, diff, tc.diff)
+		}
+	}
+
+	_, err := BlockDiff(a, testROM(2048))
+	if err == nil || !strings.Contains(err.Error(), "different sizes (4096 and 2048 bytes)") {
+		t.Errorf("error for ROMs of different sizes is %v", err)
+	}
+	_, err = BlockDiff(a, make([]byte, 100))
+	if err == nil || !strings.Contains(err.Error(), "second ROM") {
+		t.Errorf("error for an invalid second ROM is %v", err)
+	}
+	_, err = BlockDiff(make([]byte, 100), a)
+	if err == nil || !strings.Contains(err.Error(), "first ROM") {
+		t.Errorf("error for an invalid first ROM is %v", err)
+	}
+}
+
+func TestByteSwapBlocks(t *testing.T) {
+	rom := testROM(4096)
+	opts := ConvertOptions{ByteSwapBlocks: true}
+	stream, rep, err := BuildStream(rom, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each pair of bytes in the packet is swapped and the checksum is of the
+	// swapped data
+	for n := 0; n < 16; n++ {
+		p := stream[8+n*258:]
+		block := rom[n*256 : (n+1)*256]
+		for i := 0; i < 256; i += 2 {
+			if p[2+i] != block[i+1] || p[2+i+1] != block[i] {
+				t.Fatalf("block %d: bytes %d and %d are not swapped", n, i, i+1)
+			}
+		}
+		if sum(p[:258]) != 0x55 || rep.Blocks[n].Checksum != p[1] {
+			t.Errorf("block %d: checksum %02x is not for the swapped data", n, p[1])
+		}
+	}
+
+	// the ROM itself is not changed
+	if !bytes.Equal(rom, testROM(4096)) {
+		t.Errorf("ROM was changed by the byte swap")
+	}
+
+	// the swapped data is on the tape. decoding with the option restores the
+	// original order
+	w := roundTrip(t, rom, opts)
+	data, err := Decode(bytes.NewReader(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:256], stream[8+2:8+258]) {
+		t.Errorf("data on the tape is not swapped")
+	}
+
+	// the swap is applied after the block transform
+	key := func(block int, data []byte) []byte {
+		data[0] ^= 0xff
+		return data
+	}
+	stream, _, err = BuildStream(rom, ConvertOptions{ByteSwapBlocks: true, BlockTransform: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream[8+2+1] != rom[0]^0xff || stream[8+2] != rom[1] {
+		t.Errorf("byte swap is not applied after the block transform")
+	}
+}
+
+func TestAppendParity(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		count := size / 256
+		for _, opts := range []ConvertOptions{
+			{AppendParity: true},
+			{AppendParity: true, ByteSwapBlocks: true},
+		} {
+			stream, rep, err := BuildStream(rom, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stream) != 8+(count+1)*258 {
+				t.Fatalf("%d bytes: stream is %d bytes", size, len(stream))
+			}
+			if int(stream[3]) != count {
+				t.Errorf("%d bytes: block count in the header is %d", size, stream[3])
+			}
+
+			// the parity packet is the exclusive-or of the data of every
+			// packet, as written to tape
+			parity := stream[8+count*258:]
+			var xor [256]byte
+			for n := 0; n < count; n++ {
+				for i, b := range stream[8+n*258+2 : 8+(n+1)*258] {
+					xor[i] ^= b
+				}
+			}
+			if parity[0] != parityBlockNumber || !bytes.Equal(parity[2:], xor[:]) {
+				t.Errorf("%d bytes: parity packet has block number %02x and is not the exclusive-or of the blocks", size, parity[0])
+			}
+			if sum(parity) != 0x55 || rep.Parity == nil || rep.Parity.Checksum != parity[1] {
+				t.Errorf("%d bytes: parity checksum is %02x and the report is %v", size, parity[1], rep.Parity)
+			}
+
+			// any one block can be recovered from the parity and the other
+			// blocks
+			lost := count / 2
+			recovered := append([]byte{}, parity[2:]...)
+			for n := 0; n < count; n++ {
+				if n == lost {
+					continue
+				}
+				for i, b := range stream[8+n*258+2 : 8+(n+1)*258] {
+					recovered[i] ^= b
+				}
+			}
+			if !bytes.Equal(recovered, stream[8+lost*258+2:8+(lost+1)*258]) {
+				t.Errorf("%d bytes: block %d is not recovered from the parity", size, lost)
+			}
+
+			// the parity packet follows the last data packet on the tape
+			w := roundTrip(t, rom, opts)
+			p, err := readWAV(bytes.NewReader(w))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tapeReader{periods: tapeCycles(p.samples)}
+			err = tr.sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var hdr [8]byte
+			err = tr.read(hdr[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tr.readLoad(hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var packet [258]byte
+			err = tr.read(packet[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(packet[:], parity) {
+				t.Errorf("%d bytes: packet after the data packets is not the parity packet", size)
+			}
+		}
+	}
+}
@@ -1,10 +1,11 @@
 package supercharge
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 )
 
 // values used during the generation of the wav file. values are the same as the
@@ -24,42 +25,202 @@ const (
 	zeroToneVolume  = 0.98
 	oneToneVolume   = 0.98
 
-	// sample rate of the wav file
+	// the normal rate at which the tones are generated. the lengths of the
+	// tone cycles are given for this rate
 	sampleRate = 44100.0
+
+	// the range of rates accepted by the SampleRate option
+	minSampleRate = 8000
+	maxSampleRate = 192000
+
+	// length of the two parts of the stage marker
+	stageMarkerSilenceSeconds = 1.0
+	stageMarkerToneSeconds    = 0.5
+
+	// length of the two parts of the resync marker
+	resyncToneSeconds        = 0.25
+	resyncCalibrationSeconds = 0.25
+
+	// length of each label beep and the silence that follows it. the beep
+	// is a tone of about 1000Hz
+	labelBeepSeconds    = 0.1
+	labelSilenceSeconds = 0.1
+	labelBeepCycle      = 44
+	labelBeepVolume     = 0.5
+
+	// trailing silence longer than this causes a warning
+	largeSilenceSeconds = 60.0
 )
 
-// generate a sine wave of the given length
-func tone(w io.Writer, length int, volume float64) {
+// ToneFrequencies returns the frequency in Hz of the start tone and of the tones
+// used to represent zero and one bits. The frequencies depend only on the
+// SampleRate field of the options
+func ToneFrequencies(opts ConvertOptions) (start, zero, one float64) {
+	rate := opts.generationRate()
+	start = rate / float64(opts.cycle(startToneCycle))
+	zero = rate / float64(opts.cycle(zeroToneCycle))
+	one = rate / float64(opts.cycle(oneToneCycle))
+	return start, zero, one
+}
+
+// OutputFormat returns the Format of the audio that is created by a conversion
+// with the options. It is the Format given to the WriteHeader function of the
+// Encoder
+func OutputFormat(opts ConvertOptions) Format {
+	return opts.WithProfile().format()
+}
+
+// cycleLength scales the length in samples of a tone cycle at the normal rate
+// to the rate given in Hz. a cycle is never shorter than two samples
+func cycleLength(length int, rate float64) int {
+	n := int(math.Round(float64(length) * rate / sampleRate))
+	if n < 2 {
+		return 2
+	}
+	return n
+}
+
+// TapeLength returns the length of tape in feet required to record audio of
+// the given duration in seconds, at a tape speed of ips inches per second. The
+// standard speed for compact cassettes is 1.875 inches per second
+func TapeLength(seconds float64, ips float64) (feet float64) {
+	return seconds * ips / 12
+}
+
+// the slope of the ramps of the trapezoid waveform, as a multiple of the
+// triangle wave. each ramp takes one sixth of a cycle
+const trapezoidSlope = 3
+
+// generate a single cycle of a tone of the given length and waveform. sample
+// values are in the range -volume to +volume. the phase is the position in the
+// cycle of the first sample, as a fraction of a cycle
+func tone(length int, volume float64, phase float64, waveform Waveform) []float64 {
+	t := make([]float64, length)
 	m := 2 * math.Pi / float64(length)
-	for i := 0; i < length; i++ {
-		x := m * float64(i)
-		y := (math.Sin(x)*volume + 1) * 128
-		w.Write([]byte{byte(y)})
+	offset := 2 * math.Pi * phase
+	for i := range t {
+		if waveform == WaveformSine {
+			x := m*float64(i) + offset
+			t[i] = math.Sin(x) * volume
+			continue
+		}
+		_, p := math.Modf(float64(i)/float64(length) + phase)
+		t[i] = shape(waveform, p) * volume
+	}
+	return t
+}
+
+// shape returns the value of the waveform at the position in the cycle, as a
+// fraction of a cycle. the value is in the range -1 to +1
+func shape(waveform Waveform, p float64) float64 {
+	switch waveform {
+	case WaveformSquare:
+		if p < 0.5 {
+			return 1
+		}
+		return -1
+	case WaveformTrapezoid:
+		// a triangle wave with the same peaks and zero crossings as the sine
+		// wave, steepened and clipped at the peaks
+		tri := 4 * p
+		if p >= 0.75 {
+			tri = 4*p - 4
+		} else if p >= 0.25 {
+			tri = 2 - 4*p
+		}
+		return math.Max(-1, math.Min(1, tri*trapezoidSlope))
+	}
+	return math.Sin(2 * math.Pi * p)
+}
+
+// the number of points used to measure the harmonics of a waveform
+const harmonicPoints = 1024
+
+// generate a single cycle of a tone in the same way as tone() but with only
+// the harmonics of the waveform that are below the Nyquist frequency. the
+// harmonics that tone() would fold back into the audible range are removed.
+// the samples are scaled so that the peak is the volume
+func bandLimitedTone(length int, volume float64, phase float64, waveform Waveform) []float64 {
+	if waveform == WaveformSine {
+		return tone(length, volume, phase, waveform)
+	}
+
+	t := make([]float64, length)
+	for k := 1; 2*k < length; k++ {
+		// the cosine and sine components of the harmonic
+		// the waveform is measured at the middle of each interval so that
+		// the steps of the square wave do not add a cosine component
+		var a, b float64
+		for j := 0; j < harmonicPoints; j++ {
+			p := (float64(j) + 0.5) / harmonicPoints
+			v := shape(waveform, p)
+			a += v * math.Cos(2*math.Pi*float64(k)*p)
+			b += v * math.Sin(2*math.Pi*float64(k)*p)
+		}
+		a *= 2.0 / harmonicPoints
+		b *= 2.0 / harmonicPoints
+
+		for i := range t {
+			x := 2 * math.Pi * float64(k) * (float64(i)/float64(length) + phase)
+			t[i] += a*math.Cos(x) + b*math.Sin(x)
+		}
+	}
+
+	var peak float64
+	for _, v := range t {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak > 0 {
+		for i := range t {
+			t[i] *= volume / peak
+		}
+	}
+	return t
+}
+
+// tones are the samples for a single cycle of each of the tones used in the
+// output. they are the same for every conversion with the same options and can
+// be shared
+type tones struct {
+	start   []float64
+	zeroBit []float64
+	oneBit  []float64
+}
+
+func newTones(opts ConvertOptions) tones {
+	gen := tone
+	if opts.BandLimited {
+		gen = bandLimitedTone
+	}
+	return tones{
+		start:   gen(opts.cycle(startToneCycle), opts.toneVolume(startToneVolume, opts.StartToneVolume), opts.PhaseOffset, opts.Waveform),
+		zeroBit: gen(opts.cycle(zeroToneCycle), opts.toneVolume(zeroToneVolume, opts.ZeroToneVolume), opts.PhaseOffset, opts.Waveform),
+		oneBit:  gen(opts.cycle(oneToneCycle), opts.toneVolume(oneToneVolume, opts.OneToneVolume), opts.PhaseOffset, opts.Waveform),
 	}
 }
 
 // bitPacker writes bytes such that they are represented by tones. the tones are
-// written to the io.Writer specified by the w field
+// written to the generator instance specified by the w field
 type bitPacker struct {
-	w              io.Writer
+	w              *generator
 	hz             uint32
-	zeroBit        bytes.Buffer
-	oneBit         bytes.Buffer
+	zeroBit        []float64
+	oneBit         []float64
 	bytesPerSecond uint32
 }
 
-func newBitPacker(hz uint32, w io.Writer) bitPacker {
+func newBitPacker(hz uint32, w *generator) bitPacker {
 	pck := bitPacker{
 		w:  w,
 		hz: hz,
 	}
 
-	// prepare bytes for zero and one bits
-	tone(&pck.zeroBit, zeroToneCycle, zeroToneVolume)
-	tone(&pck.oneBit, oneToneCycle, oneToneVolume)
+	// samples for zero and one bits
+	pck.zeroBit = w.tones.zeroBit
+	pck.oneBit = w.tones.oneBit
 
 	// bytes per second
-	pck.bytesPerSecond = pck.hz / (zeroToneCycle + oneToneCycle) / 4
+	pck.bytesPerSecond = pck.hz / uint32(len(pck.zeroBit)+len(pck.oneBit)) / 4
 
 	return pck
 }
@@ -67,9 +228,9 @@ func newBitPacker(hz uint32, w io.Writer) bitPacker {
 func (pck bitPacker) writeByte(b byte) {
 	for i := 0; i < 8; i++ {
 		if b&0x80 == 0x80 {
-			pck.w.Write(pck.oneBit.Bytes())
+			pck.w.writeSamples(pck.oneBit)
 		} else {
-			pck.w.Write(pck.zeroBit.Bytes())
+			pck.w.writeSamples(pck.zeroBit)
 		}
 		b <<= 1
 	}
@@ -82,87 +243,536 @@ func (pck bitPacker) writeByteDuration(b byte, duration float64) {
 	}
 }
 
-// wav implements the io.Writer interface
-type wav struct {
-	format   uint16
-	channels uint16
+// generator processes the samples of the tones and passes them to an Encoder
+type generator struct {
+	enc      Encoder
+	channels int
 	hz       uint32
-	depth    uint16
+	tones    tones
+
+	// the rate at which samples are generated. the same as hz unless the
+	// output is being resampled
+	rate float64
+
+	// scales the volume of each sample by its position in seconds. can be nil
+	envelope func(float64) float64
+
+	// source of all random numbers used in the output
+	rand *rand.Rand
+
+	// the size of the dither noise added to each sample. zero if there is
+	// no dither
+	dither float64
+
+	// cue points are passed to the encoder if cues is true and the encoder
+	// implements the cueEncoder interface
+	cues bool
+
+	// the channels of a stereo output that carry the tones
+	channelMode ChannelMode
+
+	// delay line for the second channel of a stereo output
+	delay     []float64
+	delayHead int
+
+	// the ratio of the rate at which samples are generated to the rate of
+	// the output. if zero the samples are not resampled
+	resampleStep float64
+
+	// the position of the next output sample, as a fraction of the distance
+	// between resamplePrev and the next generated sample
+	resamplePos  float64
+	resamplePrev float64
 
-	data bytes.Buffer
+	// the number of frames written so far
+	samples int
+
+	// called after each data packet. can be nil
+	progress      func(int, int)
+	progressCount int
+	progressTotal int
+
+	// checked after each data packet. can be nil
+	ctx context.Context
+
+	// the first error returned by the encoder, or by the self check. no more
+	// samples are passed to the encoder once an error has occurred
+	err error
+
+	// the self check of the current load for the SelfCheck option. nil if
+	// the self check is not being made
+	check *selfChecker
+
+	// reusable buffers for the resampled frames and the interleaved output
+	frames []float64
+	out    []float64
 }
 
-func (wav *wav) Write(p []byte) (n int, err error) {
-	n = 0
-	for _, b := range p {
-		for c := 0; c < int(wav.channels); c++ {
-			n++
-			wav.data.WriteByte(b)
+// writeSamples adds samples in the range -1 to +1 to the output. if the output
+// is being resampled the samples are at the generation rate and not the rate
+// of the output
+func (g *generator) writeSamples(samples []float64) {
+	if g.check != nil {
+		g.check.add(samples)
+	}
+
+	if g.resampleStep == 0 {
+		g.writeFrames(samples)
+		return
+	}
+
+	// linear interpolation between each pair of generated samples
+	g.frames = g.frames[:0]
+	for _, s := range samples {
+		for g.resamplePos < 1 {
+			g.frames = append(g.frames, g.resamplePrev+(s-g.resamplePrev)*g.resamplePos)
+			g.resamplePos += g.resampleStep
 		}
+		g.resamplePos--
+		g.resamplePrev = s
 	}
-	return n, nil
+	g.writeFrames(g.frames)
 }
 
-func (wav wav) Bytes() []byte {
-	var w bytes.Buffer
+// writeFrames adds samples at the rate of the output
+func (g *generator) writeFrames(samples []float64) {
+	if g.err != nil {
+		return
+	}
 
-	// prepare format sub-chunk
-	var fmtSubChunk bytes.Buffer
-	fmtSubChunk.Write([]byte{byte(wav.format), byte(wav.format >> 8)})
-	fmtSubChunk.Write([]byte{byte(wav.channels), byte(wav.channels >> 8)})
-	fmtSubChunk.Write([]byte{byte(wav.hz), byte(wav.hz >> 8), byte(wav.hz >> 16), byte(wav.hz >> 24)})
-	fmtSubChunk.Write([]byte{byte(wav.hz), byte(wav.hz >> 8), byte(wav.hz >> 16), byte(wav.hz >> 24)})
-	blockAlign := wav.channels & wav.depth
-	fmtSubChunk.Write([]byte{byte(blockAlign), byte(blockAlign >> 8)})
-	fmtSubChunk.Write([]byte{byte(wav.depth), byte(wav.depth >> 8)})
+	g.out = g.out[:0]
+	for _, s := range samples {
+		if g.envelope != nil {
+			e := g.envelope(float64(g.samples) / float64(g.hz))
+			s *= math.Max(0, math.Min(1, e))
+		}
 
-	// prepare wave chunk using format and data sub-chunks
-	var waveChunk bytes.Buffer
-	waveChunk.Write([]byte("WAVE"))
-	waveChunk.Write([]byte("fmt "))
-	l := fmtSubChunk.Len()
-	waveChunk.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
-	waveChunk.Write(fmtSubChunk.Bytes())
-	waveChunk.Write([]byte("data"))
-	l = wav.data.Len()
-	waveChunk.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
-	waveChunk.Write(wav.data.Bytes())
+		// the dither is scaled to the size of a single step in the output
+		if g.dither > 0 {
+			s += (g.rand.Float64() - g.rand.Float64()) * g.dither
+		}
 
-	// write RIFF header followed by wave chunk size and data
-	w.Write([]byte("RIFF"))
-	l = waveChunk.Len()
-	w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
-	w.Write(waveChunk.Bytes())
+		if g.channels > 1 {
+			left, right := s, s
+			if len(g.delay) > 0 {
+				right, g.delay[g.delayHead] = g.delay[g.delayHead], s
+				g.delayHead = (g.delayHead + 1) % len(g.delay)
+			}
+			switch g.channelMode {
+			case ChannelLeft:
+				right = 0
+			case ChannelRight:
+				left = 0
+			}
+			g.out = append(g.out, left, right)
+		} else {
+			g.out = append(g.out, s)
+		}
+		g.samples++
+	}
+
+	g.err = g.enc.WriteSamples(g.out)
+}
+
+// cue marks the current position in the output
+func (g *generator) cue() {
+	if p, ok := g.enc.(packetEncoder); ok {
+		p.packet(g.samples)
+	}
+	if !g.cues {
+		return
+	}
+	if c, ok := g.enc.(cueEncoder); ok {
+		c.cue(g.samples)
+	}
+}
 
-	return w.Bytes()
+// packetDone reports the progress of the conversion after a data packet has
+// been written
+func (g *generator) packetDone() {
+	g.progressCount++
+	if g.progress != nil {
+		g.progress(g.progressCount, g.progressTotal)
+	}
+
+	// a cancelled conversion stops in the same way as a conversion with an
+	// error from the encoder
+	if g.ctx != nil && g.err == nil {
+		g.err = g.ctx.Err()
+	}
 }
 
-// Convert a ROM to a WAV suitable for loading on a Supercharger
+// flush writes silence until the delay line of the second channel is empty
+func (g *generator) flush() {
+	g.writeSilence(len(g.delay))
+}
+
+// the number of frames of silence written at a time by writeSilence
+const silenceChunk = 4096
+
+// writeSilence writes the number of frames of silence at the rate of the
+// output. long silences are written in small pieces so that a large buffer is
+// not required
+func (g *generator) writeSilence(frames int) {
+	silence := make([]float64, silenceChunk)
+	for frames > 0 {
+		n := frames
+		if n > silenceChunk {
+			n = silenceChunk
+		}
+		g.writeFrames(silence[:n])
+		frames -= n
+	}
+}
+
+// Convert a ROM to a WAV suitable for loading on a Supercharger. The details of
+// the conversion are written to the logger
 func Convert(rom []byte, w io.Writer, logger io.Writer) error {
-	// write wav header
-	wav := wav{
-		format:   1,
-		channels: 1,
-		hz:       sampleRate,
-		depth:    8,
+	rep, err := ConvertWithOptions(rom, w, Default())
+	if err != nil {
+		return err
+	}
+	logger.Write([]byte(rep.String()))
+	return nil
+}
+
+// ConvertContext is the same as ConvertWithOptions except that the conversion
+// stops if the context is done. It is the same as setting the Context field of
+// the options
+func ConvertContext(ctx context.Context, rom []byte, w io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	opts.Context = ctx
+	return ConvertWithOptions(rom, w, opts)
+}
+
+// ConvertWithOptions is the same as Convert but with the conversion changed by
+// the ConvertOptions argument. The details of the conversion are returned as a
+// ConvertReport
+func ConvertWithOptions(rom []byte, w io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	return ConvertEncoder(rom, NewWAVEncoder(w), opts)
+}
+
+// ConvertEncoder is the same as ConvertWithOptions except that the output is
+// written by the Encoder. The Encoder is finalized if the conversion succeeds
+func ConvertEncoder(rom []byte, enc Encoder, opts ConvertOptions) (ConvertReport, error) {
+	reps, err := convertLoads([][]byte{rom}, enc, opts, newTones(opts))
+	if err != nil {
+		return ConvertReport{}, err
+	}
+	return reps[0], nil
+}
+
+// ConvertToPlayer is the same as ConvertWithOptions except that the PCM samples
+// are written to the player without a WAV header. The samples are the same as
+// the samples in the data chunk of the WAV file: unsigned 8 bit values, or
+// signed 16 bit little-endian values if the BitDepth option is 16, with
+// interleaved channels if the output is stereo. The samples are written to the
+// player as they are generated
+//
+// The player can be any io.Writer. It is the caller's responsibility to connect
+// the player to an audio device
+func ConvertToPlayer(rom []byte, player io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	return ConvertEncoder(rom, NewRawEncoder(player), opts)
+}
+
+// ConvertSplit is the same as ConvertWithOptions except that each channel of a
+// stereo conversion is written as a separate mono WAV. It is an error if the
+// options do not specify stereo output
+func ConvertSplit(rom []byte, left io.Writer, right io.Writer, opts ConvertOptions) (ConvertReport, error) {
+	if opts.channels() != 2 {
+		return ConvertReport{}, fmt.Errorf("split: output is not stereo")
+	}
+	enc := &splitEncoder{
+		left:  NewWAVEncoder(left),
+		right: NewWAVEncoder(right),
 	}
+	return ConvertEncoder(rom, enc, opts)
+}
 
+// ConvertMultiload converts several loads of a multiload game into a single
+// WAV. Each load is written in turn, complete with its own start tone and
+// calibration tone. The multiload index in the header of each load is one more
+// than the previous load, starting with the Multiload field of the options
+//
+// The details of each load are returned in a ConvertReport, in the same order
+// as the loads
+func ConvertMultiload(loads [][]byte, w io.Writer, opts ConvertOptions) ([]ConvertReport, error) {
+	return ConvertMultiloadEncoder(loads, NewWAVEncoder(w), opts)
+}
+
+// ConvertMultiloadEncoder is the same as ConvertMultiload except that the output
+// is written by the Encoder. The Encoder is finalized if the conversion
+// succeeds
+func ConvertMultiloadEncoder(loads [][]byte, enc Encoder, opts ConvertOptions) ([]ConvertReport, error) {
+	return convertLoads(loads, enc, opts, newTones(opts))
+}
+
+// convert one or more loads and write the output to the Encoder, using the
+// samples in the tones argument
+func convertLoads(loads [][]byte, enc Encoder, opts ConvertOptions, t tones) ([]ConvertReport, error) {
+	opts = opts.WithProfile()
+	streams, reps, err := buildStreams(loads, opts)
+	if err != nil {
+		return nil, err
+	}
+	return convertStreams(streams, reps, enc, opts, t)
+}
+
+// buildStreams validates the options and builds the streams for all loads
+// before any tones are generated. the device profile should already have been
+// applied to the options
+func buildStreams(loads [][]byte, opts ConvertOptions) ([][]byte, []ConvertReport, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(loads) == 0 {
+		return nil, nil, fmt.Errorf("no loads to convert")
+	}
+	if int(opts.Multiload)+len(loads) > 256 {
+		return nil, nil, fmt.Errorf("multiload: too many loads for a starting index of %d", opts.Multiload)
+	}
+
+	var streams [][]byte
+	var reps []ConvertReport
+	for i, rom := range loads {
+		o := opts
+		o.Multiload = opts.Multiload + byte(i)
+		stream, rep, err := BuildStream(rom, o)
+		if err != nil {
+			if len(loads) > 1 {
+				return nil, nil, fmt.Errorf("load %d: %w", i, err)
+			}
+			return nil, nil, err
+		}
+		streams = append(streams, stream)
+		reps = append(reps, rep)
+	}
+
+	return streams, reps, nil
+}
+
+// convert the streams of one or more loads and write the output to the
+// Encoder. the streams should have been created by BuildStream(), or in the
+// same format, and the reports should describe the streams. the options must
+// have been validated
+func convertStreams(streams [][]byte, reps []ConvertReport, enc Encoder, opts ConvertOptions, t tones) ([]ConvertReport, error) {
+	// measure the length of the output before creating it
+	_, isWAV := enc.(*wav)
+	limit := isWAV && opts.MaxOutputBytes > 0
+	s, sized := enc.(sizedEncoder)
+	if limit || sized {
+		m, err := measureStreams(streams, opts, t)
+		if err != nil {
+			return nil, err
+		}
+		if limit {
+			size := m.wavSize(opts)
+			if size > opts.MaxOutputBytes {
+				return nil, fmt.Errorf("%w (%d bytes, limit %d)", OutputTooLarge, size, opts.MaxOutputBytes)
+			}
+		}
+
+		// an encoder that knows the length of the output in advance
+		// does not need to keep the samples
+		if sized {
+			s.expect(m.frames, m.cues)
+		}
+	}
+
+	g := generator{
+		enc:      enc,
+		channels: opts.channels(),
+		hz:       opts.sampleRate(),
+		rate:     opts.generationRate(),
+		tones:    t,
+		envelope: opts.AmplitudeEnvelope,
+		cues:     opts.CueChunk,
+	}
+
+	// the same seed is used for every load
+	seed := opts.seed()
+	g.rand = rand.New(rand.NewSource(seed))
+	g.dither = opts.ditherStep()
+	g.channelMode = opts.ChannelMode
+	for i := range reps {
+		reps[i].Seed = seed
+	}
+
+	// the first output sample is at the same position as the first generated
+	// sample
+	if opts.ResampleTo > 0 {
+		g.resampleStep = g.rate / float64(opts.ResampleTo)
+		g.resamplePos = 1
+	}
+
+	// the delay line for the second channel starts off as silence
+	if opts.ChannelDelaySamples > 0 {
+		g.delay = make([]float64, opts.ChannelDelaySamples)
+	}
+
+	err := enc.WriteHeader(opts.format())
+	if err != nil {
+		return nil, err
+	}
+
+	// the progress is reported over all loads
+	for _, stream := range streams {
+		g.progressTotal += (len(stream) - 8) / 258
+	}
+	g.progress = opts.Progress
+	g.ctx = opts.Context
+
+	for i, stream := range streams {
+		start := g.samples
+		if i == 0 {
+			writeLabelBeeps(&g, opts.LabelBeeps, opts.PhaseOffset)
+		} else if opts.MultiloadSilenceSeconds > 0 {
+			g.writeSamples(make([]float64, int(math.Round(opts.MultiloadSilenceSeconds*g.rate))))
+		}
+		reps[i].Offset = float64(g.samples) / float64(g.hz)
+		reps[i].OffsetSamples = g.samples
+		if opts.StageMarker {
+			writeStageMarker(&g)
+		}
+		writeLoad(&g, stream, opts)
+		if g.err != nil {
+			break
+		}
+
+		// the end of the output is included in the duration of the final load
+		if i == len(streams)-1 {
+			g.flush()
+
+			silence := opts.TrailingSilenceSeconds
+			if silence > largeSilenceSeconds {
+				reps[i].Warnings = append(reps[i].Warnings, fmt.Sprintf("trailing silence is very long (%.1f seconds)", silence))
+			}
+			if opts.MaxTrailingSilenceSeconds > 0 && silence > opts.MaxTrailingSilenceSeconds {
+				reps[i].Warnings = append(reps[i].Warnings, fmt.Sprintf("trailing silence shortened to %.1f seconds", opts.MaxTrailingSilenceSeconds))
+				silence = opts.MaxTrailingSilenceSeconds
+			}
+			g.writeSilence(int(math.Round(silence * float64(g.hz))))
+
+			if opts.AppendResyncMarker {
+				writeResyncMarker(&g)
+				g.flush()
+			}
+
+			// pad with silence so that the output is the requested length
+			if opts.PadToSeconds > 0 {
+				n := int(math.Round(opts.PadToSeconds * float64(g.hz)))
+				if g.samples > n {
+					return nil, fmt.Errorf("pad to seconds: content is already %.3f seconds", float64(g.samples)/float64(g.hz))
+				}
+				g.writeSilence(n - g.samples)
+			}
+		}
+
+		reps[i].Duration = float64(g.samples-start) / float64(g.hz)
+		reps[i].SampleRate = g.hz
+		reps[i].Channels = g.channels
+		reps[i].BitDepth = opts.bitDepth()
+		if opts.Float32 {
+			reps[i].BitDepth = 32
+		}
+	}
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	err = enc.Finalize()
+	if err != nil {
+		return nil, err
+	}
+
+	return reps, nil
+}
+
+// EstimateSize returns the size in bytes of the WAV file that would be created
+// by ConvertWithOptions. The size is worked out from the lengths of the tones
+// and no audio is generated
+func EstimateSize(rom []byte, opts ConvertOptions) (int64, error) {
+	return estimateSize([][]byte{rom}, opts, newTones(opts))
+}
+
+// estimateSize returns the size of the wav that would be created for the loads
+func estimateSize(loads [][]byte, opts ConvertOptions, t tones) (int64, error) {
+	opts = opts.WithProfile()
+	streams, _, err := buildStreams(loads, opts)
+	if err != nil {
+		return 0, err
+	}
+	m, err := measureStreams(streams, opts, t)
+	if err != nil {
+		return 0, err
+	}
+	return m.wavSize(opts), nil
+}
+
+// writeStageMarker writes the silence and start tone that mark the beginning of
+// a load
+func writeStageMarker(g *generator) {
+	g.writeSamples(make([]float64, int(stageMarkerSilenceSeconds*g.rate)))
+	ct := stageMarkerToneSeconds * g.rate / float64(len(g.tones.start))
+	for i := 0; i < int(ct); i++ {
+		g.writeSamples(g.tones.start)
+	}
+}
+
+// writeLabelBeeps writes the beeps requested by the LabelBeeps option
+func writeLabelBeeps(g *generator, count int, phase float64) {
+	if count == 0 {
+		return
+	}
+	// the beeps are for listeners and are always sine waves
+	beep := tone(cycleLength(labelBeepCycle, g.rate), labelBeepVolume, phase, WaveformSine)
+	ct := labelBeepSeconds * g.rate / float64(len(beep))
+	for n := 0; n < count; n++ {
+		for i := 0; i < int(ct); i++ {
+			g.writeSamples(beep)
+		}
+		g.writeSamples(make([]float64, int(labelSilenceSeconds*g.rate)))
+	}
+}
+
+// writeResyncMarker writes the start tone and calibration bytes that are added to
+// the end of the output by the AppendResyncMarker option
+func writeResyncMarker(g *generator) {
+	ct := resyncToneSeconds * g.rate / float64(len(g.tones.start))
+	for i := 0; i < int(ct); i++ {
+		g.writeSamples(g.tones.start)
+	}
+	pck := newBitPacker(uint32(g.rate), g)
+	pck.writeByteDuration(0x55, resyncCalibrationSeconds)
+}
+
+// writeLoad writes the tones for a single load to the generator. the stream
+// should have been created by BuildStream()
+func writeLoad(g *generator, stream []byte, opts ConvertOptions) {
 	// 1) comments in quotation marks are from the sctech.txt document
 	// 2) double asterisks are used to additional commentary on the content of
 	//    sctech.txt
 
+	// the self check decodes the samples of this load only
+	if opts.SelfCheck {
+		g.check = newSelfChecker()
+		defer func() {
+			g.check = nil
+		}()
+	}
+
 	// "Supercharger tapes start with a lower frequency start tone, but it's
 	// not used by the tape decoder"
-	var start bytes.Buffer
-	tone(&start, startToneCycle, startToneVolume)
-	ct := startToneSeconds * sampleRate / startToneCycle
+	ct := toneSeconds(opts.StartToneSeconds, startToneSeconds) * g.rate / float64(len(g.tones.start))
 	for i := 0; i < int(ct); i++ {
-		wav.Write(start.Bytes())
+		g.writeSamples(g.tones.start)
 	}
 
 	// everything written after the start tone is written by the bit packer. use
-	// the wav instance as the io.Writer for the bit packer
-	pck := newBitPacker(sampleRate, &wav)
+	// the generator as the destination for the bit packer
+	pck := newBitPacker(uint32(g.rate), g)
 
 	// "A pattern of alternating one's and zero's (byte value of $AA), with a
 	// recommended minimum length of 256 bytes, allows the Supercharger to
@@ -174,92 +784,40 @@ func Convert(rom []byte, w io.Writer, logger io.Writer) error {
 	//
 	// * this part of sctech.txt seems to be wrong. makewav prefers to use 0x55
 	// and 0x54 for this part of the data
-	pck.writeByteDuration(0x55, headerToneSeconds)
+	pck.writeByteDuration(0x55, toneSeconds(opts.HeaderToneSeconds, headerToneSeconds))
 	pck.writeByte(0x54)
 
-	// "An 8 byte header packet follows [...]
-	//
-	// The header indicates the starting point of execution, how many packets
-	// of game data, the bank switching configuration for the game, how
-	// quickly to scroll inward the blue progress bars, and a checksum"
-	// Its format is:
-	// - Low order byte of the address to start executing the game's startup code
-	// - High order byte of same
-	// - Bank configuration as noted below
-	// - Block count (number of 256 byte program data packets)
-	// - Checksum: computed like game data checksums.  Sum of whole header is $55.
-	// - Multiload index #.  Set to 0 for first or only load of the game.
-	//   Each new multiload game was assigned new numbers sequentially so that
-	//   no other multiload stage from another game would be accidentally
-	//   loaded
-	// - (Low, high) 16 bit speed value for progress bars.  $224 is perfect
-	//   for a 6K game image.  $16D is right for 4K, and $00B6 is right for 2K
-	//   game images"
-
-	addressLow := rom[len(rom)-4]
-	addressHigh := rom[len(rom)-3]
-	bankConfig := byte(0x1d)
-	blockCount := byte(len(rom) / 256)
-	multiload := byte(0)
-	progressSpeedLow := byte(0xc3)
-	progressSpeedHigh := byte(0x01)
-
-	// calculate checksum
-	checksum := 0x55 - addressLow - addressHigh - bankConfig - blockCount - multiload - progressSpeedLow - progressSpeedHigh
-
-	logger.Write([]byte(fmt.Sprintf("\taddress: %02x%02x\n", addressHigh, addressLow)))
-	logger.Write([]byte(fmt.Sprintf("\tbank config: %02x\n", bankConfig)))
-	logger.Write([]byte(fmt.Sprintf("\tblock count: %02x\n", blockCount)))
-	logger.Write([]byte(fmt.Sprintf("\tmultiload: %02x\n", multiload)))
-	logger.Write([]byte(fmt.Sprintf("\tload speed: %02x%02x\n", progressSpeedHigh, progressSpeedLow)))
-	logger.Write([]byte(fmt.Sprintf("\tchecksum: %02x\n", checksum)))
-
-	pck.writeByte(addressLow)
-	pck.writeByte(addressHigh)
-	pck.writeByte(bankConfig)
-	pck.writeByte(blockCount)
-	pck.writeByte(checksum)
-	pck.writeByte(multiload)
-	pck.writeByte(progressSpeedLow)
-	pck.writeByte(progressSpeedHigh)
-
-	// "The game data
-	// -------------
-	// For each 256 bytes of data in the game, a packet is written consisting
-	// of a block number that encodes the address page offset * 4 plus the
-	// bank number, and a checksum that encompasses all 256 bytes of data plus
-	// the block number as written to tape.  The data then follows in normal
-	// linear fashion, all 256 bytes being written.
-
-	// The checksum is calculated by adding all checksummed data, ignoring
-	// carries or overflows.  The value [$55 minus the sum], again ignoring
-	// carries and underflows, is the checksum to write to tape.  Hence, the
-	// sum of the whole data packet including the checksum byte itself will
-	// be $55"
-	for block := byte(0); block < blockCount; block++ {
-		page := (block * 4) + 1
-		if page > 0x1f {
-			page -= 0x1f
-		}
-
-		// checksum
-		checksum := byte(0x55)
-		checksum -= byte(page)
-		s := int(block) * 256
-		for _, b := range rom[s : s+256] {
-			checksum -= b
-		}
-		logger.Write([]byte(fmt.Sprintf("\tblock %d: checksum %02x\n", block, checksum)))
-
-		// write block number
-		pck.writeByte(page)
-
-		// write checksum
-		pck.writeByte(checksum)
-
-		// write block data
-		s = int(block) * 256
-		for _, b := range rom[s : s+256] {
+	// the header and data packets. see BuildStream() for details
+	for i, b := range stream {
+		if i >= 8 && (i-8)%258 == 0 {
+			g.cue()
+		}
+		pck.writeByte(b)
+
+		// the previous packet is checked once the first byte of the next
+		// packet has been written
+		if i >= 8 && (i-8)%258 == 0 {
+			g.selfCheck(stream[:i])
+		}
+		if i >= 8 && (i-8)%258 == 257 {
+			g.packetDone()
+			if g.err != nil {
+				return
+			}
+		}
+
+		// the silence after the header is written at the generation rate
+		// so that it is resampled along with the tones
+		if i == 7 && opts.PostHeaderSilenceSeconds > 0 {
+			g.writeSamples(make([]float64, int(math.Round(opts.PostHeaderSilenceSeconds*g.rate))))
+		}
+	}
+
+	// the repeated header is the first eight bytes of the stream
+	if opts.RepeatHeader {
+		pck.writeByteDuration(0x55, toneSeconds(opts.HeaderToneSeconds, headerToneSeconds))
+		pck.writeByte(0x54)
+		for _, b := range stream[:8] {
 			pck.writeByte(b)
 		}
 	}
@@ -267,10 +825,105 @@ func Convert(rom []byte, w io.Writer, logger io.Writer) error {
 	// "It's recommended you write a byte of 0's and some silence after the
 	// last data packet in order to avoid glitching the audio system of your
 	// tape deck and ruining the last data packet while recording"
-	pck.writeByteDuration(0x00, endToneSeconds)
+	pck.writeByteDuration(0x00, toneSeconds(opts.EndToneSeconds, endToneSeconds))
 
-	// write wav bytes
-	w.Write(wav.Bytes())
+	g.selfCheck(stream)
+}
 
-	return nil
+// selfChecker decodes the samples of a load as they are generated. the cycles
+// of the samples are measured as the samples arrive so that each call to
+// selfCheck() only decodes the bytes that have not already been checked
+type selfChecker struct {
+	// the cycles measured between the rising and falling zero crossings. the
+	// falling crossings are measured in the same way as the rising crossings
+	// of the inverted samples. see tapeCycles()
+	rising  cycleDetector
+	falling cycleDetector
+
+	// the detector chosen once the calibration tone has been seen. nil until
+	// then. the samples are inverted for the chosen detector if it is the
+	// falling detector
+	chosen *cycleDetector
+	invert bool
+
+	// reads the cycles of the chosen detector
+	reader tapeReader
+
+	// the number of bytes of the stream that have been checked
+	checked int
+}
+
+func newSelfChecker() *selfChecker {
+	return &selfChecker{
+		rising:  newCycleDetector(),
+		falling: newCycleDetector(),
+	}
+}
+
+// add samples at the generation rate
+func (c *selfChecker) add(samples []float64) {
+	if c.chosen != nil {
+		for _, s := range samples {
+			if c.invert {
+				s = -s
+			}
+			c.chosen.add(s)
+		}
+		return
+	}
+	for _, s := range samples {
+		c.rising.add(s)
+		c.falling.add(-s)
+	}
+}
+
+// selfCheck decodes the samples of the current load and compares the decoded
+// bytes with the start of the stream. the comparison should be made once the last
+// packet in the data has been followed by at least one more tone cycle, because
+// the length of a cycle is only known once the next cycle has started
+//
+// only the bytes after those compared by the previous call are decoded
+//
+// a mismatch sets the error of the generator and stops any more output
+func (g *generator) selfCheck(data []byte) {
+	if g.check == nil || g.err != nil {
+		return
+	}
+	c := g.check
+
+	// the choice between the rising and falling crossings is the same as the
+	// choice made by tapeCycles()
+	if c.chosen == nil {
+		_, zr, or, errr := calibrate(c.rising.periods, 0)
+		_, zf, of, errf := calibrate(c.falling.periods, 0)
+		if errf == nil && (errr != nil || of/zf > or/zr) {
+			c.chosen = &c.falling
+			c.invert = true
+		} else {
+			c.chosen = &c.rising
+		}
+		c.reader.periods = c.chosen.periods
+		err := c.reader.sync()
+		if err != nil {
+			g.err = fmt.Errorf("%w (%v)", SelfCheckFailed, err)
+			return
+		}
+	}
+	c.reader.periods = c.chosen.periods
+
+	for i := c.checked; i < len(data); i++ {
+		d, err := c.reader.readByte()
+		if err == nil && d != data[i] {
+			err = fmt.Errorf("decoded %02x but expected %02x", d, data[i])
+		}
+		if err != nil {
+			if i < 8 {
+				g.err = fmt.Errorf("%w (header: %v)", SelfCheckFailed, err)
+			} else {
+				g.err = fmt.Errorf("%w (block %d: %v)", SelfCheckFailed, (i-8)/258, err)
+			}
+			return
+		}
+	}
+	c.checked = len(data)
 }
@@ -0,0 +1,836 @@
+package supercharge
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testROM returns a ROM of the size with pseudo-random content and a reset
+// vector of $F000. the content is the same for every call with the same size
+func testROM(size int) []byte {
+	rom := make([]byte, size)
+	x := uint32(size)
+	for i := range rom {
+		x = x*1664525 + 1013904223
+		rom[i] = byte(x >> 24)
+	}
+	rom[size-4] = 0x00
+	rom[size-3] = 0xf0
+	return rom
+}
+
+// bufferedWAV is a WAV encoder that does not implement sizedEncoder, so the
+// samples are kept until the encoder is finalized
+type bufferedWAV struct {
+	wav *wav
+}
+
+func (enc bufferedWAV) WriteHeader(f Format) error           { return enc.wav.WriteHeader(f) }
+func (enc bufferedWAV) WriteSamples(samples []float64) error { return enc.wav.WriteSamples(samples) }
+func (enc bufferedWAV) Finalize() error                      { return enc.wav.Finalize() }
+func (enc bufferedWAV) cue(frame int)                        { enc.wav.cue(frame) }
+
+// countingEncoder counts the frames written to it
+type countingEncoder struct {
+	channels int
+	frames   int
+	cues     int
+}
+
+func (enc *countingEncoder) WriteHeader(f Format) error {
+	enc.channels = f.Channels
+	return nil
+}
+
+func (enc *countingEncoder) WriteSamples(samples []float64) error {
+	enc.frames += len(samples) / enc.channels
+	return nil
+}
+
+func (enc *countingEncoder) Finalize() error { return nil }
+func (enc *countingEncoder) cue(frame int)   { enc.cues++ }
+
+// the options used to compare the streamed and buffered output. each option
+// that changes the length of the output is used at least once
+var measureOptions = []ConvertOptions{
+	{},
+	{CueChunk: true},
+	{Channels: 2, ChannelDelaySamples: 7},
+	{ResampleTo: 48000},
+	{ResampleTo: 22050, SampleRate: 48000, BitDepth: 16},
+	{LabelBeeps: 2, StageMarker: true, PostHeaderSilenceSeconds: 0.1},
+	{RepeatHeader: true, AppendResyncMarker: true, Channels: 2, ChannelDelaySamples: 3},
+	{TrailingSilenceSeconds: 5, MaxTrailingSilenceSeconds: 1.5, ResampleTo: 48000},
+	{PadToSeconds: 20, Float32: true, WAVExtensible: true},
+	{StartToneSeconds: 0.3, HeaderToneSeconds: 0.2, EndToneSeconds: 0.1, CueChunk: true},
+	{DeviceProfile: ProfileVintageDeck},
+	{DeviceProfile: ProfileModernSoundcard, Seed: 3},
+}
+
+func TestMeasure(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range measureOptions {
+		opts := opts.WithProfile()
+		streams, _, err := buildStreams([][]byte{rom, rom}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tones := newTones(opts)
+		m, err := measureStreams(streams, opts, tones)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		enc := &countingEncoder{}
+		_, err = convertStreams(streams, []ConvertReport{{}, {}}, enc, opts, tones)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.frames != enc.frames || (opts.CueChunk && m.cues != enc.cues) {
+			t.Errorf("options %d: measured %d frames and %d cues but %d frames and %d cues were generated", i, m.frames, m.cues, enc.frames, enc.cues)
+		}
+	}
+}
+
+func TestStreamedOutput(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		for i, opts := range measureOptions {
+			var streamed bytes.Buffer
+			_, err := ConvertWithOptions(rom, &streamed, opts)
+			if err != nil {
+				t.Fatalf("%d bytes, options %d: %v", size, i, err)
+			}
+
+			var buffered bytes.Buffer
+			_, err = ConvertEncoder(rom, bufferedWAV{wav: &wav{w: &buffered}}, opts)
+			if err != nil {
+				t.Fatalf("%d bytes, options %d: %v", size, i, err)
+			}
+
+			if sha256.Sum256(streamed.Bytes()) != sha256.Sum256(buffered.Bytes()) {
+				t.Errorf("%d bytes, options %d: streamed output is different to buffered output", size, i)
+			}
+
+			est, err := EstimateSize(rom, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if est != int64(streamed.Len()) {
+				t.Errorf("%d bytes, options %d: estimated size is %d but output is %d bytes", size, i, est, streamed.Len())
+			}
+		}
+	}
+}
+
+// the SHA-256 of the WAV created from testROM(4096) with the default options
+const goldenHash = "2f7455624b62c5fe1bf4c8da33fd6e8e5e39408b254b75b86bafda4e5ce452d6"
+
+func TestGoldenOutput(t *testing.T) {
+	var b bytes.Buffer
+	_, err := ConvertWithOptions(testROM(4096), &b, Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := fmt.Sprintf("%x", sha256.Sum256(b.Bytes()))
+	if h != goldenHash {
+		t.Errorf("output hash is %s but should be %s", h, goldenHash)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	rom := testROM(6144)
+	for _, cancelAt := range []int{1, 5, 23} {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// the number of frames that had been written when the context was
+		// cancelled
+		enc := &countingEncoder{}
+		cancelled := -1
+
+		opts := ConvertOptions{
+			Context: ctx,
+			Progress: func(block int, total int) {
+				if block > cancelAt {
+					t.Errorf("cancelled at block %d: progress reported for block %d", cancelAt, block)
+				}
+				if block == cancelAt {
+					cancelled = enc.frames
+					cancel()
+				}
+			},
+		}
+		_, err := ConvertEncoder(rom, enc, opts)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled at block %d: conversion returned %v", cancelAt, err)
+		}
+		if cancelled < 0 {
+			t.Fatalf("cancelled at block %d: the context was never cancelled", cancelAt)
+		}
+
+		// no more than one block of data is written after cancellation. the
+		// block is 256 bytes of data and two bytes of packet header
+		tones := newTones(opts)
+		bit := len(tones.zeroBit)
+		if len(tones.oneBit) > bit {
+			bit = len(tones.oneBit)
+		}
+		if n := enc.frames - cancelled; n > 258*8*bit {
+			t.Errorf("cancelled at block %d: %d frames were written after cancellation", cancelAt, n)
+		}
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		for i, opts := range measureOptions {
+			var b bytes.Buffer
+			_, err := ConvertWithOptions(rom, &b, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the self check does not change the output
+			var checked bytes.Buffer
+			opts.SelfCheck = true
+			_, err = ConvertWithOptions(rom, &checked, opts)
+			if err != nil {
+				t.Fatalf("%d bytes, options %d: %v", size, i, err)
+			}
+			if !bytes.Equal(b.Bytes(), checked.Bytes()) {
+				t.Errorf("%d bytes, options %d: self check changes the output", size, i)
+			}
+		}
+	}
+}
+
+func TestSelfCheckFault(t *testing.T) {
+	rom := testROM(4096)
+	for _, block := range []int{0, 6, 14} {
+		opts := Default().WithProfile()
+		opts.SelfCheck = true
+		streams, reps, err := buildStreams([][]byte{rom}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the tone of the one bits is corrupted once the block has been
+		// written. the one bits of the next block have no zero crossing
+		tones := newTones(opts)
+		opts.Progress = func(b int, total int) {
+			if b == block+1 {
+				for i := range tones.oneBit {
+					tones.oneBit[i] = 0.5
+				}
+			}
+		}
+
+		_, err = convertStreams(streams, reps, &countingEncoder{}, opts, tones)
+		if !errors.Is(err, SelfCheckFailed) {
+			t.Fatalf("corrupted block %d: conversion returned %v", block+1, err)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("block %d:", block+1)) {
+			t.Errorf("corrupted block %d: error does not name the block: %v", block+1, err)
+		}
+	}
+}
+
+func TestDither(t *testing.T) {
+	rom := testROM(2048)
+	for _, depth := range []int{8, 16} {
+		opts := ConvertOptions{BitDepth: depth}
+		var plain bytes.Buffer
+		_, err := ConvertToPlayer(rom, &plain, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Dither = true
+		opts.Seed = 5
+		var dithered bytes.Buffer
+		_, err = ConvertToPlayer(rom, &dithered, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain.Len() != dithered.Len() {
+			t.Fatalf("%d bits: dither changes the length of the output", depth)
+		}
+
+		// a histogram of the difference made by the dither, in steps of the
+		// output. the dither is never more than a single step
+		hist := make(map[int]int)
+		n := plain.Len() * 8 / depth
+		for i := 0; i < n; i++ {
+			var a, b int
+			if depth == 16 {
+				a = int(int16(binary.LittleEndian.Uint16(plain.Bytes()[i*2:])))
+				b = int(int16(binary.LittleEndian.Uint16(dithered.Bytes()[i*2:])))
+			} else {
+				a = int(plain.Bytes()[i])
+				b = int(dithered.Bytes()[i])
+			}
+			hist[b-a]++
+		}
+		for d, ct := range hist {
+			if d < -1 || d > 1 {
+				t.Errorf("%d bits: dither changes %d samples by %d steps", depth, ct, d)
+			}
+		}
+
+		// the dither is spread in both directions
+		if hist[-1] < n/10 || hist[1] < n/10 {
+			t.Errorf("%d bits: dither changes %d samples down and %d up of %d samples", depth, hist[-1], hist[1], n)
+		}
+
+		// the output is still loadable
+		roundTrip(t, rom, opts)
+	}
+
+	// floating point output is not dithered
+	var plain, dithered bytes.Buffer
+	_, err := ConvertWithOptions(rom, &plain, ConvertOptions{Float32: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ConvertWithOptions(rom, &dithered, ConvertOptions{Float32: true, Dither: true, Seed: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain.Bytes(), dithered.Bytes()) {
+		t.Errorf("dither changes floating point output")
+	}
+}
+
+func TestAmplitudeEnvelope(t *testing.T) {
+	rom := testROM(2048)
+
+	// the envelope ramps up over the first second. it never reaches zero so
+	// that the sign of every sample is kept
+	ramp := func(pos float64) float64 {
+		return 0.1 + 0.9*math.Min(pos, 1)
+	}
+
+	var plain, shaped bytes.Buffer
+	_, err := ConvertWithOptions(rom, &plain, ConvertOptions{Float32: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ConvertWithOptions(rom, &shaped, ConvertOptions{Float32: true, AmplitudeEnvelope: ramp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := wavSamples(t, plain.Bytes())
+	b := wavSamples(t, shaped.Bytes())
+	if len(a) != len(b) {
+		t.Fatalf("envelope changes the length of the output")
+	}
+
+	// the peak level of the first tenth of a second is lower than the peak
+	// level after the first second
+	peak := func(s []float64) float64 {
+		var p float64
+		for _, v := range s {
+			p = math.Max(p, math.Abs(v))
+		}
+		return p
+	}
+	early := peak(b[:4410])
+	late := peak(b[44100:88200])
+	if early >= late/2 {
+		t.Errorf("peak level is %.3f in the first tenth of a second and %.3f after a second", early, late)
+	}
+
+	// the zero crossings are not changed
+	for i := range a {
+		if (a[i] < 0) != (b[i] < 0) || (a[i] == 0) != (b[i] == 0) {
+			t.Fatalf("sample %d is %f without the envelope and %f with it", i, a[i], b[i])
+		}
+	}
+
+	// the tape still loads with the envelope
+	roundTrip(t, rom, ConvertOptions{AmplitudeEnvelope: ramp})
+}
+
+func TestToneFrequencies(t *testing.T) {
+	start, zero, one := ToneFrequencies(ConvertOptions{})
+	if start != 44100.0/51 || zero != 44100.0/6 || one != 44100.0/10 {
+		t.Errorf("default frequencies are %.2f, %.2f and %.2f Hz", start, zero, one)
+	}
+
+	rom := testROM(2048)
+	for i, opts := range []ConvertOptions{
+		{},
+		{SampleRate: 48000},
+		{SampleRate: 22050},
+		{ResampleTo: 48000},
+	} {
+		var b bytes.Buffer
+		_, err := ConvertWithOptions(rom, &b, ConvertOptions{Float32: true, SampleRate: opts.SampleRate, ResampleTo: opts.ResampleTo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples := wavSamples(t, b.Bytes())
+		hz := float64(OutputFormat(opts).SampleRate)
+
+		// the period of each cycle of the output is the distance between
+		// rising zero crossings. the position of each crossing is
+		// interpolated between the samples either side of it
+		var periods []float64
+		last := -1.0
+		for j := 1; j < len(samples); j++ {
+			if samples[j-1] < 0 && samples[j] >= 0 {
+				pos := float64(j-1) + -samples[j-1]/(samples[j]-samples[j-1])
+				if last >= 0 {
+					periods = append(periods, pos-last)
+				}
+				last = pos
+			}
+		}
+
+		// the mean period of the cycles near each tone is the period of the
+		// frequency. the crossings of resampled output are displaced by a
+		// fraction of a sample where cycles of different lengths meet, so
+		// the mean is not as close
+		tolerance := 0.01
+		if opts.ResampleTo > 0 {
+			tolerance = 0.02
+		}
+		start, zero, one := ToneFrequencies(opts)
+		for _, tone := range []struct {
+			name string
+			freq float64
+		}{
+			{"start", start}, {"zero bit", zero}, {"one bit", one},
+		} {
+			expected := hz / tone.freq
+			var total float64
+			var n int
+			for _, p := range periods {
+				if math.Abs(p-expected) < expected*0.05 {
+					total += p
+					n++
+				}
+			}
+			if n < 50 {
+				t.Fatalf("options %d: %d cycles of the %s tone", i, n, tone.name)
+			}
+			if mean := total / float64(n); math.Abs(mean-expected) > expected*tolerance {
+				t.Errorf("options %d: %s tone period is %.3f samples but should be %.3f", i, tone.name, mean, expected)
+			}
+		}
+	}
+}
+
+func TestPadToSeconds(t *testing.T) {
+	rom := testROM(4096)
+	for i, opts := range []ConvertOptions{
+		{PadToSeconds: 20},
+		{PadToSeconds: 12.5, ResampleTo: 48000},
+		{PadToSeconds: 15, Channels: 2, BitDepth: 16, ChannelDelaySamples: 4},
+	} {
+		w := roundTrip(t, rom, opts)
+
+		// the output is the padded length, to the nearest frame
+		format := OutputFormat(opts)
+		frames := len(wavChunk(t, w, "data")) / (format.Channels * format.BitDepth / 8)
+		expected := int(math.Round(opts.PadToSeconds * float64(format.SampleRate)))
+		if frames != expected {
+			t.Errorf("options %d: output is %d frames but should be %d frames", i, frames, expected)
+		}
+	}
+
+	// the padded length can not be shorter than the content
+	var b bytes.Buffer
+	_, err := ConvertWithOptions(rom, &b, ConvertOptions{PadToSeconds: 1})
+	if err == nil {
+		t.Errorf("padding to a length shorter than the content does not return an error")
+	}
+	if b.Len() != 0 {
+		t.Errorf("%d bytes written when the padding failed", b.Len())
+	}
+}
+
+func TestTapeLength(t *testing.T) {
+	for _, tc := range []struct {
+		seconds float64
+		ips     float64
+		feet    float64
+	}{
+		{60, 1.875, 9.375},
+		{1800, 1.875, 281.25},
+		{1800, 3.75, 562.5},
+		{0, 1.875, 0},
+	} {
+		if feet := TapeLength(tc.seconds, tc.ips); math.Abs(feet-tc.feet) > 1e-9 {
+			t.Errorf("%g seconds at %g ips is %g feet but should be %g", tc.seconds, tc.ips, feet, tc.feet)
+		}
+	}
+}
+
+func TestChannelDelay(t *testing.T) {
+	rom := testROM(2048)
+	var mono bytes.Buffer
+	_, err := ConvertWithOptions(rom, &mono, ConvertOptions{BitDepth: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := wavSamples(t, mono.Bytes())
+
+	for _, delay := range []int{0, 1, 7, 100} {
+		opts := ConvertOptions{Channels: 2, BitDepth: 16, ChannelDelaySamples: delay}
+		s := wavSamples(t, roundTrip(t, rom, opts))
+		frames := len(s) / 2
+		if frames != len(m)+delay {
+			t.Fatalf("delay %d: %d frames but should be %d", delay, frames, len(m)+delay)
+		}
+
+		// the left channel is the mono output followed by silence. the right
+		// channel is silence followed by the mono output
+		for i := 0; i < frames; i++ {
+			left, right := s[i*2], s[i*2+1]
+			var l, r float64
+			if i < len(m) {
+				l = m[i]
+			}
+			if i >= delay {
+				r = m[i-delay]
+			}
+			if left != l || right != r {
+				t.Fatalf("delay %d: frame %d is %f, %f but should be %f, %f", delay, i, left, right, l, r)
+			}
+		}
+	}
+}
+
+func TestResampleTo(t *testing.T) {
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		rate uint32
+		to   uint32
+	}{
+		{0, 48000},
+		{0, 22050},
+		{0, 96000},
+		{0, 37800},
+		{48000, 44100},
+	} {
+		opts := ConvertOptions{SampleRate: tc.rate, ResampleTo: tc.to}
+		w := roundTrip(t, rom, opts)
+
+		// the fmt chunk describes the resampled audio
+		f := wavChunk(t, w, "fmt ")
+		hz := binary.LittleEndian.Uint32(f[4:8])
+		byteRate := binary.LittleEndian.Uint32(f[8:12])
+		if hz != tc.to || byteRate != tc.to {
+			t.Errorf("resampled to %dHz: header has a rate of %dHz and %d bytes per second", tc.to, hz, byteRate)
+		}
+
+		// resampling does not change the length of the audio by more than a
+		// frame at the lower of the two rates
+		var b bytes.Buffer
+		_, err := ConvertWithOptions(rom, &b, ConvertOptions{SampleRate: tc.rate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rate := tc.rate
+		if rate == 0 {
+			rate = 44100
+		}
+		original := float64(len(wavChunk(t, b.Bytes(), "data"))) / float64(rate)
+		resampled := float64(len(wavChunk(t, w, "data"))) / float64(hz)
+		if math.Abs(original-resampled) > 1/math.Min(float64(rate), float64(hz)) {
+			t.Errorf("resampled to %dHz: duration is %.6fs but should be %.6fs", tc.to, resampled, original)
+		}
+	}
+}
+
+func TestConvertSplit(t *testing.T) {
+	rom := testROM(4096)
+	for _, opts := range []ConvertOptions{
+		{Channels: 2},
+		{Channels: 2, BitDepth: 16, ChannelDelaySamples: 3},
+		{Channels: 2, ChannelMode: ChannelLeft},
+	} {
+		var stereo bytes.Buffer
+		_, err := ConvertWithOptions(rom, &stereo, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var left, right bytes.Buffer
+		_, err = ConvertSplit(rom, &left, &right, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// each file is the mono WAV of one channel of the stereo output
+		sampleSize := OutputFormat(opts).BitDepth / 8
+		data := wavChunk(t, stereo.Bytes(), "data")
+		var l, r []byte
+		for i := 0; i < len(data); i += sampleSize * 2 {
+			l = append(l, data[i:i+sampleSize]...)
+			r = append(r, data[i+sampleSize:i+sampleSize*2]...)
+		}
+		for _, ch := range []struct {
+			name string
+			wav  []byte
+			data []byte
+		}{
+			{"left", left.Bytes(), l},
+			{"right", right.Bytes(), r},
+		} {
+			if channels := binary.LittleEndian.Uint16(wavChunk(t, ch.wav, "fmt ")[2:4]); channels != 1 {
+				t.Errorf("%+v: %s file has %d channels", opts, ch.name, channels)
+			}
+			if !bytes.Equal(wavChunk(t, ch.wav, "data"), ch.data) {
+				t.Errorf("%+v: %s file is different to the %s channel of the stereo output", opts, ch.name, ch.name)
+			}
+		}
+
+		data, err = Decode(bytes.NewReader(left.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, rom) {
+			t.Errorf("%+v: left file decodes to data different to the ROM", opts)
+		}
+	}
+
+	var left, right bytes.Buffer
+	_, err := ConvertSplit(rom, &left, &right, ConvertOptions{})
+	if err == nil {
+		t.Errorf("mono output should not be split")
+	}
+	if left.Len() != 0 || right.Len() != 0 {
+		t.Errorf("%d and %d bytes written for mono output", left.Len(), right.Len())
+	}
+}
+
+func TestTrailingSilence(t *testing.T) {
+	rom := testROM(4096)
+	for _, tc := range []struct {
+		opts     ConvertOptions
+		seconds  float64
+		warnings int
+	}{
+		{ConvertOptions{TrailingSilenceSeconds: 0.5}, 0.5, 0},
+		{ConvertOptions{TrailingSilenceSeconds: 3.25, Channels: 2, BitDepth: 16}, 3.25, 0},
+		{ConvertOptions{TrailingSilenceSeconds: 2, ResampleTo: 48000}, 2, 0},
+		{ConvertOptions{TrailingSilenceSeconds: 5, MaxTrailingSilenceSeconds: 1.5}, 1.5, 1},
+		{ConvertOptions{TrailingSilenceSeconds: 61}, 61, 1},
+	} {
+		w := roundTrip(t, rom, tc.opts)
+		base := tc.opts
+		base.TrailingSilenceSeconds = 0
+		base.MaxTrailingSilenceSeconds = 0
+		var b bytes.Buffer
+		_, err := ConvertWithOptions(rom, &b, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the output without trailing silence is the start of the output
+		// with it. the rest is silence of the requested length
+		format := OutputFormat(tc.opts)
+		frameSize := format.Channels * format.BitDepth / 8
+		data := wavChunk(t, w, "data")
+		without := wavChunk(t, b.Bytes(), "data")
+		frames := (len(data) - len(without)) / frameSize
+		expected := int(math.Round(tc.seconds * float64(format.SampleRate)))
+		if frames != expected {
+			t.Errorf("%+v: %d frames of trailing silence but there should be %d", tc.opts, frames, expected)
+		}
+		if !bytes.Equal(data[:len(without)], without) {
+			t.Errorf("%+v: output before the silence is different to the output without silence", tc.opts)
+		}
+		silence := wavSamples(t, w)[len(without)/(format.BitDepth/8):]
+		for i, s := range silence {
+			if s != 0 {
+				t.Fatalf("%+v: sample %d of the trailing silence is %f", tc.opts, i, s)
+			}
+		}
+
+		rep, err := ConvertWithOptions(rom, io.Discard, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rep.Warnings) != tc.warnings {
+			t.Errorf("%+v: warnings are %q", tc.opts, rep.Warnings)
+		}
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	rom := testROM(4096)
+	for _, opts := range []ConvertOptions{
+		{},
+		{Channels: 2, BitDepth: 16, CueChunk: true},
+		{ResampleTo: 48000, TrailingSilenceSeconds: 1},
+	} {
+		size := int64(len(roundTrip(t, rom, opts)))
+		estimate, err := EstimateSize(rom, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate != size {
+			t.Errorf("%+v: estimated size is %d but the output is %d bytes", opts, estimate, size)
+		}
+
+		// a limit of exactly the size of the output is allowed
+		opts.MaxOutputBytes = size
+		roundTrip(t, rom, opts)
+
+		// nothing is written if the output would be too large
+		opts.MaxOutputBytes = size - 1
+		var b bytes.Buffer
+		_, err = ConvertWithOptions(rom, &b, opts)
+		if !errors.Is(err, OutputTooLarge) || !strings.Contains(err.Error(), fmt.Sprintf("%d bytes, limit %d", size, size-1)) {
+			t.Errorf("%+v: error is %v", opts, err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("%+v: %d bytes written when the output is too large", opts, b.Len())
+		}
+
+		// the limit only applies to WAV output
+		b.Reset()
+		_, err = ConvertToPlayer(rom, &b, opts)
+		if err != nil {
+			t.Errorf("%+v: raw output: %v", opts, err)
+		}
+	}
+}
+
+func TestPhaseOffset(t *testing.T) {
+	rom := testROM(4096)
+	for _, phase := range []float64{0, 0.1, 0.25, 0.5, 0.75} {
+		opts := ConvertOptions{PhaseOffset: phase, Float32: true}
+		samples := wavSamples(t, roundTrip(t, rom, opts))
+
+		// the tape begins with the first cycle of the start tone
+		for i := 0; i < startToneCycle; i++ {
+			expected := float64(float32(0.98 * math.Sin(2*math.Pi*(float64(i)/startToneCycle+phase))))
+			if math.Abs(samples[i]-expected) > 1e-6 {
+				t.Fatalf("phase %.2f: sample %d is %f but should be %f", phase, i, samples[i], expected)
+			}
+		}
+
+		// every packet begins with the first sample of a cycle
+		rec := &packetRecorder{packets: make(map[int]bool)}
+		_, err := ConvertEncoder(rom, rec, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := float64(float32(0.98 * math.Sin(2*math.Pi*phase)))
+		for p := range rec.packets {
+			if math.Abs(samples[p]-expected) > 1e-6 {
+				t.Errorf("phase %.2f: packet at frame %d starts with %f but should start with %f", phase, p, samples[p], expected)
+			}
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	rom := testROM(4096)
+	convert := func(opts ConvertOptions) ([]byte, ConvertReport) {
+		t.Helper()
+		var b bytes.Buffer
+		rep, err := ConvertWithOptions(rom, &b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes(), rep
+	}
+
+	// conversions with the same seed are identical
+	opts := ConvertOptions{Dither: true, BitDepth: 16, Seed: 42}
+	a, rep := convert(opts)
+	b, _ := convert(opts)
+	if !bytes.Equal(a, b) {
+		t.Errorf("conversions with the same seed are different")
+	}
+	if rep.Seed != 42 {
+		t.Errorf("seed in the report is %d", rep.Seed)
+	}
+	opts.Seed = 43
+	c, _ := convert(opts)
+	if bytes.Equal(a, c) {
+		t.Errorf("conversions with different seeds are the same")
+	}
+
+	// the seed in the report reproduces a conversion with a random seed
+	random, rep := convert(ConvertOptions{Dither: true, BitDepth: 16, RandomSeed: true})
+	again, _ := convert(ConvertOptions{Dither: true, BitDepth: 16, Seed: rep.Seed})
+	if !bytes.Equal(random, again) {
+		t.Errorf("conversion with the reported seed %d is different to the conversion with a random seed", rep.Seed)
+	}
+	roundTrip(t, rom, ConvertOptions{Dither: true, BitDepth: 16, Seed: rep.Seed})
+
+	// the seed has no effect without random options
+	d, _ := convert(ConvertOptions{Seed: 1})
+	e, _ := convert(ConvertOptions{Seed: 2})
+	if !bytes.Equal(d, e) {
+		t.Errorf("seed changes a conversion without dither")
+	}
+}
+
+func TestLabelBeeps(t *testing.T) {
+	rom := testROM(4096)
+	plain := wavSamples(t, roundTrip(t, rom, ConvertOptions{Float32: true}))
+
+	// each beep is a whole number of cycles followed by the silence
+	beep := int(math.Floor(labelBeepSeconds*sampleRate/labelBeepCycle)) * labelBeepCycle
+	silence := int(labelSilenceSeconds * sampleRate)
+
+	for _, count := range []int{1, 3, 5} {
+		samples := wavSamples(t, roundTrip(t, rom, ConvertOptions{Float32: true, LabelBeeps: count}))
+		label := count * (beep + silence)
+		if len(samples) != len(plain)+label {
+			t.Fatalf("%d beeps: %d samples but should be %d", count, len(samples), len(plain)+label)
+		}
+
+		// the beeps are the bursts of sound before the tape. each burst
+		// starts after a run of silence, or at the start of the output
+		bursts, run := 0, silence
+		for _, s := range samples[:label] {
+			if s != 0 {
+				if run >= silence/2 {
+					bursts++
+				}
+				run = 0
+			} else {
+				run++
+			}
+		}
+		if bursts != count {
+			t.Errorf("%d beeps: %d beeps found", count, bursts)
+		}
+		for n := 0; n < count; n++ {
+			for _, s := range samples[n*(beep+silence)+beep : (n+1)*(beep+silence)] {
+				if s != 0 {
+					t.Fatalf("%d beeps: silence after beep %d is not silent", count, n)
+				}
+			}
+		}
+
+		// the tape follows the beeps unchanged
+		for i, s := range samples[label:] {
+			if s != plain[i] {
+				t.Fatalf("%d beeps: sample %d of the tape is different to the tape without beeps", count, i)
+			}
+		}
+	}
+
+	_, err := ConvertWithOptions(rom, io.Discard, ConvertOptions{LabelBeeps: -1})
+	if err == nil {
+		t.Errorf("a negative number of beeps should not be allowed")
+	}
+}
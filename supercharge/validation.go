@@ -1,18 +1,199 @@
 package supercharge
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 )
 
 var UnsupportedSize = errors.New("unsupported size")
+var AlreadyEncoded = errors.New("already encoded")
+var NoBlocks = errors.New("no data blocks")
+var OutputTooLarge = errors.New("output too large")
+var BadEncoding = errors.New("bad encoding")
+var BlankImage = errors.New("blank image")
+var BadStartAddress = errors.New("bad start address")
+
+// the ROM sizes accepted by Validate
+var romSizes = []int{2048, 4096, 6144}
+
+// the largest ROM size accepted by Validate
+const maxROMSize = 6144
 
 // Validate indicates whether the ROM data is compatible with the supercharger. It
-// returns nil if the validation check passes
+// returns nil if the validation check passes. ROMs of 2K, 4K and 6K are
+// supported
+//
+// Each reason for failure is indicated by a different error, which can be
+// tested with errors.Is():
+//
+//   - AlreadyEncoded: the data looks like it has already been prepared for the
+//     Supercharger, such as a WAV file or a Supercharger load image
+//   - UnsupportedSize: the ROM is not one of the supported sizes
+//   - BlankImage: every byte of the ROM is $00 or every byte is $FF
+//   - BadStartAddress: the reset vector does not point into the cartridge
+//     address space ($F000 to $FFFF and its mirrors)
+//
+// A load image can be converted with ConvertLoadImage()
 func Validate(rom []byte) error {
-	if len(rom) != 4096 {
-		return fmt.Errorf("%w (%d)", UnsupportedSize, len(rom))
+	err := validateEncoding(rom)
+	if err != nil {
+		return err
+	}
+	err = validateSize(len(rom))
+	if err != nil {
+		return err
+	}
+	return validateContent(rom)
+}
+
+// validateContent checks the content of a ROM that is a supported size
+func validateContent(rom []byte) error {
+	if bytes.Count(rom, []byte{0x00}) == len(rom) {
+		return fmt.Errorf("%w (every byte is $00)", BlankImage)
+	}
+	if bytes.Count(rom, []byte{0xff}) == len(rom) {
+		return fmt.Errorf("%w (every byte is $FF)", BlankImage)
+	}
+
+	// the 6507 has 13 address lines and the cartridge is selected by A12. the
+	// reset vector must point into the cartridge for the game to start
+	address := uint16(rom[len(rom)-3])<<8 | uint16(rom[len(rom)-4])
+	if address&0x1000 == 0 {
+		return fmt.Errorf("%w (%04x is outside the cartridge address space)", BadStartAddress, address)
+	}
+
+	return nil
+}
+
+// the size of a single load in a Supercharger load image. each load is 8192
+// bytes of data followed by a 256 byte header block. the first eight bytes of
+// the header block are the same as the header packet written to tape
+const loadImageSize = 8448
+
+// validateEncoding checks for data that is not a raw ROM image
+func validateEncoding(rom []byte) error {
+	return checkEncoding(bytes.NewReader(rom), int64(len(rom)))
+}
+
+// readAt fills the buffer from the io.ReaderAt at the offset. an io.ReaderAt
+// may return io.EOF for a read that ends at the end of the data, which is not
+// an error if the buffer was filled
+func readAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if err == io.EOF && n == len(p) {
+		return nil
+	}
+	return err
+}
+
+// checkEncoding is the same as validateEncoding except that the data is read
+// from an io.ReaderAt. only the bytes needed for the check are read
+func checkEncoding(r io.ReaderAt, size int64) error {
+	if size >= 12 {
+		var riff [12]byte
+		err := readAt(r, riff[:], 0)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(riff[0:4], []byte("RIFF")) && bytes.Equal(riff[8:12], []byte("WAVE")) {
+			return fmt.Errorf("%w: input is a WAV file", AlreadyEncoded)
+		}
+	}
+
+	if size > 0 && size%loadImageSize == 0 {
+		var hdr [8]byte
+		err := readAt(r, hdr[:], 8192)
+		if err != nil {
+			return err
+		}
+		var sum byte
+		for _, b := range hdr {
+			sum += b
+		}
+		if sum == 0x55 {
+			return fmt.Errorf("%w: input is a Supercharger load image", AlreadyEncoded)
+		}
 	}
 
 	return nil
 }
+
+// ValidateReader is the same as Validate except that the ROM data is read from
+// an io.Reader. The data is counted but not retained. Reading stops once it is
+// clear that the data is too large to be valid
+//
+// The number of bytes read is returned along with the result of validation
+func ValidateReader(r io.Reader) (int, error) {
+	n, err := io.Copy(io.Discard, io.LimitReader(r, maxROMSize+1))
+	if err != nil {
+		return int(n), err
+	}
+	if n > maxROMSize {
+		return int(n), fmt.Errorf("%w (more than the %d bytes of Supercharger RAM)", UnsupportedSize, maxROMSize)
+	}
+	return int(n), validateSize(int(n))
+}
+
+func validateSize(size int) error {
+	for _, s := range romSizes {
+		if size == s {
+			return nil
+		}
+	}
+	if size > maxROMSize {
+		// an 8K image with F8 bank switching is the most common case. the
+		// Supercharger has 6K of RAM and no equivalent of the F8 hotspots so
+		// the image can not be split across the RAM banks
+		return fmt.Errorf("%w (%d bytes is more than the %d bytes of Supercharger RAM)", UnsupportedSize, size, maxROMSize)
+	}
+	return fmt.Errorf("%w (%d)", UnsupportedSize, size)
+}
+
+// the lengths of footers recognised by TrimROM
+var footerSizes = []int{16, 32, 64, 128, 256}
+
+// TrimROM returns the ROM data with any trailing bytes removed, as specified by
+// the TrimTrailing and TrimFooter fields of the options. The returned slice
+// shares the same underlying data as the rom argument
+//
+// A footer is recognised by the TrimFooter option if the ROM is 16, 32, 64,
+// 128 or 256 bytes longer than a supported size and the extra bytes are either all
+// the same value (padding) or are all printable ASCII characters (a text
+// signature)
+//
+// Validate() does not trim the ROM. The conversion functions call TrimROM()
+// before validating the ROM
+func TrimROM(rom []byte, opts ConvertOptions) ([]byte, error) {
+	if opts.TrimTrailing > len(rom) {
+		return nil, fmt.Errorf("trim: %d bytes is longer than the ROM (%d bytes)", opts.TrimTrailing, len(rom))
+	}
+	rom = rom[:len(rom)-opts.TrimTrailing]
+
+	if opts.TrimFooter {
+		for _, n := range footerSizes {
+			size := len(rom) - n
+			if validateSize(size) == nil && isFooter(rom[size:]) {
+				return rom[:size], nil
+			}
+		}
+	}
+
+	return rom, nil
+}
+
+// isFooter returns true if the data is padding or printable text
+func isFooter(data []byte) bool {
+	padding := true
+	text := true
+	for _, b := range data {
+		if b != data[0] {
+			padding = false
+		}
+		if b < 0x20 || b > 0x7e {
+			text = false
+		}
+	}
+	return padding || text
+}
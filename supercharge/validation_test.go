@@ -0,0 +1,214 @@
+package supercharge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLargeROM(t *testing.T) {
+	// an 8K image is the size of an F8 bank switched cartridge
+	rom := testROM(8192)
+
+	err := Validate(rom)
+	if !errors.Is(err, UnsupportedSize) {
+		t.Fatalf("validation of an 8K ROM returned %v", err)
+	}
+	if !strings.Contains(err.Error(), "Supercharger RAM") {
+		t.Errorf("error for an 8K ROM does not give the reason: %v", err)
+	}
+
+	n, err := ValidateReader(bytes.NewReader(rom))
+	if !errors.Is(err, UnsupportedSize) || !strings.Contains(err.Error(), "Supercharger RAM") {
+		t.Errorf("validation of an 8K ROM from an io.Reader returned %v", err)
+	}
+	if n > maxROMSize+1 {
+		t.Errorf("%d bytes were read from the io.Reader", n)
+	}
+
+	_, err = ConvertWithOptions(rom, io.Discard, ConvertOptions{})
+	if !errors.Is(err, UnsupportedSize) {
+		t.Errorf("conversion of an 8K ROM returned %v", err)
+	}
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// failingReader returns an error after the data
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("test read failure")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestValidateReader(t *testing.T) {
+	for _, size := range romSizes {
+		n, err := ValidateReader(bytes.NewReader(testROM(size)))
+		if err != nil || n != size {
+			t.Errorf("%d bytes: returned %d bytes and %v", size, n, err)
+		}
+	}
+
+	for _, size := range []int{0, 1, 2047, 2049, 3000, 6143} {
+		n, err := ValidateReader(bytes.NewReader(make([]byte, size)))
+		if !errors.Is(err, UnsupportedSize) || n != size {
+			t.Errorf("%d bytes: returned %d bytes and %v", size, n, err)
+		}
+		if err != nil && Validate(make([]byte, size)).Error() != err.Error() {
+			t.Errorf("%d bytes: error is different to Validate: %v", size, err)
+		}
+	}
+
+	// reading stops once the data is larger than any ROM
+	n, err := ValidateReader(zeroReader{})
+	if !errors.Is(err, UnsupportedSize) {
+		t.Errorf("endless reader returned %v", err)
+	}
+	if n != maxROMSize+1 {
+		t.Errorf("%d bytes were read from an endless reader", n)
+	}
+
+	// an error from the reader is returned
+	_, err = ValidateReader(&failingReader{data: testROM(4096)})
+	if err == nil || errors.Is(err, UnsupportedSize) {
+		t.Errorf("failing reader returned %v", err)
+	}
+}
+
+func TestAlreadyEncoded(t *testing.T) {
+	rom := testROM(4096)
+
+	var wav bytes.Buffer
+	_, err := ConvertWithOptions(rom, &wav, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var image bytes.Buffer
+	_, err = ConvertToAR(rom, &image, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a WAV file the size of a ROM is still a WAV file
+	small := append([]byte{}, wav.Bytes()[:12]...)
+	small = append(small, rom[12:]...)
+
+	// the header block of a load image that does not sum to $55
+	notImage := append([]byte{}, image.Bytes()...)
+	notImage[8192] ^= 0xff
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		err    error
+		reason string
+	}{
+		{"ROM", rom, nil, ""},
+		{"WAV file", wav.Bytes(), AlreadyEncoded, "WAV file"},
+		{"ROM sized WAV file", small, AlreadyEncoded, "WAV file"},
+		{"load image", image.Bytes(), AlreadyEncoded, "load image"},
+		{"damaged load image", notImage, UnsupportedSize, ""},
+	} {
+		err := Validate(tc.data)
+		if !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+			t.Errorf("%s: validation returned %v", tc.name, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), tc.reason) {
+			t.Errorf("%s: error does not give the reason: %v", tc.name, err)
+		}
+
+		// the same classification is made from an io.ReaderAt
+		err = checkEncoding(bytes.NewReader(tc.data), int64(len(tc.data)))
+		if tc.err == AlreadyEncoded && !errors.Is(err, AlreadyEncoded) || tc.err != AlreadyEncoded && err != nil {
+			t.Errorf("%s: checkEncoding returned %v", tc.name, err)
+		}
+
+		// the data is not converted
+		if tc.err != nil {
+			_, err = ConvertWithOptions(tc.data, io.Discard, ConvertOptions{})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s: conversion returned %v", tc.name, err)
+			}
+		}
+	}
+}
+
+func TestTrimROM(t *testing.T) {
+	rom := testROM(4096)
+	pad := func(n int, b byte) []byte { return bytes.Repeat([]byte{b}, n) }
+	text := func(n int) []byte { return bytes.Repeat([]byte("(c) 1983 "), n)[:n] }
+	binary := make([]byte, 64)
+	for i := range binary {
+		binary[i] = byte(i * 37)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		extra   []byte
+		opts    ConvertOptions
+		trimmed int
+	}{
+		{"no footer", nil, ConvertOptions{TrimFooter: true}, 4096},
+		{"trailing bytes", pad(100, 0x00), ConvertOptions{TrimTrailing: 100}, 4096},
+		{"padding", pad(64, 0xff), ConvertOptions{TrimFooter: true}, 4096},
+		{"signature", text(128), ConvertOptions{TrimFooter: true}, 4096},
+		{"largest footer", pad(256, 0x00), ConvertOptions{TrimFooter: true}, 4096},
+		{"trailing bytes and footer", append(pad(32, 0xea), pad(10, 0x00)...), ConvertOptions{TrimTrailing: 10, TrimFooter: true}, 4096},
+
+		// footers that are not recognised
+		{"footer not trimmed", pad(64, 0xff), ConvertOptions{}, 4160},
+		{"binary footer", binary, ConvertOptions{TrimFooter: true}, 4160},
+		{"unsupported footer size", pad(48, 0xff), ConvertOptions{TrimFooter: true}, 4144},
+	} {
+		data := append(bytes.Clone(rom), tc.extra...)
+		trimmed, err := TrimROM(data, tc.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(trimmed) != tc.trimmed {
+			t.Errorf("%s: trimmed to %d bytes but should be %d", tc.name, len(trimmed), tc.trimmed)
+			continue
+		}
+		if &trimmed[0] != &data[0] {
+			t.Errorf("%s: trimmed ROM does not share the data of the ROM", tc.name)
+		}
+
+		// the trimmed ROM is converted
+		if tc.trimmed == 4096 {
+			var b bytes.Buffer
+			_, err = ConvertWithOptions(data, &b, tc.opts)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			decoded, err := Decode(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if !bytes.Equal(decoded, rom) {
+				t.Errorf("%s: decoded data is different to the ROM", tc.name)
+			}
+		}
+	}
+
+	_, err := TrimROM(rom, ConvertOptions{TrimTrailing: 4097})
+	if err == nil || !strings.Contains(err.Error(), "4097 bytes is longer than the ROM (4096 bytes)") {
+		t.Errorf("error when trimming more than the ROM is %v", err)
+	}
+}
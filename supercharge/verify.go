@@ -0,0 +1,90 @@
+package supercharge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var BadChecksum = errors.New("bad checksum")
+var SelfCheckFailed = errors.New("self check failed")
+
+// VerifyReport describes the tape read by VerifyWAV
+type VerifyReport struct {
+	// the header packet as read from the tape
+	Header [8]byte `json:"header"`
+
+	// the number of data packets that were read and had a valid checksum
+	Blocks int `json:"blocks"`
+
+	// the measured lengths of the zero and one bit cycles. tone lengths that
+	// vary widely can cause read errors on real hardware
+	ToneStats ToneStats `json:"tone_stats"`
+}
+
+// VerifyWAV reads a WAV file containing a Supercharger tape and checks that the
+// header and every data packet can be read and have valid checksums
+//
+// The VerifyReport is returned even if an error is found. It will describe the
+// tape up to the point of the error
+func VerifyWAV(r io.Reader) (rep VerifyReport, err error) {
+	p, err := readWAV(r)
+	if err != nil {
+		return rep, err
+	}
+
+	t := tapeReader{periods: tapeCycles(p.samples)}
+	defer func() {
+		rep.ToneStats = t.stats
+	}()
+
+	err = t.sync()
+	if err != nil {
+		return rep, fmt.Errorf("verify: %w", err)
+	}
+
+	err = t.read(rep.Header[:])
+	if err != nil {
+		return rep, fmt.Errorf("verify: header: %w", err)
+	}
+	if sum(rep.Header[:]) != 0x55 {
+		return rep, fmt.Errorf("verify: header: %w", BadChecksum)
+	}
+
+	var packet [258]byte
+	for i := 0; i < int(rep.Header[3]); i++ {
+		err = t.read(packet[:])
+		if err != nil {
+			return rep, fmt.Errorf("verify: block %d: %w", i, err)
+		}
+		if sum(packet[:]) != 0x55 {
+			return rep, fmt.Errorf("verify: block %d: %w", i, BadChecksum)
+		}
+		rep.Blocks++
+	}
+
+	return rep, nil
+}
+
+// sum of all bytes ignoring carries. the sum of a valid header or data packet,
+// including the checksum byte, is $55
+func sum(data []byte) byte {
+	var s byte
+	for _, b := range data {
+		s += b
+	}
+	return s
+}
+
+// AssertLoadable returns an error if any load in the WAV data can not be read,
+// or if any header or data packet has a bad checksum. It is intended for use
+// in tests of programs that create Supercharger tapes
+func AssertLoadable(wav []byte) error {
+	_, err := VerifyWAV(bytes.NewReader(wav))
+	if err != nil {
+		return err
+	}
+	_, err = DecodeMultiload(bytes.NewReader(wav))
+	return err
+}
@@ -0,0 +1,168 @@
+package supercharge
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+// roundTrip converts the ROM with the options, checks that the output is
+// loadable and that it decodes to the same data as the ROM. the WAV file is
+// returned so that a test can make further checks of the output
+func roundTrip(t *testing.T, rom []byte, opts ConvertOptions) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	_, err := ConvertWithOptions(rom, &b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AssertLoadable(b.Bytes())
+	if err != nil {
+		t.Fatalf("output is not loadable: %v", err)
+	}
+	data, err := DecodeWithOptions(bytes.NewReader(b.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, rom) {
+		t.Errorf("decoded data is different to the ROM")
+	}
+	return b.Bytes()
+}
+
+// options that change the content of the tape but not the data it loads. each
+// is used with the round trip
+var featureOptions = []ConvertOptions{
+	{BlockOrder: []int{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}},
+	{ByteSwapBlocks: true},
+	{PostHeaderSilenceSeconds: 0.25},
+	{RepeatHeader: true},
+	{AppendParity: true},
+	{AppendResyncMarker: true},
+	{StageMarker: true, LabelBeeps: 3},
+	{FastLoad: true},
+	{ProgressPreset: ProgressSlow},
+	{PhaseOffset: 0.25},
+	{Waveform: WaveformSquare},
+	{Waveform: WaveformTrapezoid, BandLimited: true},
+	{Channels: 2, ChannelMode: ChannelLeft},
+	{Rounding: RoundNearest},
+	{PadToSeconds: 20},
+	{TrailingSilenceSeconds: 2},
+	{SampleRate: 48000},
+	{ResampleTo: 22050},
+	{Dither: true, BitDepth: 16, Seed: 9},
+	{DeviceProfile: ProfileModernSoundcard},
+	{DeviceProfile: ProfileVintageDeck},
+	{DeviceProfile: ProfileEmulator},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		for _, opts := range measureOptions {
+			roundTrip(t, rom, opts)
+		}
+	}
+
+	rom := testROM(4096)
+	for _, opts := range featureOptions {
+		roundTrip(t, rom, opts)
+	}
+}
+
+func TestAssertLoadable(t *testing.T) {
+	rom := testROM(4096)
+
+	var b bytes.Buffer
+	_, err := ConvertWithOptions(rom, &b, Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AssertLoadable(b.Bytes())
+	if err != nil {
+		t.Errorf("output of a conversion is not loadable: %v", err)
+	}
+
+	err = AssertLoadable(corruptWAV(t, rom, 3))
+	if !errors.Is(err, BadChecksum) {
+		t.Errorf("corrupted block is not detected: %v", err)
+	}
+}
+
+func TestToneStats(t *testing.T) {
+	rom := testROM(4096)
+	w := roundTrip(t, rom, ConvertOptions{Float32: true})
+	clean, err := VerifyWAV(bytes.NewReader(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clean.Blocks != 16 {
+		t.Errorf("%d blocks were read", clean.Blocks)
+	}
+
+	// the cycles of a clean tape are all the same length
+	for _, tc := range []struct {
+		name   string
+		stats  CycleStats
+		length float64
+	}{
+		{"zero", clean.ToneStats.Zero, 6},
+		{"one", clean.ToneStats.One, 10},
+	} {
+		if tc.stats.Count == 0 {
+			t.Errorf("no %s cycles were measured", tc.name)
+		}
+		if math.Abs(tc.stats.Mean-tc.length) > 0.01 || tc.stats.Max-tc.stats.Min > 0.1 {
+			t.Errorf("%s cycles of a clean tape: %+v", tc.name, tc.stats)
+		}
+	}
+
+	// the tape is played with a speed that wavers by up to 3%, as it would on
+	// a tape deck with wow and flutter
+	samples := wavSamples(t, w)
+	var jittered []float64
+	for x := 0.0; x < float64(len(samples)-1); x += 1 + 0.03*math.Sin(float64(len(jittered))*2*math.Pi/5000) {
+		i := int(x)
+		f := x - float64(i)
+		jittered = append(jittered, samples[i]*(1-f)+samples[i+1]*f)
+	}
+	var b bytes.Buffer
+	enc := NewWAVEncoder(&b)
+	err = enc.WriteHeader(Format{SampleRate: 44100, Channels: 1, Float32: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteSamples(jittered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := VerifyWAV(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Blocks != 16 {
+		t.Errorf("%d blocks were read from the jittered tape", rep.Blocks)
+	}
+	for _, tc := range []struct {
+		name   string
+		stats  CycleStats
+		length float64
+	}{
+		{"zero", rep.ToneStats.Zero, 6},
+		{"one", rep.ToneStats.One, 10},
+	} {
+		if tc.stats.Max-tc.stats.Min < tc.length*0.04 {
+			t.Errorf("%s cycles of a jittered tape are too close in length: %+v", tc.name, tc.stats)
+		}
+		if tc.stats.Min > tc.length*0.99 || tc.stats.Max < tc.length*1.01 {
+			t.Errorf("%s cycles of a jittered tape do not spread either side of the clean length: %+v", tc.name, tc.stats)
+		}
+	}
+}
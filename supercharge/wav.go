@@ -0,0 +1,320 @@
+package supercharge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// NewWAVEncoder returns an Encoder that writes samples to a WAV file. The
+// samples are unsigned 8 bit values, signed 16 bit values if the BitDepth field
+// of the Format is 16, or 32 bit floating point values if the Float32 field of
+// the Format is set
+//
+// When the Encoder is used by one of the conversion functions the length of
+// the audio is measured before it is generated, and the WAV file is written to
+// the io.Writer as the samples are received. Otherwise the samples are kept
+// until the Encoder is finalized, when the entire WAV file is written
+func NewWAVEncoder(w io.Writer) Encoder {
+	return &wav{w: w}
+}
+
+// wav collects samples and encodes them as a WAV file
+type wav struct {
+	w io.Writer
+
+	format   uint16
+	channels uint16
+	hz       uint32
+	depth    uint16
+
+	// the fmt chunk uses the WAVE_FORMAT_EXTENSIBLE layout. the format field
+	// is the sub-format of the extensible layout
+	extensible bool
+
+	// how sample values are quantized
+	rounding Rounding
+
+	// the frame position of the start of each data packet. the positions
+	// are written to a cue chunk if there are any
+	cues []int
+
+	// if stream is true the length of the audio and the number of cues are
+	// known in advance. the header is written by WriteHeader() and samples
+	// are written as they are received. dataLen counts the bytes of samples
+	// written so far. the samples are buffered before they are written to
+	// the io.Writer
+	stream       bool
+	expectFrames int
+	expectCues   int
+	dataLen      int
+	out          *bufio.Writer
+
+	// the samples of a wav that is not being streamed
+	data bytes.Buffer
+
+	// reusable buffer for the encoded samples
+	buf []byte
+}
+
+// expect implements the sizedEncoder interface
+func (wav *wav) expect(frames int, cues int) {
+	wav.stream = true
+	wav.expectFrames = frames
+	wav.expectCues = cues
+	wav.out = bufio.NewWriter(wav.w)
+}
+
+func (wav *wav) WriteHeader(f Format) error {
+	if f.Channels < 1 || f.Channels > 2 {
+		return fmt.Errorf("wav: unsupported number of channels (%d)", f.Channels)
+	}
+
+	wav.format = 1
+	wav.channels = uint16(f.Channels)
+	wav.hz = f.SampleRate
+	wav.depth = 8
+	wav.rounding = f.Rounding
+	wav.extensible = f.Extensible
+
+	// format 3 is IEEE floating point
+	if f.Float32 {
+		wav.format = 3
+		wav.depth = 32
+	} else if f.BitDepth == 16 {
+		wav.depth = 16
+	}
+
+	if wav.stream {
+		_, err := wav.out.Write(wav.header(wav.expectFrames*int(wav.frameSize()), wav.expectCues))
+		return err
+	}
+
+	return nil
+}
+
+func (wav *wav) WriteSamples(samples []float64) error {
+	// samples are always written as complete frames
+	if len(samples)%int(wav.channels) != 0 {
+		return fmt.Errorf("wav: %d samples is not a whole number of frames", len(samples))
+	}
+
+	wav.buf = wav.encode(wav.buf[:0], samples)
+	if wav.stream {
+		wav.dataLen += len(wav.buf)
+		_, err := wav.out.Write(wav.buf)
+		return err
+	}
+	wav.data.Write(wav.buf)
+	return nil
+}
+
+// encode appends the samples to the buffer in the format of the wav
+func (wav *wav) encode(buf []byte, samples []float64) []byte {
+	if wav.format == 3 {
+		for _, s := range samples {
+			s = math.Max(-1, math.Min(1, s))
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(s)))
+		}
+		return buf
+	}
+
+	if wav.depth == 16 {
+		for _, s := range samples {
+			v := quantize16(s, wav.rounding)
+			buf = append(buf, byte(v), byte(v>>8))
+		}
+		return buf
+	}
+
+	for _, s := range samples {
+		buf = append(buf, quantize8(s, wav.rounding))
+	}
+	return buf
+}
+
+// frameSize returns the number of bytes in a frame. a frame is one sample for
+// each channel
+func (wav *wav) frameSize() uint16 {
+	return wav.channels * wav.depth / 8
+}
+
+func (wav *wav) cue(frame int) {
+	wav.cues = append(wav.cues, frame)
+}
+
+func (wav *wav) Finalize() error {
+	if wav.stream {
+		// the header has already been written so the audio must be exactly
+		// the length that was expected
+		expected := wav.expectFrames * int(wav.frameSize())
+		if wav.dataLen != expected {
+			return fmt.Errorf("wav: %d bytes of samples written but %d bytes were expected", wav.dataLen, expected)
+		}
+		if len(wav.cues) != wav.expectCues {
+			return fmt.Errorf("wav: %d cues written but %d were expected", len(wav.cues), wav.expectCues)
+		}
+		_, err := wav.out.Write(wav.trailer(wav.dataLen))
+		if err != nil {
+			return err
+		}
+		return wav.out.Flush()
+	}
+
+	_, err := wav.w.Write(wav.Bytes())
+	return err
+}
+
+// size returns the number of bytes in the WAV file. it is the same as the
+// length of the slice returned by Bytes()
+func (wav *wav) size() int64 {
+	dataLen := wav.dataLen
+	if !wav.stream {
+		dataLen = wav.data.Len()
+	}
+	return wav.sizeFor(dataLen, len(wav.cues))
+}
+
+// sizeFor returns the number of bytes in a WAV file with the given number of
+// bytes of samples and number of cues
+func (wav *wav) sizeFor(dataLen int, cues int) int64 {
+	// RIFF header and the fmt and data chunks
+	n := 12 + 8 + 16 + 8 + dataLen
+	if wav.extensible {
+		// fmt chunk extension and fact chunk
+		n += 2 + 22 + 12
+	} else if wav.format != 1 {
+		// fmt chunk extension and fact chunk
+		n += 2 + 12
+	}
+	if cues > 0 {
+		n += dataLen&1 + 8 + 4 + cues*24
+	}
+
+	return int64(n)
+}
+
+func (wav *wav) Bytes() []byte {
+	var w bytes.Buffer
+	w.Write(wav.header(wav.data.Len(), len(wav.cues)))
+	w.Write(wav.data.Bytes())
+	w.Write(wav.trailer(wav.data.Len()))
+	return w.Bytes()
+}
+
+// header returns the part of the WAV file that comes before the samples, for a
+// file with the given number of bytes of samples and number of cues
+func (wav *wav) header(dataLen int, cues int) []byte {
+	var w bytes.Buffer
+
+	// prepare format sub-chunk
+	var fmtSubChunk bytes.Buffer
+	tag := wav.format
+	if wav.extensible {
+		tag = formatExtensible
+	}
+	fmtSubChunk.Write([]byte{byte(tag), byte(tag >> 8)})
+	fmtSubChunk.Write([]byte{byte(wav.channels), byte(wav.channels >> 8)})
+	fmtSubChunk.Write([]byte{byte(wav.hz), byte(wav.hz >> 8), byte(wav.hz >> 16), byte(wav.hz >> 24)})
+
+	// the byte rate is the number of bytes in one second of audio and the
+	// block align is the number of bytes in a single frame
+	blockAlign := wav.frameSize()
+	byteRate := wav.hz * uint32(blockAlign)
+	fmtSubChunk.Write([]byte{byte(byteRate), byte(byteRate >> 8), byte(byteRate >> 16), byte(byteRate >> 24)})
+	fmtSubChunk.Write([]byte{byte(blockAlign), byte(blockAlign >> 8)})
+	fmtSubChunk.Write([]byte{byte(wav.depth), byte(wav.depth >> 8)})
+
+	// the extension to the fmt chunk of the extensible layout has the number
+	// of valid bits in each sample, the speaker positions of the channels and
+	// the GUID of the sub-format. formats other than PCM have a (zero
+	// length) extension
+	if wav.extensible {
+		mask := speakerFrontCenter
+		if wav.channels == 2 {
+			mask = speakerFrontLeft | speakerFrontRight
+		}
+		fmtSubChunk.Write([]byte{22, 0})
+		fmtSubChunk.Write([]byte{byte(wav.depth), byte(wav.depth >> 8)})
+		fmtSubChunk.Write([]byte{byte(mask), byte(mask >> 8), byte(mask >> 16), byte(mask >> 24)})
+		fmtSubChunk.Write([]byte{byte(wav.format), byte(wav.format >> 8)})
+		fmtSubChunk.Write(subFormatGUID[:])
+	} else if wav.format != 1 {
+		fmtSubChunk.Write([]byte{0, 0})
+	}
+
+	// write RIFF header followed by the size of the rest of the file
+	w.Write([]byte("RIFF"))
+	l := int(wav.sizeFor(dataLen, cues)) - 8
+	w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
+
+	// wave chunk using format and data sub-chunks
+	w.Write([]byte("WAVE"))
+	w.Write([]byte("fmt "))
+	l = fmtSubChunk.Len()
+	w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
+	w.Write(fmtSubChunk.Bytes())
+
+	// formats other than PCM require a fact chunk containing the number of
+	// sample frames. the extensible format is not PCM
+	if wav.format != 1 || wav.extensible {
+		w.Write([]byte("fact"))
+		w.Write([]byte{4, 0, 0, 0})
+		l = dataLen / int(wav.frameSize())
+		w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
+	}
+
+	// the samples follow the data sub-chunk header
+	w.Write([]byte("data"))
+	l = dataLen
+	w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
+
+	return w.Bytes()
+}
+
+// the format code of the WAVE_FORMAT_EXTENSIBLE layout of the fmt chunk
+const formatExtensible uint16 = 0xfffe
+
+// the speaker positions used in the channel mask of the extensible layout
+const (
+	speakerFrontLeft   = 0x1
+	speakerFrontRight  = 0x2
+	speakerFrontCenter = 0x4
+)
+
+// the sub-format GUID of the extensible layout follows the two byte format
+// code of the samples. the remaining 14 bytes are the same for all formats
+var subFormatGUID = [14]byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// trailer returns the part of the WAV file that comes after the samples
+func (wav *wav) trailer(dataLen int) []byte {
+	var w bytes.Buffer
+
+	// prepare cue sub-chunk with one cue point for each entry in the cues
+	// field. each cue point is 24 bytes
+	if len(wav.cues) > 0 {
+		// chunks must start on an even byte boundary
+		if dataLen&1 == 1 {
+			w.WriteByte(0)
+		}
+		w.Write([]byte("cue "))
+		l := 4 + len(wav.cues)*24
+		w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
+		l = len(wav.cues)
+		w.Write([]byte{byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)})
+		for i, c := range wav.cues {
+			id := i + 1
+			w.Write([]byte{byte(id), byte(id >> 8), byte(id >> 16), byte(id >> 24)})
+			w.Write([]byte{byte(c), byte(c >> 8), byte(c >> 16), byte(c >> 24)})
+			w.Write([]byte("data"))
+			w.Write([]byte{0, 0, 0, 0})
+			w.Write([]byte{0, 0, 0, 0})
+			w.Write([]byte{byte(c), byte(c >> 8), byte(c >> 16), byte(c >> 24)})
+		}
+	}
+
+	return w.Bytes()
+}
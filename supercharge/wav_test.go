@@ -0,0 +1,275 @@
+package supercharge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// wavChunk returns the body of the first chunk in the WAV file with the ID
+func wavChunk(t *testing.T, data []byte, id string) []byte {
+	t.Helper()
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Fatalf("not a WAV file")
+	}
+	if l := int(binary.LittleEndian.Uint32(data[4:8])); l != len(data)-8 {
+		t.Fatalf("RIFF size is %d but the file is %d bytes", l, len(data))
+	}
+	for p := 12; p+8 <= len(data); {
+		l := int(binary.LittleEndian.Uint32(data[p+4 : p+8]))
+		if string(data[p:p+4]) == id {
+			return data[p+8 : p+8+l]
+		}
+		p += 8 + l + l&1
+	}
+	t.Fatalf("no %q chunk", id)
+	return nil
+}
+
+func TestWAVFormatChunk(t *testing.T) {
+	for _, tc := range []struct {
+		channels int
+		depth    int
+	}{
+		{1, 8}, {2, 8}, {1, 16}, {2, 16},
+	} {
+		for _, stream := range []bool{false, true} {
+			const frames = 100
+			var b bytes.Buffer
+			enc := NewWAVEncoder(&b)
+			if stream {
+				enc.(sizedEncoder).expect(frames, 0)
+			}
+			err := enc.WriteHeader(Format{SampleRate: 44100, Channels: tc.channels, BitDepth: tc.depth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = enc.WriteSamples(make([]float64, frames*tc.channels))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = enc.Finalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f := wavChunk(t, b.Bytes(), "fmt ")
+			if len(f) != 16 {
+				t.Fatalf("%d channels, %d bits: fmt chunk is %d bytes", tc.channels, tc.depth, len(f))
+			}
+			format := binary.LittleEndian.Uint16(f[0:2])
+			channels := binary.LittleEndian.Uint16(f[2:4])
+			rate := binary.LittleEndian.Uint32(f[4:8])
+			byteRate := binary.LittleEndian.Uint32(f[8:12])
+			blockAlign := binary.LittleEndian.Uint16(f[12:14])
+			bits := binary.LittleEndian.Uint16(f[14:16])
+
+			align := tc.channels * tc.depth / 8
+			if format != 1 || int(channels) != tc.channels || rate != 44100 {
+				t.Errorf("%d channels, %d bits: format %d, %d channels, %dHz", tc.channels, tc.depth, format, channels, rate)
+			}
+			if int(blockAlign) != align {
+				t.Errorf("%d channels, %d bits: block align is %d but should be %d", tc.channels, tc.depth, blockAlign, align)
+			}
+			if int(byteRate) != 44100*align {
+				t.Errorf("%d channels, %d bits: byte rate is %d but should be %d", tc.channels, tc.depth, byteRate, 44100*align)
+			}
+			if int(bits) != tc.depth {
+				t.Errorf("%d channels, %d bits: bits per sample is %d", tc.channels, tc.depth, bits)
+			}
+
+			data := wavChunk(t, b.Bytes(), "data")
+			if len(data) != frames*align {
+				t.Errorf("%d channels, %d bits: data chunk is %d bytes but should be %d", tc.channels, tc.depth, len(data), frames*align)
+			}
+		}
+	}
+}
+
+func TestWAVSamples(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewWAVEncoder(&b)
+	err := enc.WriteHeader(Format{SampleRate: 44100, Channels: 2, BitDepth: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.WriteSamples([]float64{-1, 0, 1, -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signed little-endian samples. full scale positive is one step short of
+	// the maximum in the same way as 8 bit samples
+	expected := []byte{0x00, 0x80, 0x00, 0x00, 0xff, 0x7f, 0x00, 0x80}
+	data := wavChunk(t, b.Bytes(), "data")
+	if !bytes.Equal(data, expected) {
+		t.Errorf("samples are % x but should be % x", data, expected)
+	}
+}
+
+// wavSamples returns the samples of the WAV file in the range -1 to +1. 8 bit,
+// 16 bit and 32 bit floating point samples are read. the samples of each
+// channel are interleaved
+func wavSamples(t *testing.T, data []byte) []float64 {
+	t.Helper()
+	bits := binary.LittleEndian.Uint16(wavChunk(t, data, "fmt ")[14:16])
+	d := wavChunk(t, data, "data")
+	var samples []float64
+	switch bits {
+	case 8:
+		for _, b := range d {
+			samples = append(samples, float64(b)/128-1)
+		}
+	case 16:
+		for i := 0; i+2 <= len(d); i += 2 {
+			samples = append(samples, float64(int16(binary.LittleEndian.Uint16(d[i:])))/32768)
+		}
+	case 32:
+		for i := 0; i+4 <= len(d); i += 4 {
+			samples = append(samples, float64(math.Float32frombits(binary.LittleEndian.Uint32(d[i:]))))
+		}
+	default:
+		t.Fatalf("unsupported bits per sample (%d)", bits)
+	}
+	return samples
+}
+
+func TestWAVCueChunk(t *testing.T) {
+	for _, size := range []int{2048, 4096, 6144} {
+		rom := testROM(size)
+		for _, opts := range []ConvertOptions{
+			{CueChunk: true},
+			{CueChunk: true, Channels: 2, BitDepth: 16},
+			{CueChunk: true, ResampleTo: 48000},
+		} {
+			w := roundTrip(t, rom, opts)
+			c := wavChunk(t, w, "cue ")
+
+			// one cue point for each data packet
+			count := int(binary.LittleEndian.Uint32(c[0:4]))
+			if count != size/256 {
+				t.Fatalf("%d bytes: %d cue points but there are %d blocks", size, count, size/256)
+			}
+			if len(c) != 4+count*24 {
+				t.Fatalf("%d bytes: cue chunk is %d bytes", size, len(c))
+			}
+
+			// the packets include the header packet, which has no cue point
+			rec := &packetRecorder{packets: make(map[int]bool)}
+			_, err := ConvertEncoder(rom, rec, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			format := OutputFormat(opts)
+			frames := len(wavChunk(t, w, "data")) / (format.Channels * format.BitDepth / 8)
+			prev := -1
+			for i := 0; i < count; i++ {
+				p := c[4+i*24:]
+				id := binary.LittleEndian.Uint32(p[0:4])
+				pos := int(binary.LittleEndian.Uint32(p[4:8]))
+				if id != uint32(i+1) {
+					t.Errorf("%d bytes: cue point %d has id %d", size, i, id)
+				}
+				if string(p[8:12]) != "data" || binary.LittleEndian.Uint32(p[12:16]) != 0 || binary.LittleEndian.Uint32(p[16:20]) != 0 {
+					t.Errorf("%d bytes: cue point %d does not refer to the data chunk", size, i)
+				}
+				if int(binary.LittleEndian.Uint32(p[20:24])) != pos {
+					t.Errorf("%d bytes: cue point %d has a sample offset different to its position", size, i)
+				}
+				if pos <= prev || pos >= frames {
+					t.Errorf("%d bytes: cue point %d is at frame %d after %d (%d frames)", size, i, pos, prev, frames)
+				}
+				if !rec.packets[pos] {
+					t.Errorf("%d bytes: cue point %d at frame %d is not at the start of a packet", size, i, pos)
+				}
+				prev = pos
+			}
+		}
+	}
+}
+
+func TestWAVFloat32(t *testing.T) {
+	for _, channels := range []int{1, 2} {
+		for _, extensible := range []bool{false, true} {
+			for _, stream := range []bool{false, true} {
+				const frames = 101
+				var b bytes.Buffer
+				enc := NewWAVEncoder(&b)
+				if stream {
+					enc.(sizedEncoder).expect(frames, 0)
+				}
+				err := enc.WriteHeader(Format{SampleRate: 44100, Channels: channels, Float32: true, Extensible: extensible})
+				if err != nil {
+					t.Fatal(err)
+				}
+				samples := make([]float64, frames*channels)
+				for i := range samples {
+					samples[i] = float64(i%9)/4 - 1
+				}
+				err = enc.WriteSamples(samples)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = enc.Finalize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data := b.Bytes()
+
+				// the format tag of floating point samples is 3. in the
+				// extensible layout the tag is the first two bytes of the
+				// sub-format GUID
+				f := wavChunk(t, data, "fmt ")
+				tag := binary.LittleEndian.Uint16(f[0:2])
+				size := 18
+				if extensible {
+					size = 40
+					if tag != formatExtensible || binary.LittleEndian.Uint16(f[24:26]) != 3 {
+						t.Errorf("%d channels, extensible: format %04x and sub-format %04x", channels, tag, binary.LittleEndian.Uint16(f[24:26]))
+					}
+				} else if tag != 3 {
+					t.Errorf("%d channels: format is %d but should be 3", channels, tag)
+				}
+				if len(f) != size || binary.LittleEndian.Uint16(f[16:18]) != uint16(size-18) {
+					t.Errorf("%d channels, extensible %v: fmt chunk is %d bytes with an extension of %d bytes", channels, extensible, len(f), binary.LittleEndian.Uint16(f[16:18]))
+				}
+				if binary.LittleEndian.Uint16(f[12:14]) != uint16(4*channels) || binary.LittleEndian.Uint16(f[14:16]) != 32 {
+					t.Errorf("%d channels, extensible %v: block align %d and %d bits per sample", channels, extensible, binary.LittleEndian.Uint16(f[12:14]), binary.LittleEndian.Uint16(f[14:16]))
+				}
+
+				// the fact chunk is the number of frames
+				fact := wavChunk(t, data, "fact")
+				if len(fact) != 4 || binary.LittleEndian.Uint32(fact) != frames {
+					t.Errorf("%d channels, extensible %v: fact chunk is % x", channels, extensible, fact)
+				}
+				if binary.LittleEndian.Uint32(data[4:8]) != uint32(len(data)-8) {
+					t.Errorf("%d channels, extensible %v: RIFF size is %d for a file of %d bytes", channels, extensible, binary.LittleEndian.Uint32(data[4:8]), len(data))
+				}
+
+				// the samples are stored without quantization
+				s := wavSamples(t, data)
+				if len(s) != len(samples) {
+					t.Fatalf("%d channels, extensible %v: %d samples", channels, extensible, len(s))
+				}
+				for i := range s {
+					if s[i] != samples[i] {
+						t.Fatalf("%d channels, extensible %v: sample %d is %f but should be %f", channels, extensible, i, s[i], samples[i])
+					}
+				}
+			}
+		}
+	}
+
+	// the fact chunk of a conversion
+	w := roundTrip(t, testROM(4096), ConvertOptions{Float32: true, Channels: 2})
+	frames := len(wavChunk(t, w, "data")) / 8
+	if binary.LittleEndian.Uint32(wavChunk(t, w, "fact")) != uint32(frames) {
+		t.Errorf("fact chunk is %d frames but the data chunk is %d frames", binary.LittleEndian.Uint32(wavChunk(t, w, "fact")), frames)
+	}
+}
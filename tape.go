@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// processTape converts every file into a single output file, one program after
+// another, in the same way as a cassette with several games on one side. the
+// gap between programs is the same as the gap between the loads of a
+// multiload game
+//
+// every file is read and validated before the output file is created
+func processTape(ctx context, tapeFile string, files []string) ([]supercharge.ConvertReport, error) {
+	var roms [][]byte
+	for _, f := range files {
+		rom, err := os.ReadFile(f)
+		if err != nil {
+			return nil, inputError{fmt.Errorf("%s: %w", filepath.Base(f), err)}
+		}
+
+		// the rom is validated as it will be converted, after it has been
+		// trimmed. the conversion does the trimming itself so the rom is not
+		// changed here
+		trimmed, err := supercharge.TrimROM(rom, ctx.options())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		err = supercharge.Validate(trimmed)
+		if err != nil {
+			return nil, skipError{fmt.Errorf("%s skipped: %w", filepath.Base(f), err)}
+		}
+		roms = append(roms, rom)
+	}
+
+	if ctx.exists(tapeFile) {
+		return nil, skipError{fmt.Errorf("%s already exists", filepath.Base(tapeFile))}
+	}
+
+	w, err := ctx.create(tapeFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(tapeFile), err)
+	}
+	defer w.Close()
+
+	reps, err := supercharge.ConvertAll(roms, w, ctx.options())
+	if err != nil {
+		// nothing is written if a rom can not be converted. the file is
+		// removed rather than left empty
+		ctx.remove(w, tapeFile)
+		return nil, fmt.Errorf("%s: %w", filepath.Base(tapeFile), err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(tapeFile), err)
+	}
+
+	return reps, nil
+}
+
+// tapeIndex writes the position of each program on the tape, in the style of
+// the index card of a cassette
+func (ctx context) tapeIndex(files []string, reps []supercharge.ConvertReport) {
+	if ctx.json || ctx.quiet {
+		return
+	}
+	ctx.Write([]byte("index\n"))
+	for i, rep := range reps {
+		m := int(rep.Offset) / 60
+		s := rep.Offset - float64(m*60)
+		ctx.Write([]byte(fmt.Sprintf("\t%d:%05.2f %s (sample %d)\n", m, s, filepath.Base(files[i]), rep.OffsetSamples)))
+	}
+}
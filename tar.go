@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tarArchive collects output files as entries in a tar archive
+type tarArchive struct {
+	w *tar.Writer
+
+	// the names of the entries that have been added to the archive
+	names map[string]bool
+}
+
+// newTarArchive returns a tarArchive that writes to the io.Writer
+func newTarArchive(w io.Writer) *tarArchive {
+	return &tarArchive{
+		w:     tar.NewWriter(w),
+		names: make(map[string]bool),
+	}
+}
+
+// tarEntry collects the data for an entry in the archive. the entry is added
+// to the archive when it is closed
+type tarEntry struct {
+	archive *tarArchive
+	name    string
+	data    bytes.Buffer
+	closed  bool
+}
+
+func (e *tarEntry) Write(p []byte) (int, error) {
+	return e.data.Write(p)
+}
+
+// Close adds the entry to the archive. an empty entry is not added. this means
+// that output that was not written because of an error does not appear in the
+// archive
+func (e *tarEntry) Close() error {
+	if e.closed || e.data.Len() == 0 {
+		return nil
+	}
+	e.closed = true
+
+	err := e.archive.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     e.name,
+		Mode:     0644,
+		Size:     int64(e.data.Len()),
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = e.archive.w.Write(e.data.Bytes())
+	if err != nil {
+		return err
+	}
+	e.archive.names[e.name] = true
+	return nil
+}
+
+// create creates the named output file. if output is to a tar archive the file
+// is an entry in the archive, named with the base name of the file
+func (ctx context) create(name string) (io.WriteCloser, error) {
+	if ctx.archive != nil {
+		return &tarEntry{archive: ctx.archive, name: filepath.Base(name)}, nil
+	}
+	return os.Create(name)
+}
+
+// remove closes and deletes an output file created by create() that could not
+// be completed. an entry in a tar archive is discarded
+func (ctx context) remove(w io.WriteCloser, name string) {
+	if e, ok := w.(*tarEntry); ok {
+		e.data.Reset()
+		e.Close()
+		return
+	}
+	w.Close()
+	os.Remove(name)
+}
+
+// exists returns true if the named output file should not be created because
+// it already exists. files that exist on disk are overwritten if the overwrite
+// option is set. entries in a tar archive are never overwritten
+func (ctx context) exists(name string) bool {
+	if ctx.archive != nil {
+		return ctx.archive.names[filepath.Base(name)]
+	}
+	if ctx.overwrite {
+		return false
+	}
+	_, err := os.Stat(name)
+	return err == nil || !os.IsNotExist(err)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+func TestTar(t *testing.T) {
+	dir := t.TempDir()
+	roms := map[string][]byte{
+		"game1.wav": testROM(2048),
+		"game2.wav": testROM(4096),
+		"game3.wav": testROM(6144),
+	}
+	writeFile(t, dir, "game1.bin", roms["game1.wav"])
+	writeFile(t, dir, "game2.bin", roms["game2.wav"])
+	err := os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, filepath.Join("sub", "game3.bin"), roms["game3.wav"])
+
+	// the ROMs are found with the recursive option. the entries of the archive
+	// are named with the base name of the output file
+	_, stderr, code := runMain(t, dir, nil, "-q", "-tar", "out.tar", "-r", ".")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(readFile(t, dir, "out.tar")))
+	entries := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rom, ok := roms[hdr.Name]
+		if !ok || entries[hdr.Name] {
+			t.Errorf("unexpected entry in archive: %s", hdr.Name)
+			continue
+		}
+		entries[hdr.Name] = true
+		if hdr.Typeflag != tar.TypeReg || hdr.Size != int64(len(data)) {
+			t.Errorf("%s: entry is type %c with a size of %d", hdr.Name, hdr.Typeflag, hdr.Size)
+		}
+
+		// the entry is a complete WAV file of the ROM
+		if len(data) < 44 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[12:16]) != "fmt " {
+			t.Errorf("%s: entry does not have a WAV header", hdr.Name)
+			continue
+		}
+		if binary.LittleEndian.Uint32(data[4:8]) != uint32(len(data)-8) {
+			t.Errorf("%s: RIFF size is %d for an entry of %d bytes", hdr.Name, binary.LittleEndian.Uint32(data[4:8]), len(data))
+		}
+		if hz := binary.LittleEndian.Uint32(data[24:28]); hz != 44100 {
+			t.Errorf("%s: sample rate is %dHz", hdr.Name, hz)
+		}
+		decoded, err := supercharge.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", hdr.Name, err)
+			continue
+		}
+		if !bytes.Equal(decoded, rom) {
+			t.Errorf("%s: entry decodes to data different to the ROM", hdr.Name)
+		}
+	}
+	if len(entries) != len(roms) {
+		t.Errorf("archive has %d entries, expected %d", len(entries), len(roms))
+	}
+
+	// no output files are written next to the ROM files
+	for _, name := range []string{"game1.wav", "game2.wav", filepath.Join("sub", "game3.wav")} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if err == nil {
+			t.Errorf("%s written outside of the archive", name)
+		}
+	}
+
+	// an existing archive is not replaced without the overwrite option
+	_, _, code = runMain(t, dir, nil, "-q", "-tar", "out.tar", "game1.bin")
+	if code == 0 {
+		t.Errorf("existing archive replaced without the overwrite option")
+	}
+}
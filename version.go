@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/jetsetilly/supercharge/supercharge"
+)
+
+// version returns the version of the program and the default parameters of the
+// output. the version is taken from the build information of the executable
+func version() string {
+	var s strings.Builder
+
+	ver := "unknown"
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		ver = info.Main.Version
+
+		// development builds do not have a version number. the revision
+		// is used instead if it is known
+		if ver == "(devel)" {
+			for _, set := range info.Settings {
+				if set.Key == "vcs.revision" {
+					ver = fmt.Sprintf("%s %s", ver, set.Value)
+				}
+			}
+		}
+	}
+	s.WriteString(fmt.Sprintf("supercharge %s\n", ver))
+	if ok {
+		s.WriteString(fmt.Sprintf("go version: %s\n", info.GoVersion))
+	}
+
+	opts := supercharge.Default()
+	f := supercharge.OutputFormat(opts)
+	samples := fmt.Sprintf("%d bit unsigned", f.BitDepth)
+	if f.Float32 {
+		samples = "32 bit float"
+	} else if f.BitDepth == 16 {
+		samples = "16 bit signed"
+	}
+	channels := "mono"
+	if f.Channels == 2 {
+		channels = "stereo"
+	}
+	s.WriteString(fmt.Sprintf("sample rate: %d Hz\n", f.SampleRate))
+	s.WriteString(fmt.Sprintf("format: %s %s\n", samples, channels))
+
+	start, zero, one := supercharge.ToneFrequencies(opts)
+	s.WriteString(fmt.Sprintf("start tone: %.2f Hz\n", start))
+	s.WriteString(fmt.Sprintf("zero bit: %.2f Hz\n", zero))
+	s.WriteString(fmt.Sprintf("one bit: %.2f Hz\n", one))
+
+	return s.String()
+}